@@ -0,0 +1,518 @@
+package main
+
+import (
+	"crypto/tls"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+func adminHandler(store *recordStore, recorders []*recorder) http.Handler {
+	ui, _ := fs.Sub(uiFiles, "ui")
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServerFS(ui))
+	mux.HandleFunc("GET /metrics", metricsHandler(store))
+	mux.HandleFunc("GET /api/records", listRecords(store))
+	mux.HandleFunc("DELETE /api/records", clearRecords(store))
+	mux.HandleFunc("GET /api/events", streamRecords(store))
+	mux.HandleFunc("GET /api/records/{seq}", getRecord(store))
+	mux.HandleFunc("DELETE /api/records/{seq}", deleteRecord(store))
+	mux.HandleFunc("GET /api/records/{seq}/files/{name}", getRecordFile(store))
+	mux.HandleFunc("POST /api/records/{seq}/replay", replayRecord(store))
+	mux.HandleFunc("GET /api/sequence", getSequence(store))
+	mux.HandleFunc("PUT /api/sequence", setSequence(store))
+	mux.HandleFunc("GET /api/listeners", listListeners(recorders))
+	mux.HandleFunc("GET /api/responder", getResponder(recorders))
+	mux.HandleFunc("PUT /api/responder", setResponder(recorders))
+	mux.HandleFunc("POST /api/scenarios/reset", resetScenarios(recorders))
+	mux.HandleFunc("POST /api/scenarios/{name}/reset", resetScenarios(recorders))
+	return sameOrigin(mux)
+}
+
+// sameOrigin rejects requests changing state which a browser sends from
+// another site, the admin api has no authentication of its own.
+func sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			origin = r.Header.Get("Referer")
+		}
+		if origin != "" {
+			uri, err := url.Parse(origin)
+			if err != nil || !strings.EqualFold(uri.Host, r.Host) {
+				writeJsonError(w, http.StatusForbidden, "cross origin request")
+				return
+			}
+		}
+		if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+			writeJsonError(w, http.StatusForbidden, "cross origin request")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type recordSummary struct {
+	Seq      int    `json:"seq"`
+	File     string `json:"file"`
+	Listener string `json:"listener,omitempty"`
+	Method   string `json:"method"`
+	URL      string `json:"url"`
+	Time     string `json:"time"`
+	Status   int    `json:"status,omitempty"`
+}
+
+func summarize(entry *recordEntry) *recordSummary {
+	return &recordSummary{
+		Seq:      entry.Seq,
+		File:     entry.File,
+		Listener: entry.Record.Listener,
+		Method:   entry.Record.Method,
+		URL:      entry.Record.URL,
+		Time:     entry.Record.Time,
+		Status:   entry.Record.status(),
+	}
+}
+
+// recordFilter selects records by listener, method, a path substring and a
+// status pattern such as '404' or '5xx'.
+type recordFilter struct {
+	Listener string
+	Method   string
+	Path     string
+	Status   string
+}
+
+func (f *recordFilter) match(record *Record) bool {
+	if f.Listener != "" && f.Listener != record.Listener {
+		return false
+	}
+	if f.Method != "" && !strings.EqualFold(f.Method, record.Method) {
+		return false
+	}
+	if f.Path != "" && !strings.Contains(record.URL, f.Path) {
+		return false
+	}
+	if f.Status != "" && !matchStatus(f.Status, record.status()) {
+		return false
+	}
+	return true
+}
+
+func queryFilter(query url.Values) *recordFilter {
+	return &recordFilter{
+		Listener: query.Get("listener"),
+		Method:   query.Get("method"),
+		Path:     query.Get("path"),
+		Status:   query.Get("status"),
+	}
+}
+
+func matchStatus(pattern string, status int) bool {
+	s := strconv.Itoa(status)
+	if len(pattern) != len(s) {
+		return false
+	}
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != 'x' && pattern[i] != 'X' && pattern[i] != s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func listRecords(store *recordStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := queryFilter(query)
+		after, _ := strconv.Atoi(query.Get("after"))
+
+		summaries := []*recordSummary{}
+		for _, entry := range store.list() {
+			if entry.Seq <= after || !filter.match(entry.Record) {
+				continue
+			}
+			summaries = append(summaries, summarize(entry))
+		}
+		writeJson(w, http.StatusOK, summaries)
+	}
+}
+
+// streamRecords sends every new record as a server-sent event. Records after
+// the 'after' parameter or the Last-Event-ID header are sent first.
+func streamRecords(store *recordStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeJsonError(w, http.StatusInternalServerError, "streaming is not supported")
+			return
+		}
+
+		query := r.URL.Query()
+		filter := queryFilter(query)
+		after := -1
+		if v := r.Header.Get("Last-Event-ID"); v != "" {
+			after, _ = strconv.Atoi(v)
+		} else if v := query.Get("after"); v != "" {
+			after, _ = strconv.Atoi(v)
+		}
+
+		// subscribe before reading the backlog, so nothing is missed in between
+		entries, cancel := store.subscribe()
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		// records are not always stored in sequence order, so remember what
+		// the backlog has sent instead of comparing sequence numbers
+		sent := make(map[int]bool)
+		send := func(entry *recordEntry) error {
+			if sent[entry.Seq] || !filter.match(entry.Record) {
+				return nil
+			}
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: record\ndata: %s\n\n", entry.Seq, data); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		}
+
+		if after >= 0 {
+			for _, entry := range store.list() {
+				if entry.Seq <= after {
+					continue
+				}
+				if err := send(entry); err != nil {
+					return
+				}
+				sent[entry.Seq] = true
+			}
+		}
+
+		keepAlive := time.NewTicker(15 * time.Second)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case entry := <-entries:
+				if err := send(entry); err != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func getRecord(store *recordStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := pathEntry(w, r, store)
+		if entry == nil {
+			return
+		}
+		writeJson(w, http.StatusOK, entry)
+	}
+}
+
+func deleteRecord(store *recordStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := pathEntry(w, r, store)
+		if entry == nil {
+			return
+		}
+
+		store.remove(entry.Seq)
+		if err := entry.removeFiles(); err != nil {
+			writeJsonError(w, http.StatusInternalServerError, fmt.Sprintf("failed to delete files: %s", err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// clearRecords deletes every record, the sequence counter is reset as well
+// when the 'reset' parameter is set.
+func clearRecords(store *recordStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries := store.clear()
+		for _, entry := range entries {
+			if err := entry.removeFiles(); err != nil {
+				writeJsonError(w, http.StatusInternalServerError, fmt.Sprintf("failed to delete files: %s", err))
+				return
+			}
+		}
+		if reset, _ := strconv.ParseBool(r.URL.Query().Get("reset")); reset {
+			store.resetSeq(0)
+		}
+		writeJson(w, http.StatusOK, map[string]int{"deleted": len(entries)})
+	}
+}
+
+type sequence struct {
+	Seq int `json:"seq"`
+}
+
+func getSequence(store *recordStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, &sequence{Seq: store.currentSeq()})
+	}
+}
+
+// setSequence sets the number of the last record, the next record gets seq+1.
+func setSequence(store *recordStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var seq sequence
+		if err := json.NewDecoder(r.Body).Decode(&seq); err != nil {
+			writeJsonError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode json: %s", err))
+			return
+		}
+		if seq.Seq < 0 {
+			writeJsonError(w, http.StatusBadRequest, "seq must not be negative")
+			return
+		}
+		store.resetSeq(seq.Seq)
+		writeJson(w, http.StatusOK, &seq)
+	}
+}
+
+type listenerInfo struct {
+	Name string `json:"name"`
+	Save string `json:"save"`
+}
+
+func listListeners(recorders []*recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listeners := []*listenerInfo{}
+		for _, rec := range recorders {
+			listeners = append(listeners, &listenerInfo{Name: rec.name, Save: rec.saveDir})
+		}
+		writeJson(w, http.StatusOK, listeners)
+	}
+}
+
+// queryRecorder picks the recorder named by the 'listener' parameter, the
+// first one by default.
+func queryRecorder(w http.ResponseWriter, r *http.Request, recorders []*recorder) *recorder {
+	name := r.URL.Query().Get("listener")
+	if name == "" {
+		return recorders[0]
+	}
+	for _, rec := range recorders {
+		if rec.name == name {
+			return rec
+		}
+	}
+	writeJsonError(w, http.StatusNotFound, fmt.Sprintf("listener '%s' not found", name))
+	return nil
+}
+
+func getResponder(recorders []*recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := queryRecorder(w, r, recorders)
+		if rec == nil {
+			return
+		}
+		writeJson(w, http.StatusOK, rec.responder.Load().config)
+	}
+}
+
+func setResponder(recorders []*recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := queryRecorder(w, r, recorders)
+		if rec == nil {
+			return
+		}
+
+		var cfg responderConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			writeJsonError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode json: %s", err))
+			return
+		}
+		// the admin port has no login, it must not open other files
+		for _, path := range []string{cfg.Playback, cfg.WWWRoot, cfg.Scenario} {
+			if path != "" && !rec.allowedPath(path) {
+				writeJsonError(w, http.StatusForbidden, fmt.Sprintf("'%s' is outside the configured directories", path))
+				return
+			}
+		}
+		if err := rec.setResponder(&cfg); err != nil {
+			writeJsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("Responder changed through admin api")
+		writeJson(w, http.StatusOK, &cfg)
+	}
+}
+
+func resetScenarios(recorders []*recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := queryRecorder(w, r, recorders)
+		if rec == nil {
+			return
+		}
+
+		scenarios := rec.responder.Load().scenarios
+		name := r.PathValue("name")
+		if scenarios == nil || !scenarios.Reset(name) {
+			writeJsonError(w, http.StatusNotFound, "no matching scenario")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func getRecordFile(store *recordStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := pathEntry(w, r, store)
+		if entry == nil {
+			return
+		}
+
+		name := r.PathValue("name")
+		if !slices.Contains(entry.files(), name) {
+			writeJsonError(w, http.StatusNotFound, fmt.Sprintf("record %d has no file %s", entry.Seq, name))
+			return
+		}
+
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		http.ServeFile(w, r, filepath.Join(entry.Dir, name))
+	}
+}
+
+type replayRequest struct {
+	Target   string `json:"target"`
+	Insecure bool   `json:"insecure"`
+}
+
+type replayResult struct {
+	Status   int    `json:"status"`
+	Header   Header `json:"header"`
+	Body     string `json:"body"`
+	Duration string `json:"duration"`
+}
+
+func replayRecord(store *recordStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := pathEntry(w, r, store)
+		if entry == nil {
+			return
+		}
+
+		// a form post from another page can not send json
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			writeJsonError(w, http.StatusUnsupportedMediaType, "content type must be application/json")
+			return
+		}
+
+		var replay replayRequest
+		if err := json.NewDecoder(r.Body).Decode(&replay); err != nil {
+			writeJsonError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode json: %s", err))
+			return
+		}
+		if !strings.Contains(replay.Target, "://") {
+			replay.Target = "http://" + replay.Target
+		}
+		uri, err := url.Parse(replay.Target)
+		if err != nil || uri.Host == "" {
+			writeJsonError(w, http.StatusBadRequest, fmt.Sprintf("invalid target '%s'", replay.Target))
+			return
+		}
+
+		req, err := newRequest(entry.Record, uri, entry.Dir)
+		if err != nil {
+			writeJsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if replay.Insecure {
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		client := &http.Client{Transport: transport, Timeout: 30 * time.Second}
+		defer client.CloseIdleConnections()
+
+		start := time.Now()
+		resp, err := client.Do(req.WithContext(r.Context()))
+		if err != nil {
+			writeJsonError(w, http.StatusBadGateway, fmt.Sprintf("failed to send request: %s", err))
+			return
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			writeJsonError(w, http.StatusBadGateway, fmt.Sprintf("failed to read response: %s", err))
+			return
+		}
+
+		result := &replayResult{
+			Status:   resp.StatusCode,
+			Body:     string(body),
+			Duration: time.Since(start).String(),
+		}
+		result.Header.FromHttpHeader(resp.Header)
+		writeJson(w, http.StatusOK, result)
+	}
+}
+
+func pathEntry(w http.ResponseWriter, r *http.Request, store *recordStore) *recordEntry {
+	seq, err := strconv.Atoi(r.PathValue("seq"))
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, fmt.Sprintf("invalid record number '%s'", r.PathValue("seq")))
+		return nil
+	}
+
+	entry := store.get(seq)
+	if entry == nil {
+		writeJsonError(w, http.StatusNotFound, fmt.Sprintf("record %d not found", seq))
+		return nil
+	}
+	return entry
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v)
+}
+
+func writeJsonError(w http.ResponseWriter, status int, msg string) {
+	writeJson(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminSameOrigin(t *testing.T) {
+	handler := adminHandler(newRecordStore(0), nil)
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		status int
+	}{
+		{"no origin", nil, http.StatusOK},
+		{"same origin", map[string]string{"Origin": "http://admin.test"}, http.StatusOK},
+		{"other origin", map[string]string{"Origin": "http://evil.test"}, http.StatusForbidden},
+		{"other referer", map[string]string{"Referer": "http://evil.test/page"}, http.StatusForbidden},
+		{"cross site fetch", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "http://admin.test/api/sequence", strings.NewReader(`{"seq": 5}`))
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	// reading is allowed from anywhere
+	r := httptest.NewRequest("GET", "http://admin.test/api/records", nil)
+	r.Header.Set("Origin", "http://evil.test")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("GET status = %d, want 200", w.Code)
+	}
+}
+
+func TestReplayContentType(t *testing.T) {
+	store := newRecordStore(0)
+	store.add(&recordEntry{Seq: 1, File: "0001_a.json", Record: &Record{Method: "GET", URL: "/a"}})
+	handler := adminHandler(store, nil)
+
+	r := httptest.NewRequest("POST", "http://admin.test/api/records/1/replay", strings.NewReader("target=localhost"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("status = %d, want 415", w.Code)
+	}
+}
+
+// waitSubscribers waits until the store has n subscribers.
+func waitSubscribers(t *testing.T, store *recordStore, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		store.mu.RLock()
+		count := len(store.subscribers)
+		store.mu.RUnlock()
+		if count == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("store has %d subscribers, want %d", count, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStreamRecords(t *testing.T) {
+	rec, _ := newTestRecorder(t, 0)
+	server := httptest.NewServer(adminHandler(rec.store, []*recorder{rec}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan *recordEntry, 8)
+	done := make(chan error, 1)
+	go func() {
+		done <- tailEvents(ctx, server.URL, false, func(entry *recordEntry) { events <- entry })
+	}()
+	waitSubscribers(t, rec.store, 1)
+
+	rec.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/hook", strings.NewReader("data")))
+
+	select {
+	case entry := <-events:
+		if entry.Seq != 1 || entry.Record.Method != "POST" || entry.Record.URL != "/hook" {
+			t.Errorf("event = #%d %s %s, want #1 POST /hook", entry.Seq, entry.Record.Method, entry.Record.URL)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event for the recorded request")
+	}
+	select {
+	case entry := <-events:
+		t.Errorf("unexpected second event #%d", entry.Seq)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("tail: %v", err)
+	}
+}
+
+func TestStreamRecordsDisconnect(t *testing.T) {
+	store := newRecordStore(0)
+	server := httptest.NewServer(adminHandler(store, nil))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribers(t, store, 1)
+
+	// the client stops reading and goes away
+	cancel()
+	resp.Body.Close()
+	waitSubscribers(t, store, 0)
+
+	// a subscriber which never reads does not block the capture either
+	_, unsubscribe := store.subscribe()
+	defer unsubscribe()
+	added := make(chan struct{})
+	go func() {
+		for seq := 1; seq <= 200; seq++ {
+			store.add(&recordEntry{Seq: seq, Record: &Record{}})
+		}
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(2 * time.Second):
+		t.Fatal("adding records blocks on a slow subscriber")
+	}
+}
+
+// adminRequest sends a request to the admin handler as the admin page would.
+func adminRequest(t *testing.T, handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, "http://admin.test"+target, reader)
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestAdminRecords(t *testing.T) {
+	rec, dir := newTestRecorder(t, 0)
+	handler := adminHandler(rec.store, []*recorder{rec})
+	rec.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a", nil))
+	rec.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/b", strings.NewReader("body")))
+	rec.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/c", nil))
+
+	w := adminRequest(t, handler, "GET", "/api/records?method=GET", "")
+	var list []*recordSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("list: %s %s", err, w.Body)
+	}
+	if len(list) != 2 || list[0].URL != "/a" || list[1].URL != "/c" {
+		t.Errorf("GET records = %+v", list)
+	}
+
+	w = adminRequest(t, handler, "GET", "/api/records/2", "")
+	var entry recordEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entry); err != nil || entry.Record.URL != "/b" {
+		t.Errorf("record 2 = %s, %v", w.Body, err)
+	}
+	if w := adminRequest(t, handler, "GET", "/api/records/9", ""); w.Code != http.StatusNotFound {
+		t.Errorf("missing record status = %d, want 404", w.Code)
+	}
+	if w := adminRequest(t, handler, "GET", "/api/records/x", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid record status = %d, want 400", w.Code)
+	}
+
+	if w := adminRequest(t, handler, "DELETE", "/api/records/2", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d: %s", w.Code, w.Body)
+	}
+	if rec.store.get(2) != nil {
+		t.Error("deleted record is still listed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, entry.File)); !os.IsNotExist(err) {
+		t.Errorf("deleted record file: %v", err)
+	}
+
+	w = adminRequest(t, handler, "DELETE", "/api/records?reset=true", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"deleted":2`) {
+		t.Errorf("clear = %d %s", w.Code, w.Body)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 0 || rec.store.currentSeq() != 0 {
+		t.Errorf("after clear files %v seq %d", files, rec.store.currentSeq())
+	}
+}
+
+func TestAdminSequence(t *testing.T) {
+	store := newRecordStore(0)
+	handler := adminHandler(store, nil)
+
+	if w := adminRequest(t, handler, "PUT", "/api/sequence", `{"seq": 41}`); w.Code != http.StatusOK {
+		t.Fatalf("set status = %d: %s", w.Code, w.Body)
+	}
+	if seq := store.nextSeq(); seq != 42 {
+		t.Errorf("next seq = %d, want 42", seq)
+	}
+	w := adminRequest(t, handler, "GET", "/api/sequence", "")
+	if strings.TrimSpace(w.Body.String()) != `{"seq":42}` {
+		t.Errorf("sequence = %s", w.Body)
+	}
+
+	for _, body := range []string{`{"seq": -1}`, `{"seq": "x"}`, `not json`} {
+		if w := adminRequest(t, handler, "PUT", "/api/sequence", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, w.Code)
+		}
+	}
+}
+
+func TestAdminResponder(t *testing.T) {
+	rec, dir := newTestRecorder(t, 0)
+	handler := adminHandler(rec.store, []*recorder{rec})
+
+	w := adminRequest(t, handler, "PUT", "/api/responder", `{"status": 201, "body": "created"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	resp := httptest.NewRecorder()
+	rec.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+	if resp.Code != 201 || resp.Body.String() != "created" {
+		t.Errorf("response = %d %s, want 201 created", resp.Code, resp.Body)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		header map[string]string
+		status int
+	}{
+		{"invalid json", `{"status": `, nil, http.StatusBadRequest},
+		{"invalid status", `{"status": 42}`, nil, http.StatusBadRequest},
+		{"playback below the save dir", `{"playback": "` + dir + `"}`, nil, http.StatusOK},
+		{"playback outside", `{"playback": "` + t.TempDir() + `"}`, nil, http.StatusForbidden},
+		{"wwwroot outside", `{"wwwroot": "/"}`, nil, http.StatusForbidden},
+		{"relative escape", `{"wwwroot": "` + dir + `/../.."}`, nil, http.StatusForbidden},
+		{"other origin", `{"status": 200}`, map[string]string{"Origin": "http://evil.test"}, http.StatusForbidden},
+		{"unknown listener", `{"status": 200}`, nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "http://admin.test/api/responder"
+			if tt.name == "unknown listener" {
+				target += "?listener=missing"
+			}
+			r := httptest.NewRequest("PUT", target, strings.NewReader(tt.body))
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	// the rejected changes left the last accepted responder in place
+	if cfg := rec.responder.Load().config; cfg.Playback != dir {
+		t.Errorf("responder = %+v, want playback of %s", cfg, dir)
+	}
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"io"
+	"log"
+	"math"
+	"math/bits"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+func benchCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "bench",
+		Usage:     "Drive a target with recorded traffic at a fixed or ramped rate",
+		ArgsUsage: "[record ...]",
+		Flags: slices.Concat(recordFlags(), replayFlags(), []cli.Flag{
+			&cli.StringFlag{
+				Name:     "rate",
+				Aliases:  []string{"r"},
+				Usage:    "Requests per time unit, e.g. '200/s', '50/100ms' or '6000/m'",
+				Value:    "10/s",
+				Category: "load",
+			},
+			&cli.DurationFlag{
+				Name:     "duration",
+				Aliases:  []string{"d"},
+				Usage:    "How long to send requests",
+				Value:    10 * time.Second,
+				Category: "load",
+			},
+			&cli.DurationFlag{
+				Name:     "ramp",
+				Usage:    "Raise the rate linearly from zero during this time",
+				Category: "load",
+			},
+			&cli.StringSliceFlag{
+				Name:     "weight",
+				Usage:    "Weight of the records whose path matches a pattern, e.g. '/api/search*=5', every record weighs 1 by default",
+				Category: "load",
+			},
+			&cli.IntFlag{
+				Name:     "concurrency",
+				Aliases:  []string{"n"},
+				Usage:    "Requests in flight at most, waiting for a slot counts as latency",
+				Value:    100,
+				Category: "load",
+			},
+			&cli.DurationFlag{
+				Name:     "timeout",
+				Usage:    "Timeout of a request",
+				Value:    30 * time.Second,
+				Category: "load",
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Report format, 'text' or 'json'",
+				Value:   "text",
+			},
+		}, transportFlags()),
+		Action: func(c *cli.Context) error {
+			if c.String("output") != "text" && c.String("output") != "json" {
+				return cli.Exit(fmt.Sprintf("unknown output format '%s'", c.String("output")), 1)
+			}
+			rate, err := parseRate(c.String("rate"))
+			if err != nil {
+				return err
+			}
+
+			entries, err := selectRecords(c)
+			if err != nil {
+				return err
+			}
+			mix, err := newTrafficMix(entries, c.StringSlice("weight"))
+			if err != nil {
+				return err
+			}
+
+			rp, err := newReplayer(c)
+			if err != nil {
+				return err
+			}
+			defer rp.close()
+			rp.transport.MaxIdleConnsPerHost = max(c.Int("concurrency"), 1)
+
+			ctx, stop := signal.NotifyContext(c.Context, os.Interrupt)
+			defer stop()
+
+			b := &bench{
+				replayer:    rp,
+				mix:         mix,
+				rate:        rate,
+				ramp:        c.Duration("ramp"),
+				duration:    c.Duration("duration"),
+				concurrency: max(c.Int("concurrency"), 1),
+				timeout:     c.Duration("timeout"),
+				stats:       newBenchStats(),
+			}
+			log.Printf("Sending %.6g requests/s for %s from %d records", rate, b.duration, len(entries))
+			report := b.run(ctx)
+
+			if c.String("output") == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(report)
+			}
+			report.print(os.Stdout)
+			return nil
+		},
+	}
+}
+
+// parseRate returns the requests per second of rates like '200/s' or
+// '50/100ms', a plain number is per second.
+func parseRate(s string) (float64, error) {
+	count, unit, found := strings.Cut(strings.TrimSpace(s), "/")
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate '%s'", s)
+	}
+	if !found {
+		return n, nil
+	}
+
+	if unit != "" && (unit[0] < '0' || unit[0] > '9') {
+		unit = "1" + unit
+	}
+	per, err := time.ParseDuration(unit)
+	if err != nil || per <= 0 {
+		return 0, fmt.Errorf("invalid rate '%s'", s)
+	}
+	return n / per.Seconds(), nil
+}
+
+// trafficMix picks records at random by weight.
+type trafficMix struct {
+	entries    []*recordEntry
+	cumulative []float64
+}
+
+func newTrafficMix(entries []*recordEntry, weights []string) (*trafficMix, error) {
+	type weight struct {
+		pattern string
+		value   float64
+	}
+	var rules []weight
+	for _, w := range weights {
+		i := strings.LastIndex(w, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid weight '%s', expect 'pattern=weight'", w)
+		}
+		value, err := strconv.ParseFloat(w[i+1:], 64)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("invalid weight '%s', expect 'pattern=weight'", w)
+		}
+		if _, err := path.Match(w[:i], "/"); err != nil {
+			return nil, fmt.Errorf("invalid weight pattern '%s': %s", w[:i], err)
+		}
+		rules = append(rules, weight{w[:i], value})
+	}
+
+	mix := &trafficMix{}
+	total := 0.0
+	for _, entry := range entries {
+		value := 1.0
+		p := entry.Record.URL
+		if u, err := url.Parse(p); err == nil {
+			p = u.Path
+		}
+		for _, rule := range rules {
+			if ok, _ := path.Match(rule.pattern, p); ok {
+				value = rule.value
+				break
+			}
+		}
+		if value == 0 {
+			continue
+		}
+		total += value
+		mix.entries = append(mix.entries, entry)
+		mix.cumulative = append(mix.cumulative, total)
+	}
+	if len(mix.entries) == 0 {
+		return nil, cli.Exit("no record to send", 1)
+	}
+	return mix, nil
+}
+
+func (m *trafficMix) pick() *recordEntry {
+	x := rand.Float64() * m.cumulative[len(m.cumulative)-1]
+	i := sort.SearchFloat64s(m.cumulative, x)
+	return m.entries[min(i, len(m.entries)-1)]
+}
+
+type bench struct {
+	replayer    *replayer
+	mix         *trafficMix
+	rate        float64
+	ramp        time.Duration
+	duration    time.Duration
+	concurrency int
+	timeout     time.Duration
+	stats       *benchStats
+}
+
+// sendTime is when the k-th request is due, the rate rises linearly during
+// the ramp and stays constant after it.
+func (b *bench) sendTime(k int) time.Duration {
+	ramp := b.ramp.Seconds()
+	rampCount := b.rate * ramp / 2
+	var t float64
+	if float64(k) < rampCount {
+		t = math.Sqrt(2 * ramp * float64(k) / b.rate)
+	} else {
+		t = ramp + (float64(k)-rampCount)/b.rate
+	}
+	return time.Duration(t * float64(time.Second))
+}
+
+// run sends requests open loop: a request is due at its time whether the
+// earlier ones were answered or not, latencies count from that time.
+func (b *bench) run(ctx context.Context) *benchReport {
+	slots := make(chan struct{}, b.concurrency)
+	wg := &sync.WaitGroup{}
+	begin := time.Now()
+
+	for k := 0; ; k++ {
+		offset := b.sendTime(k)
+		if offset >= b.duration {
+			break
+		}
+		at := begin.Add(offset)
+		timer := time.NewTimer(time.Until(at))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+		case slots <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		entry := b.mix.pick()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			b.send(ctx, entry, at)
+		}()
+	}
+	wg.Wait()
+
+	return b.stats.report(time.Since(begin))
+}
+
+func (b *bench) send(ctx context.Context, entry *recordEntry, at time.Time) {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	status := 0
+	resp, _, err := b.replayer.do(ctx, entry)
+	if err == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		status = resp.StatusCode
+	}
+	b.stats.add(entry.Record, status, err, time.Since(at))
+}
+
+// latencyHistogram keeps microseconds with about 1% precision the way an HDR
+// histogram does: values below 128 are exact, every higher power of two is
+// split into 128 linear buckets.
+type latencyHistogram struct {
+	counts   []uint64
+	total    uint64
+	sum      time.Duration
+	min, max time.Duration
+}
+
+const histogramSubBuckets = 128
+
+func histogramIndex(v uint64) int {
+	if v < histogramSubBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - 8
+	return histogramSubBuckets + shift*histogramSubBuckets + int(v>>shift) - histogramSubBuckets
+}
+
+// histogramUpper is the highest value of a bucket.
+func histogramUpper(i int) uint64 {
+	if i < histogramSubBuckets {
+		return uint64(i)
+	}
+	shift := (i - histogramSubBuckets) / histogramSubBuckets
+	m := uint64((i-histogramSubBuckets)%histogramSubBuckets + histogramSubBuckets)
+	return (m+1)<<shift - 1
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	i := histogramIndex(uint64(max(d.Microseconds(), 0)))
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, i+1-len(h.counts))...)
+	}
+	h.counts[i]++
+
+	if h.total == 0 || d < h.min {
+		h.min = d
+	}
+	h.max = max(h.max, d)
+	h.total++
+	h.sum += d
+}
+
+func (h *latencyHistogram) percentile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	target := uint64(math.Ceil(q / 100 * float64(h.total)))
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= max(target, 1) {
+			return min(time.Duration(histogramUpper(i))*time.Microsecond, h.max)
+		}
+	}
+	return h.max
+}
+
+type routeStats struct {
+	requests int
+	errors   int
+	latency  latencyHistogram
+}
+
+type benchStats struct {
+	mu       sync.Mutex
+	latency  latencyHistogram
+	requests int
+	errors   int
+	status   map[string]int
+	kinds    map[string]int
+	routes   map[string]*routeStats
+}
+
+func newBenchStats() *benchStats {
+	return &benchStats{
+		status: make(map[string]int),
+		kinds:  make(map[string]int),
+		routes: make(map[string]*routeStats),
+	}
+}
+
+func (s *benchStats) add(record *Record, status int, err error, latency time.Duration) {
+	p := record.URL
+	if u, perr := url.Parse(p); perr == nil {
+		p = u.Path
+	}
+	route := record.Method + " " + metricRoute(p)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.routes[route]
+	if !ok {
+		r = &routeStats{}
+		s.routes[route] = r
+	}
+	s.requests++
+	r.requests++
+	s.latency.record(latency)
+	r.latency.record(latency)
+
+	kind := ""
+	switch {
+	case err != nil:
+		kind = errorKind(err)
+	case status >= 400:
+		kind = "HTTP " + strconv.Itoa(status)
+	}
+	if status > 0 {
+		s.status[strconv.Itoa(status)]++
+	}
+	if kind != "" {
+		s.errors++
+		r.errors++
+		s.kinds[kind]++
+	}
+}
+
+// errorKind names transport errors without their addresses so they group.
+func errorKind(err error) string {
+	var netErr interface{ Timeout() bool }
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection closed"
+	}
+	return "other"
+}
+
+type latencySummary struct {
+	Min  float64 `json:"min_ms"`
+	Mean float64 `json:"mean_ms"`
+	P50  float64 `json:"p50_ms"`
+	P90  float64 `json:"p90_ms"`
+	P95  float64 `json:"p95_ms"`
+	P99  float64 `json:"p99_ms"`
+	P999 float64 `json:"p99_9_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+type routeReport struct {
+	Route    string         `json:"route"`
+	Requests int            `json:"requests"`
+	Errors   int            `json:"errors"`
+	Latency  latencySummary `json:"latency"`
+}
+
+type benchReport struct {
+	Requests   int            `json:"requests"`
+	Errors     int            `json:"errors"`
+	Duration   float64        `json:"duration_seconds"`
+	Throughput float64        `json:"throughput"`
+	Latency    latencySummary `json:"latency"`
+	Status     map[string]int `json:"status"`
+	ErrorKinds map[string]int `json:"error_kinds"`
+	Routes     []*routeReport `json:"routes"`
+}
+
+func milliseconds(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
+func (h *latencyHistogram) summary() latencySummary {
+	s := latencySummary{
+		Min:  milliseconds(h.min),
+		P50:  milliseconds(h.percentile(50)),
+		P90:  milliseconds(h.percentile(90)),
+		P95:  milliseconds(h.percentile(95)),
+		P99:  milliseconds(h.percentile(99)),
+		P999: milliseconds(h.percentile(99.9)),
+		Max:  milliseconds(h.max),
+	}
+	if h.total > 0 {
+		s.Mean = milliseconds(h.sum / time.Duration(h.total))
+	}
+	return s
+}
+
+func (s *benchStats) report(elapsed time.Duration) *benchReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := &benchReport{
+		Requests:   s.requests,
+		Errors:     s.errors,
+		Duration:   elapsed.Seconds(),
+		Throughput: float64(s.requests) / elapsed.Seconds(),
+		Latency:    s.latency.summary(),
+		Status:     s.status,
+		ErrorKinds: s.kinds,
+	}
+	for route, r := range s.routes {
+		report.Routes = append(report.Routes, &routeReport{
+			Route:    route,
+			Requests: r.requests,
+			Errors:   r.errors,
+			Latency:  r.latency.summary(),
+		})
+	}
+	sort.Slice(report.Routes, func(i, j int) bool {
+		if report.Routes[i].Requests != report.Routes[j].Requests {
+			return report.Routes[i].Requests > report.Routes[j].Requests
+		}
+		return report.Routes[i].Route < report.Routes[j].Route
+	})
+	return report
+}
+
+func sortedCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s: %d", k, counts[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (r *benchReport) print(w io.Writer) {
+	fmt.Fprintf(w, "Requests:    %d in %.3fs, %d errors\n", r.Requests, r.Duration, r.Errors)
+	fmt.Fprintf(w, "Throughput:  %.2f requests/s\n", r.Throughput)
+	l := r.Latency
+	fmt.Fprintf(w, "Latency:     min %.3fms, mean %.3fms, max %.3fms\n", l.Min, l.Mean, l.Max)
+	fmt.Fprintf(w, "Percentiles: p50 %.3fms, p90 %.3fms, p95 %.3fms, p99 %.3fms, p99.9 %.3fms\n", l.P50, l.P90, l.P95, l.P99, l.P999)
+	if len(r.Status) > 0 {
+		fmt.Fprintf(w, "Status:      %s\n", sortedCounts(r.Status))
+	}
+	if len(r.ErrorKinds) > 0 {
+		fmt.Fprintf(w, "Errors:      %s\n", sortedCounts(r.ErrorKinds))
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROUTE\tREQUESTS\tERRORS\tP50\tP90\tP99\tMAX")
+	for _, route := range r.Routes {
+		l := route.Latency
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.3fms\t%.3fms\t%.3fms\t%.3fms\n",
+			route.Route, route.Requests, route.Errors, l.P50, l.P90, l.P99, l.Max)
+	}
+	tw.Flush()
+}
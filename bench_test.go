@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	tests := []struct {
+		v     uint64
+		index int
+		upper uint64
+	}{
+		{0, 0, 0},
+		{127, 127, 127},
+		{128, 128, 128},
+		{255, 255, 255},
+		{256, 256, 257},
+		{257, 256, 257},
+		{258, 257, 259},
+		{511, 383, 511},
+		{512, 384, 515},
+		{1 << 20, 1792, 1<<20 + 1<<13 - 1},
+	}
+	for _, tt := range tests {
+		i := histogramIndex(tt.v)
+		if i != tt.index || histogramUpper(i) != tt.upper {
+			t.Errorf("value %d: bucket %d upper %d, want bucket %d upper %d", tt.v, i, histogramUpper(i), tt.index, tt.upper)
+		}
+	}
+
+	// every value falls between the upper bounds of its bucket and the one before
+	for v := uint64(1); v < 1<<24; v = v*17/16 + 1 {
+		i := histogramIndex(v)
+		if histogramUpper(i) < v || histogramUpper(i-1) >= v {
+			t.Fatalf("value %d is outside of bucket %d (%d, %d]", v, i, histogramUpper(i-1), histogramUpper(i))
+		}
+		if float64(histogramUpper(i)-v) > float64(v)/100 {
+			t.Fatalf("value %d: bucket upper %d is off by more than 1%%", v, histogramUpper(i))
+		}
+	}
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	h := &latencyHistogram{}
+	if h.percentile(50) != 0 {
+		t.Error("percentile of an empty histogram is not 0")
+	}
+	for i := 1; i <= 100; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{1, time.Millisecond},
+		{50, 50 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		got := h.percentile(tt.q)
+		// bucket precision is about 1%
+		if got < tt.want || float64(got-tt.want) > float64(tt.want)/100 {
+			t.Errorf("p%v = %s, want %s", tt.q, got, tt.want)
+		}
+	}
+	if h.min != time.Millisecond || h.max != 100*time.Millisecond || h.total != 100 {
+		t.Errorf("min %s max %s total %d", h.min, h.max, h.total)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		s    string
+		want float64
+	}{
+		{"200", 200},
+		{"200/s", 200},
+		{"50/100ms", 500},
+		{"6000/m", 100},
+		{"30/2s", 15},
+		{"0.5/s", 0.5},
+		{" 10/h ", 10.0 / 3600},
+	}
+	for _, tt := range tests {
+		got, err := parseRate(tt.s)
+		if err != nil || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("parseRate(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+	for _, s := range []string{"", "0", "-5/s", "a/s", "5/", "5/0s", "5/x", "5/-1s"} {
+		if _, err := parseRate(s); err == nil {
+			t.Errorf("parseRate(%q) is accepted", s)
+		}
+	}
+}
+
+func TestBenchSendTime(t *testing.T) {
+	b := &bench{rate: 10}
+	for k, want := range []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if got := b.sendTime(k); got != want {
+			t.Errorf("no ramp: request %d at %s, want %s", k, got, want)
+		}
+	}
+
+	// a ramp of 10s to 10/s sends 50 requests during the ramp
+	b = &bench{rate: 10, ramp: 10 * time.Second}
+	tests := []struct {
+		k    int
+		want time.Duration
+	}{
+		{0, 0},
+		{5, time.Duration(math.Sqrt(10) * float64(time.Second))},
+		{20, time.Duration(math.Sqrt(40) * float64(time.Second))},
+		{50, 10 * time.Second},
+		{60, 11 * time.Second},
+	}
+	for _, tt := range tests {
+		got := b.sendTime(tt.k)
+		if d := got - tt.want; d < -time.Microsecond || d > time.Microsecond {
+			t.Errorf("ramp: request %d at %s, want %s", tt.k, got, tt.want)
+		}
+	}
+	for k := 1; k < 100; k++ {
+		if b.sendTime(k) <= b.sendTime(k-1) {
+			t.Fatalf("request %d is not after request %d", k, k-1)
+		}
+	}
+}
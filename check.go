@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func checkFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:     "check",
+			Usage:    "Compare the response with the recorded one and exit with 1 if they differ",
+			Category: "check",
+		},
+		&cli.StringSliceFlag{
+			Name:     "check-header",
+			Usage:    "Response header to compare, can be repeated",
+			Category: "check",
+		},
+		&cli.StringFlag{
+			Name:     "check-body",
+			Usage:    "Body comparison: 'exact', 'json', 'regex' or 'none', default is 'json' for JSON records and 'exact' otherwise",
+			Category: "check",
+		},
+		&cli.StringFlag{
+			Name:     "body-regex",
+			Usage:    "Pattern the body must match with '--check-body regex', default is the recorded body",
+			Category: "check",
+		},
+		&cli.StringSliceFlag{
+			Name:     "ignore",
+			Usage:    "JSONPath skipped by '--check-body json', e.g. '$.timestamp' or '$..id', can be repeated",
+			Category: "check",
+		},
+		&cli.BoolFlag{
+			Name:     "no-color",
+			Usage:    "Disable colored output",
+			Category: "check",
+		},
+	}
+}
+
+// responseCheck compares live responses with the recorded ones.
+type responseCheck struct {
+	headers []string
+	body    string
+	pattern *regexp.Regexp
+	ignore  []jsonPath
+	color   bool
+}
+
+func newResponseCheck(c *cli.Context) (*responseCheck, error) {
+	rc := &responseCheck{
+		headers: c.StringSlice("check-header"),
+		body:    c.String("check-body"),
+		color:   !c.Bool("no-color") && isTerminal(os.Stdout),
+	}
+
+	switch rc.body {
+	case "", "exact", "json", "none":
+	case "regex":
+		if c.String("body-regex") != "" {
+			pattern, err := regexp.Compile(c.String("body-regex"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid body regex: %s", err)
+			}
+			rc.pattern = pattern
+		}
+	default:
+		return nil, fmt.Errorf("unknown body check '%s'", rc.body)
+	}
+
+	for _, s := range c.StringSlice("ignore") {
+		p, err := parseJSONPath(s)
+		if err != nil {
+			return nil, err
+		}
+		rc.ignore = append(rc.ignore, p)
+	}
+	return rc, nil
+}
+
+// mismatch is one difference, diff holds the lines of a text body diff.
+type mismatch struct {
+	what     string
+	expected string
+	actual   string
+	diff     []diffLine
+}
+
+// compare returns the differences between the recorded response of entry and
+// resp, whose body was read into body.
+func (rc *responseCheck) compare(entry *recordEntry, resp *http.Response, body []byte) ([]*mismatch, error) {
+	expected := entry.Record.Response
+	if expected == nil {
+		return nil, fmt.Errorf("record #%04d has no response", entry.Seq)
+	}
+
+	var mismatches []*mismatch
+	if expected.Status != resp.StatusCode {
+		mismatches = append(mismatches, &mismatch{
+			what:     "status",
+			expected: strconv.Itoa(expected.Status),
+			actual:   strconv.Itoa(resp.StatusCode),
+		})
+	}
+	for _, name := range rc.headers {
+		want := strings.Join(expected.Header.Values(name), ", ")
+		got := strings.Join(resp.Header.Values(name), ", ")
+		if want != got {
+			mismatches = append(mismatches, &mismatch{what: "header " + name, expected: want, actual: got})
+		}
+	}
+
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" {
+		if decoded, err := decodeContent(encoding, body); err == nil {
+			body = decoded
+		}
+	}
+
+	mode := rc.body
+	if mode == "" {
+		mode = "exact"
+		if expected.BodyJson != nil {
+			mode = "json"
+		}
+	}
+	if mode == "none" {
+		return mismatches, nil
+	}
+
+	reader, err := parseRecordBody(expected, expected.Header.ToHttpHeader(), entry.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recorded body: %s", err)
+	}
+	want, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recorded body: %s", err)
+	}
+
+	switch mode {
+	case "exact":
+		if !bytes.Equal(want, body) {
+			mismatches = append(mismatches, &mismatch{what: "body", diff: diffLines(string(want), string(body))})
+		}
+	case "regex":
+		pattern := rc.pattern
+		if pattern == nil {
+			if pattern, err = regexp.Compile(string(want)); err != nil {
+				return nil, fmt.Errorf("recorded body is no valid regex: %s", err)
+			}
+		}
+		if !pattern.Match(body) {
+			mismatches = append(mismatches, &mismatch{what: "body", expected: "match " + pattern.String(), actual: string(body)})
+		}
+	case "json":
+		var wantValue, gotValue interface{}
+		if err := decodeJsonNumber(want, &wantValue); err != nil {
+			return nil, fmt.Errorf("recorded body is no valid JSON: %s", err)
+		}
+		if err := decodeJsonNumber(body, &gotValue); err != nil {
+			mismatches = append(mismatches, &mismatch{what: "body", expected: "JSON", actual: err.Error()})
+			break
+		}
+		mismatches = rc.compareJSON(mismatches, nil, wantValue, gotValue)
+	}
+	return mismatches, nil
+}
+
+func (rc *responseCheck) ignored(location []interface{}) bool {
+	for _, p := range rc.ignore {
+		if p.match(location) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareJSON walks both values and reports every differing location.
+func (rc *responseCheck) compareJSON(mismatches []*mismatch, location []interface{}, want, got interface{}) []*mismatch {
+	if rc.ignored(location) {
+		return mismatches
+	}
+	at := func(step interface{}) []interface{} {
+		return append(location[:len(location):len(location)], step)
+	}
+
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(w)+len(g))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		for k := range g {
+			if _, ok := w[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			wv, inWant := w[k]
+			gv, inGot := g[k]
+			switch {
+			case inWant && inGot:
+				mismatches = rc.compareJSON(mismatches, at(k), wv, gv)
+			case !rc.ignored(at(k)):
+				mismatches = append(mismatches, jsonMismatch(at(k), wv, gv, inWant, inGot))
+			}
+		}
+		return mismatches
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < max(len(w), len(g)); i++ {
+			switch {
+			case i < len(w) && i < len(g):
+				mismatches = rc.compareJSON(mismatches, at(i), w[i], g[i])
+			case !rc.ignored(at(i)):
+				mismatches = append(mismatches, jsonMismatch(at(i), listItem(w, i), listItem(g, i), i < len(w), i < len(g)))
+			}
+		}
+		return mismatches
+	default:
+		if jsonEqual(want, got) {
+			return mismatches
+		}
+	}
+	return append(mismatches, jsonMismatch(location, want, got, true, true))
+}
+
+func listItem(list []interface{}, i int) interface{} {
+	if i < len(list) {
+		return list[i]
+	}
+	return nil
+}
+
+func jsonMismatch(location []interface{}, want, got interface{}, inWant, inGot bool) *mismatch {
+	m := &mismatch{what: "body " + formatJSONPath(location), expected: "(missing)", actual: "(missing)"}
+	if inWant {
+		data, _ := json.Marshal(want)
+		m.expected = string(data)
+	}
+	if inGot {
+		data, _ := json.Marshal(got)
+		m.actual = string(data)
+	}
+	return m
+}
+
+// jsonEqual compares scalars, numbers by value so 1.0 equals 1.
+func jsonEqual(want, got interface{}) bool {
+	wn, ok1 := want.(json.Number)
+	gn, ok2 := got.(json.Number)
+	if ok1 && ok2 {
+		wf, _, err1 := big.ParseFloat(wn.String(), 10, 256, big.ToNearestEven)
+		gf, _, err2 := big.ParseFloat(gn.String(), 10, 256, big.ToNearestEven)
+		if err1 == nil && err2 == nil {
+			return wf.Cmp(gf) == 0
+		}
+		return wn == gn
+	}
+	return want == got
+}
+
+// report formats the differences of a record for the terminal.
+func (rc *responseCheck) report(entry *recordEntry, mismatches []*mismatch) string {
+	p := &tailPrinter{color: rc.color}
+	b := &strings.Builder{}
+	record := entry.Record
+	if len(mismatches) == 0 {
+		fmt.Fprintf(b, "%s #%04d %s %s\n", p.paint(colorGreen, "PASS"), entry.Seq, record.Method, record.URL)
+		return b.String()
+	}
+
+	fmt.Fprintf(b, "%s #%04d %s %s\n", p.paint(colorRed, "FAIL"), entry.Seq, record.Method, record.URL)
+	for _, m := range mismatches {
+		fmt.Fprintf(b, "  %s\n", p.paint(colorBold, m.what))
+		if m.diff != nil {
+			for _, line := range m.diff {
+				switch line.op {
+				case '-':
+					fmt.Fprintf(b, "    %s\n", p.paint(colorGreen, "- "+line.text))
+				case '+':
+					fmt.Fprintf(b, "    %s\n", p.paint(colorRed, "+ "+line.text))
+				case '~':
+					fmt.Fprintf(b, "    %s\n", p.paint(colorDim, line.text))
+				default:
+					fmt.Fprintf(b, "      %s\n", line.text)
+				}
+			}
+			continue
+		}
+		fmt.Fprintf(b, "    %s\n", p.paint(colorGreen, "- "+m.expected))
+		fmt.Fprintf(b, "    %s\n", p.paint(colorRed, "+ "+m.actual))
+	}
+	return b.String()
+}
+
+// diffLine is a line of a diff, op is ' ', '-' for expected only, '+' for
+// actual only or '~' for skipped unchanged lines.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+const diffContext = 2
+
+// diffLines is a line diff of two texts with a few unchanged lines around
+// every change.
+func diffLines(expected, actual string) []diffLine {
+	a := strings.Split(expected, "\n")
+	b := strings.Split(actual, "\n")
+
+	var lines []diffLine
+	if len(a)*len(b) > 4<<20 {
+		// too large for the table below, show both sides
+		for _, s := range a {
+			lines = append(lines, diffLine{'-', s})
+		}
+		for _, s := range b {
+			lines = append(lines, diffLine{'+', s})
+		}
+		return lines
+	}
+
+	// longest common subsequence from the end
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+
+	// drop unchanged lines far from changes
+	keep := make([]bool, len(lines))
+	for k, line := range lines {
+		if line.op != ' ' {
+			for n := max(k-diffContext, 0); n <= min(k+diffContext, len(lines)-1); n++ {
+				keep[n] = true
+			}
+		}
+	}
+	var result []diffLine
+	for k, line := range lines {
+		if keep[k] {
+			result = append(result, line)
+		} else if len(result) == 0 || result[len(result)-1].op != '~' {
+			result = append(result, diffLine{'~', "..."})
+		}
+	}
+	return result
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestJSONEqualNumbers(t *testing.T) {
+	tests := []struct {
+		want, got string
+		equal     bool
+	}{
+		{"1", "1.0", true},
+		{"1e2", "100", true},
+		{"0.1", "0.10", true},
+		{"-0", "0", true},
+		{"12345678901234567890", "12345678901234567891", false},
+		{"1", "2", false},
+		{`"1"`, "1", false},
+		{"true", "true", true},
+		{"null", "false", false},
+	}
+	for _, tt := range tests {
+		var want, got interface{}
+		if err := decodeJsonNumber([]byte(tt.want), &want); err != nil {
+			t.Fatal(err)
+		}
+		if err := decodeJsonNumber([]byte(tt.got), &got); err != nil {
+			t.Fatal(err)
+		}
+		if jsonEqual(want, got) != tt.equal {
+			t.Errorf("jsonEqual(%s, %s) = %v, want %v", tt.want, tt.got, !tt.equal, tt.equal)
+		}
+	}
+}
+
+func compareTestJSON(t *testing.T, rc *responseCheck, want, got string) []string {
+	t.Helper()
+	var wantValue, gotValue interface{}
+	if err := decodeJsonNumber([]byte(want), &wantValue); err != nil {
+		t.Fatal(err)
+	}
+	if err := decodeJsonNumber([]byte(got), &gotValue); err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for _, m := range rc.compareJSON(nil, nil, wantValue, gotValue) {
+		found = append(found, m.what+" "+m.expected+" "+m.actual)
+	}
+	return found
+}
+
+func TestCompareJSON(t *testing.T) {
+	want := `{"id": 1, "price": 2.50, "time": "a", "items": [{"id": 7, "n": 1}, {"id": 8, "n": 2}]}`
+	got := `{"id": 1.0, "price": 2.5, "time": "b", "items": [{"id": 9, "n": 1}], "extra": true}`
+
+	rc := &responseCheck{}
+	mismatches := compareTestJSON(t, rc, want, got)
+	expected := []string{
+		"body $.extra (missing) true",
+		"body $.items[0].id 7 9",
+		`body $.items[1] {"id":8,"n":2} (missing)`,
+		`body $.time "a" "b"`,
+	}
+	if strings.Join(mismatches, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("mismatches:\n%s\nwant:\n%s", strings.Join(mismatches, "\n"), strings.Join(expected, "\n"))
+	}
+
+	for _, s := range []string{"$.time", "$..id", "$.items[1]", "$.extra"} {
+		p, err := parseJSONPath(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc.ignore = append(rc.ignore, p)
+	}
+	if mismatches := compareTestJSON(t, rc, want, got); len(mismatches) != 0 {
+		t.Errorf("ignored paths are reported: %v", mismatches)
+	}
+
+	// a type change is one mismatch at its location
+	if mismatches := compareTestJSON(t, &responseCheck{}, `{"a": [1]}`, `{"a": {"0": 1}}`); len(mismatches) != 1 || !strings.HasPrefix(mismatches[0], "body $.a ") {
+		t.Errorf("type change: %v", mismatches)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	format := func(lines []diffLine) string {
+		var b strings.Builder
+		for _, l := range lines {
+			b.WriteString(string(l.op) + l.text + "\n")
+		}
+		return b.String()
+	}
+
+	tests := []struct {
+		expected, actual string
+		want             string
+	}{
+		{"a\nb\nc", "a\nb\nc", "~...\n"},
+		{"a\nb\nc", "a\nx\nc", " a\n-b\n+x\n c\n"},
+		{"a", "a\nb", " a\n+b\n"},
+		{"1\n2\n3\n4\n5\n6\n7\n8", "1\n2\n3\n4\n5\n6\n7\nx", "~...\n 6\n 7\n-8\n+x\n"},
+		{"1\n2\n3\n4\n5\n6\n7\n8", "x\n2\n3\n4\n5\n6\n7\ny", "-1\n+x\n 2\n 3\n~...\n 6\n 7\n-8\n+y\n"},
+	}
+	for _, tt := range tests {
+		if got := format(diffLines(tt.expected, tt.actual)); got != tt.want {
+			t.Errorf("diffLines(%q, %q) =\n%s\nwant\n%s", tt.expected, tt.actual, got, tt.want)
+		}
+	}
+}
+
+func TestCheckCompareStatusAndHeader(t *testing.T) {
+	entry := &recordEntry{Seq: 1, Record: &Record{Response: &RequestResponse{
+		Status: 200,
+		Header: Header{{"Content-Type", "text/plain"}},
+		Body:   "ok",
+	}}}
+	resp := &http.Response{StatusCode: 201, Header: http.Header{"Content-Type": {"text/html"}}}
+
+	rc := &responseCheck{headers: []string{"content-type"}}
+	mismatches, err := rc.compare(entry, resp, []byte("ok"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 2 || mismatches[0].what != "status" || mismatches[1].what != "header content-type" {
+		for _, m := range mismatches {
+			t.Logf("%+v", m)
+		}
+		t.Errorf("got %d mismatches, want status and header", len(mismatches))
+	}
+
+	rc = &responseCheck{body: "regex"}
+	if mismatches, _ := rc.compare(entry, &http.Response{StatusCode: 200, Header: http.Header{}}, []byte("okay")); len(mismatches) != 0 {
+		t.Errorf("recorded body as regex: %v", mismatches[0])
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,108 +17,125 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func clientCmd() *cli.Command {
 	return &cli.Command{
-		Name:  "req",
-		Usage: "Replay a request",
-		Flags: []cli.Flag{
+		Name:      "req",
+		Usage:     "Replay a request",
+		ArgsUsage: "[record ...]",
+		Flags: slices.Concat(recordFlags(), replayFlags(), []cli.Flag{
 			&cli.StringFlag{
-				Name:     "file",
-				Aliases:  []string{"f"},
-				Usage:    "Request JSON file",
-				Required: true,
-			},
-			&cli.StringFlag{
-				Name:    "server",
-				Aliases: []string{"s"},
-				Usage:   "Server address, if a url is provided, it will override the url from request file",
-				Value:   "localhost",
+				Name:     "order",
+				Usage:    "Replay order, 'seq' or 'time'",
+				Value:    "seq",
+				Category: "batch",
 			},
 			&cli.IntFlag{
-				Name:    "port",
-				Aliases: []string{"p"},
-				Usage:   "Server port, default is 80 or 443 for https",
+				Name:     "concurrency",
+				Aliases:  []string{"n"},
+				Usage:    "Requests in flight at the same time",
+				Value:    1,
+				Category: "batch",
 			},
 			&cli.BoolFlag{
-				Name:    "https",
-				Aliases: []string{"H"},
-				Usage:   "Use HTTPS",
+				Name:     "keep-going",
+				Usage:    "Continue after a request fails or is answered with 5xx",
+				Category: "batch",
 			},
 			&cli.BoolFlag{
-				Name:  "insecure",
-				Usage: "Skip SSL verification",
+				Name:     "timing",
+				Usage:    "Keep the gaps between the times the records were received, orders by time",
+				Category: "timing",
 			},
 			&cli.StringFlag{
-				Name:  "basic",
-				Usage: "Use basic authentication",
+				Name:     "speed",
+				Usage:    "Replay speed of --timing, e.g. '0.5x' or '10x'",
+				Value:    "1x",
+				Category: "timing",
+			},
+			&cli.DurationFlag{
+				Name:     "max-gap",
+				Usage:    "Longest wait between two records of --timing, after the speed is applied",
+				Category: "timing",
 			},
 			&cli.StringFlag{
-				Name:  "bearer",
-				Usage: "Use bearer token",
+				Name:     "save",
+				Usage:    "Save the request as sent and the response as a new record in this directory",
+				Category: "save",
 			},
 			&cli.BoolFlag{
-				Name:    "verbose",
-				Aliases: []string{"v"},
-				Usage:   "Verbose output",
+				Name:     "update",
+				Usage:    "Replace the response of the replayed record with the new one",
+				Category: "save",
 			},
-		},
+		}, checkFlags(), transportFlags()),
 		Action: func(c *cli.Context) error {
-			uri, err := parseUri(c)
+			entries, err := selectRecords(c)
 			if err != nil {
 				return err
 			}
 
-			var record Record
-			if err := loadRecord(c.String("file"), &record); err != nil {
+			order := c.String("order")
+			if c.Bool("timing") && !c.IsSet("order") {
+				order = "time"
+			}
+			if err := sortRecordEntries(entries, order); err != nil {
 				return err
 			}
-
-			if uri.Path == "" {
-				uri.Path = record.URL
+			if len(entries) == 0 {
+				return cli.Exit("no record to replay", 1)
 			}
 
-			req := &http.Request{}
-			req.URL = uri
-			req.Method = record.Method
-			req.Proto = record.Protocol
-			req.Header = record.Request.Header.ToHttpHeader()
-			req.Body, err = parseRecordBody(record.Request, req.Header, filepath.Base(c.String("file")))
+			rp, err := newReplayer(c)
 			if err != nil {
 				return err
 			}
+			defer rp.close()
 
-			if c.String("basic") != "" {
-				req.Header.Set("Authorization",
-					"Basic "+base64.StdEncoding.EncodeToString([]byte(c.String("basic"))))
-			}
-			if c.String("bearer") != "" {
-				req.Header.Set("Authorization", "Bearer "+c.String("bearer"))
-			}
-
-			client := &http.Client{
-				Transport: http.DefaultTransport,
-			}
-			defer client.CloseIdleConnections()
-			if c.Bool("insecure") {
-				client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-			}
-			if c.Bool("verbose") {
-				client.Transport.(*http.Transport).DialContext = verboseDial(false, false)
-				client.Transport.(*http.Transport).DialTLSContext = verboseDial(true, c.Bool("insecure"))
+			if len(entries) > 1 {
+				return replayBatch(c, rp, entries)
 			}
 
-			resp, err := client.Do(req)
+			start := time.Now()
+			resp, sent, err := rp.do(c.Context, entries[0])
 			if err != nil {
-				return fmt.Errorf("failed to send request: %s", err)
+				return err
 			}
 
 			defer resp.Body.Close()
 			log.Printf("Response: %s\n", resp.Status)
 
+			if rp.check != nil || rp.keeping() {
+				body, err := io.ReadAll(resp.Body)
+				if err != nil {
+					return fmt.Errorf("failed to read response body: %s", err)
+				}
+				if rp.keeping() {
+					if err := rp.keep(entries[0], resp, sent, body, start); err != nil {
+						return err
+					}
+				}
+				if rp.check == nil {
+					_, err := os.Stdout.Write(body)
+					return err
+				}
+
+				mismatches, err := rp.check.compare(entries[0], resp, body)
+				if err != nil {
+					return err
+				}
+				fmt.Print(rp.check.report(entries[0], mismatches))
+				if len(mismatches) > 0 {
+					return cli.Exit("", 1)
+				}
+				return nil
+			}
+
 			if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
 				return fmt.Errorf("failed to print out response body")
 			}
@@ -129,6 +145,93 @@ func clientCmd() *cli.Command {
 	}
 }
 
+// recordFlags select the records a command sends.
+func recordFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "file",
+			Aliases: []string{"f"},
+			Usage:   "Request JSON file, also a save directory, a glob or a JSONL journal, can be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "range",
+			Usage: "Only use records with these sequence numbers, e.g. '120-180' or '120-'",
+		},
+	}
+}
+
+// selectRecords loads the records of --file and the arguments within --range.
+func selectRecords(c *cli.Context) ([]*recordEntry, error) {
+	files := append(c.StringSlice("file"), c.Args().Slice()...)
+	if len(files) == 0 {
+		return nil, cli.Exit("no record given, use --file", 1)
+	}
+
+	entries, err := loadRecordEntries(files)
+	if err != nil {
+		return nil, err
+	}
+	if c.IsSet("range") {
+		r, err := parseSeqRange(c.String("range"))
+		if err != nil {
+			return nil, err
+		}
+		entries = slices.DeleteFunc(entries, func(entry *recordEntry) bool {
+			return !r.contains(entry.Seq)
+		})
+	}
+	return entries, nil
+}
+
+// replayFlags are the target and authentication options of newReplayer.
+func replayFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "server",
+			Aliases: []string{"s"},
+			Usage:   "Server address, if a url is provided, it will override the url from request file",
+			Value:   "localhost",
+		},
+		&cli.IntFlag{
+			Name:    "port",
+			Aliases: []string{"p"},
+			Usage:   "Server port, default is 80 or 443 for https",
+		},
+		&cli.BoolFlag{
+			Name:    "https",
+			Aliases: []string{"H"},
+			Usage:   "Use HTTPS",
+		},
+		&cli.StringFlag{
+			Name:  "basic",
+			Usage: "Use basic authentication",
+		},
+		&cli.StringFlag{
+			Name:  "bearer",
+			Usage: "Use bearer token",
+		},
+		&cli.BoolFlag{
+			Name:  "ordered-headers",
+			Usage: "Send the headers in the recorded order and casing over HTTP/1.1",
+		},
+		&cli.BoolFlag{
+			Name:     "expand",
+			Usage:    "Replace the ${NAME} placeholders of the records with --var, --env, the environment or the built-ins now, uuid and random_int, implied by --var and --env",
+			Category: "variables",
+		},
+		&cli.StringSliceFlag{
+			Name:     "env",
+			Usage:    "File of 'NAME=value' lines for the ${NAME} placeholders of the records, can be repeated",
+			Category: "variables",
+		},
+		&cli.StringSliceFlag{
+			Name:     "var",
+			Usage:    "Variable 'name=value' for the placeholders, overrides the env files and the environment",
+			Category: "variables",
+		},
+	}
+}
+
 func parseUri(c *cli.Context) (*url.URL, error) {
 	var uri *url.URL
 	var err error
@@ -200,10 +303,54 @@ func loadRecord(filename string, record *Record) error {
 	return nil
 }
 
+// newRequest builds the request of a record sent to uri, the path and query of
+// the record are used when uri has no path. Body files are relative to dir.
+func newRequest(record *Record, uri *url.URL, dir string) (*http.Request, error) {
+	target := *uri
+	if target.Path == "" {
+		recordURL, err := url.Parse(record.URL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse record url: %s", err)
+		}
+		target.Path = recordURL.Path
+		target.RawPath = recordURL.RawPath
+		if target.RawQuery == "" {
+			target.RawQuery = recordURL.RawQuery
+		}
+	}
+
+	rr := record.Request
+	if rr == nil {
+		rr = &RequestResponse{}
+	}
+
+	req := &http.Request{}
+	req.URL = &target
+	req.Host = target.Host
+	req.Method = record.Method
+	req.Proto = record.Protocol
+	req.Header = rr.Header.ToHttpHeader()
+
+	var err error
+	req.Body, err = parseRecordBody(rr, req.Header, dir)
+	if err != nil {
+		return nil, err
+	}
+	if rr.Body != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "text/plain")
+	}
+	return req, nil
+}
+
+// parseRecordBody opens the body of a request or response, the Content-Type
+// is only added where the stored form of the body tells it.
+
 func parseRecordBody(req *RequestResponse, header http.Header, baseDir string) (io.ReadCloser, error) {
 	if req.BodyFile != "" {
 		if header.Get("Content-Type") == "" {
-			header.Set("Content-Type", mime.TypeByExtension(filepath.Ext(req.BodyFile)))
+			if contentType := mime.TypeByExtension(filepath.Ext(req.BodyFile)); contentType != "" {
+				header.Set("Content-Type", contentType)
+			}
 		}
 
 		return os.Open(filepath.Join(baseDir, req.BodyFile))
@@ -271,37 +418,90 @@ func parseRecordBody(req *RequestResponse, header http.Header, baseDir string) (
 		return pReader, nil
 	}
 
-	if header.Get("Content-Type") == "" {
-		header.Set("Content-Type", "text/plain")
-	}
 	return io.NopCloser(strings.NewReader(req.Body)), nil
 }
 
-func verboseDial(dialTLS bool, insecure bool) func(ctx context.Context, network, addr string) (net.Conn, error) {
+// transportFlags are the connection options of the commands sending records.
+func transportFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "insecure",
+			Usage: "Skip SSL verification",
+		},
+		&cli.StringFlag{
+			Name:  "cert",
+			Usage: "Client certificate file for mutual TLS",
+		},
+		&cli.StringFlag{
+			Name:  "key",
+			Usage: "Key file of the client certificate, default is the certificate file",
+		},
+		&cli.StringFlag{
+			Name:  "cacert",
+			Usage: "CA file to verify the server certificate",
+		},
+		&cli.StringFlag{
+			Name:  "unix-socket",
+			Usage: "Connect to this unix socket instead of the server address",
+		},
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "Verbose output",
+		},
+	}
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// clientTransport sets up the connection options shared by the client commands.
+func clientTransport(c *cli.Context) (*http.Transport, error) {
+	tlsConfig, err := clientTLSConfig(c.String("cert"), c.String("key"), c.String("cacert"), c.Bool("insecure"))
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	dial := (&net.Dialer{}).DialContext
+	if socket := c.String("unix-socket"); socket != "" {
+		// the host of the url is only used for the Host header and TLS
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		}
+	}
+	transport.DialContext = dial
+
+	if c.Bool("verbose") {
+		transport.DialContext = verboseDial(dial, nil)
+		transport.DialTLSContext = verboseDial(dial, tlsConfig)
+	}
+	return transport, nil
+}
+
+func verboseDial(dial dialFunc, tlsConfig *tls.Config) dialFunc {
 	return func(ctx context.Context, network, addr string) (net.Conn, error) {
 		log.Printf("-- Connecting to server '%s' ...", addr)
-		dialer := &net.Dialer{}
-		rawConn, err := dialer.DialContext(ctx, network, addr)
+		rawConn, err := dial(ctx, network, addr)
 		if err != nil {
 			log.Printf("   Connect failed: %s", err)
-		} else {
-			log.Printf("   Connected")
+			return nil, err
 		}
+		log.Printf("   Connected")
 
-		if !dialTLS {
-			return rawConn, err
+		if tlsConfig == nil {
+			return rawConn, nil
 		}
 
-		colonPos := strings.LastIndex(addr, ":")
-		if colonPos == -1 {
-			colonPos = len(addr)
+		config := tlsConfig.Clone()
+		if config.ServerName == "" {
+			colonPos := strings.LastIndex(addr, ":")
+			if colonPos == -1 {
+				colonPos = len(addr)
+			}
+			config.ServerName = addr[:colonPos]
 		}
-		hostname := addr[:colonPos]
-
-		conn := tls.Client(rawConn, &tls.Config{
-			ServerName:         hostname,
-			InsecureSkipVerify: insecure,
-		})
+		conn := tls.Client(rawConn, config)
 
 		log.Printf("-- TLS handshake ...")
 		if err := conn.HandshakeContext(ctx); err != nil {
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestNewRequestContentType(t *testing.T) {
+	uri, _ := url.Parse("http://example.com")
+	tests := []struct {
+		rr   *RequestResponse
+		want []string
+	}{
+		{&RequestResponse{}, nil},
+		{&RequestResponse{Body: "text"}, []string{"text/plain"}},
+		{&RequestResponse{Header: Header{{Name: "Content-Type", Value: "application/xml"}}, Body: "<a/>"}, []string{"application/xml"}},
+		{&RequestResponse{BodyFile: "0001-body.dat"}, nil},
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "0001-body.dat"), []byte{0, 1}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		req, err := newRequest(&Record{Method: "POST", URL: "/", Request: tt.rr}, uri, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Body.Close()
+		if got := req.Header.Values("Content-Type"); !slices.Equal(got, tt.want) {
+			t.Errorf("body %q: Content-Type = %q, want %q", tt.rr.Body, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/format"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// goString writes s as a Go string literal, a raw one for text with quotes
+// or several lines.
+func goString(s string) string {
+	if strings.ContainsAny(s, "\"\n") && !strings.ContainsAny(s, "`\r") && utf8.ValidString(s) &&
+		strings.IndexFunc(s, func(r rune) bool { return r != '\n' && r != '\t' && !unicode.IsPrint(r) }) < 0 {
+		return "`" + s + "`"
+	}
+	return strconv.Quote(s)
+}
+
+// jsString writes s as a JSON string, which is a valid JavaScript and
+// Python string literal as well.
+func jsString(s string) string {
+	buffer := &bytes.Buffer{}
+	enc := json.NewEncoder(buffer)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
+
+// joinedHeader merges repeated headers for languages taking a map, names
+// are in the order of their first appearance.
+func joinedHeader(h Header) ([]string, map[string]string) {
+	var names []string
+	values := make(map[string]string)
+	first := make(map[string]string)
+	for _, f := range h {
+		key := strings.ToLower(f.Name)
+		name, ok := first[key]
+		if !ok {
+			first[key] = f.Name
+			names = append(names, f.Name)
+			values[f.Name] = f.Value
+			continue
+		}
+		sep := ", "
+		if key == "cookie" {
+			sep = "; "
+		}
+		values[name] += sep + f.Value
+	}
+	return names, values
+}
+
+// goWriter writes the statements of a generated Go function and collects
+// the imports they need.
+type goWriter struct {
+	bytes.Buffer
+	imports map[string]bool
+	defined map[string]bool
+	// statement leaving the function on error
+	fail string
+}
+
+func newGoWriter(fail string) *goWriter {
+	return &goWriter{imports: make(map[string]bool), defined: make(map[string]bool), fail: fail}
+}
+
+func (w *goWriter) use(pkg ...string) {
+	for _, p := range pkg {
+		w.imports[p] = true
+	}
+}
+
+// define returns the assignment operator for names, ':=' if one of them is
+// new.
+func (w *goWriter) define(names ...string) string {
+	op := "="
+	for _, name := range names {
+		if !w.defined[name] {
+			w.defined[name] = true
+			op = ":="
+		}
+	}
+	return op
+}
+
+func (w *goWriter) line(format string, a ...interface{}) {
+	fmt.Fprintf(w, "\t"+format+"\n", a...)
+}
+
+func (w *goWriter) checkErr() {
+	w.line("if err != nil {")
+	w.line("\t%s", w.fail)
+	w.line("}")
+}
+
+// file writes the package clause, the imports and the collected code.
+func (w *goWriter) file(out io.Writer, pkg string) error {
+	imports := make([]string, 0, len(w.imports))
+	for p := range w.imports {
+		imports = append(imports, p)
+	}
+	sort.Strings(imports)
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "package %s\n\nimport (\n", pkg)
+	for _, p := range imports {
+		fmt.Fprintf(b, "\t%s\n", strconv.Quote(p))
+	}
+	fmt.Fprintf(b, ")\n\n")
+	b.Write(w.Bytes())
+
+	source, err := format.Source(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to format go code: %s", err)
+	}
+	_, err = out.Write(source)
+	return err
+}
+
+// body writes the statements creating the request body and returns the
+// expression of it.
+func (w *goWriter) body(ex *exportRequest) string {
+	switch {
+	case ex.BodyFile != "":
+		w.use("os")
+		w.line("body, err %s os.Open(%s)", w.define("body", "err"), goString(ex.BodyFile))
+		w.checkErr()
+		w.line("defer body.Close()")
+		w.line("")
+	case ex.Body != nil:
+		w.use("strings")
+		w.line("body := strings.NewReader(%s)", goString(string(ex.Body)))
+		w.line("")
+	case ex.Parts != nil:
+		w.use("bytes", "mime/multipart", "net/textproto")
+		w.line("body := &bytes.Buffer{}")
+		w.line("mw := multipart.NewWriter(body)")
+		for _, p := range ex.Parts {
+			w.line("")
+			w.line("part, err %s mw.CreatePart(textproto.MIMEHeader{", w.define("part", "err"))
+			w.line("\t\"Content-Disposition\": {%s},", goString(p.disposition()))
+			if p.ContentType != "" {
+				w.line("\t\"Content-Type\":        {%s},", goString(p.ContentType))
+			}
+			w.line("})")
+			w.checkErr()
+
+			if p.File != "" {
+				w.use("os")
+				w.line("content, err %s os.ReadFile(%s)", w.define("content", "err"), goString(p.File))
+				w.checkErr()
+				w.line("part.Write(content)")
+			} else {
+				w.use("io")
+				w.line("io.WriteString(part, %s)", goString(p.Value))
+			}
+		}
+		w.line("mw.Close()")
+		w.line("")
+	default:
+		return "nil"
+	}
+	return "body"
+}
+
+// headers writes the statements setting the request headers of req.
+func (w *goWriter) headers(ex *exportRequest) {
+	for _, f := range ex.Header {
+		w.line("req.Header.Add(%s, %s)", goString(f.Name), goString(f.Value))
+	}
+	if ex.Parts != nil {
+		w.line("req.Header.Set(\"Content-Type\", mw.FormDataContentType())")
+	}
+}
+
+func (p *exportPart) disposition() string {
+	disposition := fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(p.Name))
+	if p.Filename != "" {
+		disposition += fmt.Sprintf(`; filename="%s"`, quoteEscaper.Replace(p.Filename))
+	}
+	return disposition
+}
+
+// writeGo prints a Go program sending the request and printing the response.
+func writeGo(out io.Writer, ex *exportRequest) error {
+	w := newGoWriter("panic(err)")
+	w.use("fmt", "io", "net/http", "os")
+	fmt.Fprintf(w, "func main() {\n")
+	body := w.body(ex)
+	w.line("req, err %s http.NewRequest(%s, %s, %s)", w.define("req", "err"), goString(ex.Method), goString(ex.URL), body)
+	w.checkErr()
+	w.headers(ex)
+	w.line("")
+	w.line("resp, err %s http.DefaultClient.Do(req)", w.define("resp", "err"))
+	w.checkErr()
+	w.line("defer resp.Body.Close()")
+	w.line("")
+	w.line("fmt.Println(resp.Status)")
+	w.line("io.Copy(os.Stdout, resp.Body)")
+	fmt.Fprintf(w, "}\n")
+	return w.file(out, "main")
+}
+
+// writeGoTest prints a test sending the request to handler through
+// httptest and asserting the status, content type and body of the recorded
+// response.
+func writeGoTest(out io.Writer, ex *exportRequest, pkg string, handler string) error {
+	resp := ex.Response
+	if resp == nil || resp.Status == 0 {
+		return errors.New("record has no response to assert")
+	}
+	target, err := url.Parse(ex.URL)
+	if err != nil {
+		return fmt.Errorf("failed to parse url: %s", err)
+	}
+
+	w := newGoWriter("t.Fatal(err)")
+	w.use("net/http/httptest", "testing")
+	fmt.Fprintf(w, "func %s(t *testing.T) {\n", goTestName(ex.Method, target.Path))
+	body := w.body(ex)
+	w.line("req := httptest.NewRequest(%s, %s, %s)", goString(ex.Method), goString(target.RequestURI()), body)
+	w.defined["req"] = true
+	w.headers(ex)
+	w.line("")
+	w.line("rec := httptest.NewRecorder()")
+	w.line("%s.ServeHTTP(rec, req)", handler)
+	w.line("")
+	w.line("if rec.Code != %d {", resp.Status)
+	w.line("\tt.Fatalf(\"status = %%d, want %d\", rec.Code)", resp.Status)
+	w.line("}")
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.line("if got := rec.Header().Get(\"Content-Type\"); got != %s {", goString(contentType))
+		w.line("\tt.Errorf(\"Content-Type = %%q, want %%q\", got, %s)", goString(contentType))
+		w.line("}")
+	}
+
+	switch {
+	case resp.BodyJson != nil:
+		compact := &bytes.Buffer{}
+		if err := json.Compact(compact, resp.BodyJson); err != nil {
+			return fmt.Errorf("failed to parse response json: %s", err)
+		}
+		w.use("encoding/json", "reflect")
+		w.line("")
+		w.line("want := %s", goString(compact.String()))
+		w.line("var got, wantJSON interface{}")
+		w.line("if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {")
+		w.line("\tt.Fatalf(\"response body is no JSON: %%s\\n%%s\", err, rec.Body)")
+		w.line("}")
+		w.line("if err := json.Unmarshal([]byte(want), &wantJSON); err != nil {")
+		w.line("\tt.Fatal(err)")
+		w.line("}")
+		w.line("if !reflect.DeepEqual(got, wantJSON) {")
+		w.line("\tt.Errorf(\"body = %%s, want %%s\", rec.Body, want)")
+		w.line("}")
+	case resp.BodyFile != "":
+		w.use("bytes", "os")
+		w.line("")
+		w.line("want, err %s os.ReadFile(%s)", w.define("want", "err"), goString(filepath.Join(ex.Dir, resp.BodyFile)))
+		w.checkErr()
+		w.line("if !bytes.Equal(rec.Body.Bytes(), want) {")
+		w.line("\tt.Errorf(%s)", goString("body differs from "+strings.ReplaceAll(filepath.Join(ex.Dir, resp.BodyFile), "%", "%%")))
+		w.line("}")
+	case resp.BodyMultiPart != nil:
+		w.line("// the multipart body is not checked")
+	default:
+		w.line("")
+		w.line("if got, want := rec.Body.String(), %s; got != want {", goString(resp.Body))
+		w.line("\tt.Errorf(\"body = %%q, want %%q\", got, want)")
+		w.line("}")
+	}
+	fmt.Fprintf(w, "}\n")
+	return w.file(out, pkg)
+}
+
+// goTestName is Test followed by the method and the words of the path,
+// e.g. TestPostApiUsers.
+func goTestName(method string, urlPath string) string {
+	b := &strings.Builder{}
+	b.WriteString("Test")
+	words := strings.FieldsFunc(strings.ToLower(method)+"/"+urlPath, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		r, size := utf8.DecodeRuneInString(word)
+		b.WriteRune(unicode.ToUpper(r))
+		b.WriteString(word[size:])
+	}
+	return b.String()
+}
+
+var pythonMethods = map[string]bool{
+	"GET": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true, "HEAD": true, "OPTIONS": true,
+}
+
+// writePython prints a script sending the request with the requests
+// package.
+func writePython(out io.Writer, ex *exportRequest) error {
+	b := &bytes.Buffer{}
+	b.WriteString("import requests\n\n")
+	fmt.Fprintf(b, "url = %s\n", jsString(ex.URL))
+
+	args := []string{"url"}
+	if len(ex.Header) > 0 {
+		names, values := joinedHeader(ex.Header)
+		b.WriteString("headers = {\n")
+		for _, name := range names {
+			fmt.Fprintf(b, "    %s: %s,\n", jsString(name), jsString(values[name]))
+		}
+		b.WriteString("}\n")
+		args = append(args, "headers=headers")
+	}
+
+	switch {
+	case ex.BodyFile != "":
+		fmt.Fprintf(b, "data = open(%s, \"rb\")\n", jsString(ex.BodyFile))
+		args = append(args, "data=data")
+	case ex.Body != nil:
+		fmt.Fprintf(b, "data = %s\n", jsString(string(ex.Body)))
+		args = append(args, "data=data")
+	case ex.Parts != nil:
+		b.WriteString("files = [\n")
+		for _, p := range ex.Parts {
+			filename := "None"
+			if p.Filename != "" {
+				filename = jsString(p.Filename)
+			}
+			content := jsString(p.Value)
+			if p.File != "" {
+				content = fmt.Sprintf("open(%s, \"rb\")", jsString(p.File))
+			}
+			if p.ContentType != "" {
+				fmt.Fprintf(b, "    (%s, (%s, %s, %s)),\n", jsString(p.Name), filename, content, jsString(p.ContentType))
+			} else {
+				fmt.Fprintf(b, "    (%s, (%s, %s)),\n", jsString(p.Name), filename, content)
+			}
+		}
+		b.WriteString("]\n")
+		args = append(args, "files=files")
+	}
+
+	b.WriteString("\n")
+	if pythonMethods[ex.Method] {
+		fmt.Fprintf(b, "response = requests.%s(%s)\n", strings.ToLower(ex.Method), strings.Join(args, ", "))
+	} else {
+		fmt.Fprintf(b, "response = requests.request(%s, %s)\n", jsString(ex.Method), strings.Join(args, ", "))
+	}
+	b.WriteString("print(response.status_code, response.reason)\n")
+	b.WriteString("print(response.text)\n")
+	_, err := out.Write(b.Bytes())
+	return err
+}
+
+// writeJS prints an ES module sending the request with fetch, files are
+// read with node:fs.
+func writeJS(out io.Writer, ex *exportRequest) error {
+	b := &bytes.Buffer{}
+	usesFiles := ex.BodyFile != ""
+	for _, p := range ex.Parts {
+		usesFiles = usesFiles || p.File != ""
+	}
+	if usesFiles {
+		b.WriteString("import { readFile } from \"node:fs/promises\";\n\n")
+	}
+
+	var body string
+	switch {
+	case ex.BodyFile != "":
+		body = fmt.Sprintf("await readFile(%s)", jsString(ex.BodyFile))
+	case ex.Body != nil:
+		body = jsString(string(ex.Body))
+	case ex.Parts != nil:
+		b.WriteString("const form = new FormData();\n")
+		for _, p := range ex.Parts {
+			var value string
+			switch {
+			case p.Filename != "":
+				content := jsString(p.Value)
+				if p.File != "" {
+					content = fmt.Sprintf("await readFile(%s)", jsString(p.File))
+				}
+				if p.ContentType != "" {
+					value = fmt.Sprintf("new Blob([%s], { type: %s }), %s", content, jsString(p.ContentType), jsString(p.Filename))
+				} else {
+					value = fmt.Sprintf("new Blob([%s]), %s", content, jsString(p.Filename))
+				}
+			case p.File != "":
+				value = fmt.Sprintf("await readFile(%s, \"utf8\")", jsString(p.File))
+			default:
+				value = jsString(p.Value)
+			}
+			fmt.Fprintf(b, "form.append(%s, %s);\n", jsString(p.Name), value)
+		}
+		b.WriteString("\n")
+		body = "form"
+	}
+
+	fmt.Fprintf(b, "const response = await fetch(%s, {\n", jsString(ex.URL))
+	fmt.Fprintf(b, "  method: %s,\n", jsString(ex.Method))
+	if len(ex.Header) > 0 {
+		names, values := joinedHeader(ex.Header)
+		b.WriteString("  headers: {\n")
+		for _, name := range names {
+			fmt.Fprintf(b, "    %s: %s,\n", jsString(name), jsString(values[name]))
+		}
+		b.WriteString("  },\n")
+	}
+	if body != "" {
+		fmt.Fprintf(b, "  body: %s,\n", body)
+	}
+	b.WriteString("});\n")
+	b.WriteString("console.log(response.status, response.statusText);\n")
+	b.WriteString("console.log(await response.text());\n")
+	_, err := out.Write(b.Bytes())
+	return err
+}
+
+// writeHTTPie prints an HTTPie command sending the request.
+func writeHTTPie(out io.Writer, ex *exportRequest) error {
+	command := "http"
+	if ex.Parts != nil {
+		command += " --multipart"
+	}
+	if ex.Body != nil {
+		command += " --raw " + shellQuote(string(ex.Body))
+	}
+	args := []string{command + " " + shellQuote(ex.Method) + " " + shellQuote(ex.URL)}
+
+	for _, f := range ex.Header {
+		if f.Value == "" {
+			args = append(args, shellQuote(httpieKey(f.Name)+";"))
+		} else {
+			args = append(args, shellQuote(httpieKey(f.Name)+":"+f.Value))
+		}
+	}
+	if ex.BodyFile != "" {
+		args = append(args, shellQuote("@"+ex.BodyFile))
+	}
+	for _, p := range ex.Parts {
+		switch {
+		case p.File != "" && p.Filename != "":
+			item := httpieKey(p.Name) + "@" + p.File
+			if p.ContentType != "" {
+				item += ";type=" + p.ContentType
+			}
+			args = append(args, shellQuote(item))
+		case p.File != "":
+			// '=@' sends the content of the file as a field
+			args = append(args, shellQuote(httpieKey(p.Name)+"=@"+p.File))
+		default:
+			args = append(args, shellQuote(httpieKey(p.Name)+"="+p.Value))
+		}
+	}
+
+	_, err := fmt.Fprintln(out, strings.Join(args, " \\\n  "))
+	return err
+}
+
+// httpieKey escapes the separators of HTTPie request items in a name.
+func httpieKey(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ":", `\:`, "=", `\=`, "@", `\@`, ";", `\;`).Replace(s)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// the standard library is type checked from source once for all tests
+var (
+	generatedFset     = token.NewFileSet()
+	generatedImporter = importer.ForCompiler(generatedFset, "source", nil)
+)
+
+// typeCheckGo parses and type checks generated Go source, which catches
+// unused imports and variables and redeclared names.
+func typeCheckGo(t *testing.T, source string) {
+	t.Helper()
+	file, err := parser.ParseFile(generatedFset, "generated.go", source, 0)
+	if err != nil {
+		t.Fatalf("generated code does not parse: %s\n%s", err, source)
+	}
+	conf := types.Config{Importer: generatedImporter}
+	if file.Name.Name != "main" {
+		// the handler of a generated test lives in the package under test
+		conf.Error = func(err error) {
+			if !strings.Contains(err.Error(), "undefined: handler") {
+				t.Errorf("generated code: %s\n%s", err, source)
+			}
+		}
+	}
+	if _, err := conf.Check("generated", generatedFset, []*ast.File{file}, nil); err != nil && conf.Error == nil {
+		t.Fatalf("generated code: %s\n%s", err, source)
+	}
+}
+
+func testExportRequests() map[string]*exportRequest {
+	return map[string]*exportRequest{
+		"get": {
+			Method: "GET",
+			URL:    "http://example.com/items?page=2",
+			Header: Header{{Name: "Accept", Value: "text/plain"}, {Name: "Cookie", Value: "a=1"}, {Name: "cookie", Value: "b=2"}},
+		},
+		"json": {
+			Method: "POST",
+			URL:    "http://example.com/items",
+			Header: Header{{Name: "Content-Type", Value: "application/json"}},
+			Body:   []byte(`{"name":"it's \"quoted\""}`),
+		},
+		"file": {
+			Method:   "PUT",
+			URL:      "http://example.com/files/a.bin",
+			BodyFile: "records/0001-body.bin",
+		},
+		"multipart": {
+			Method: "POST",
+			URL:    "http://example.com/upload",
+			Parts: []*exportPart{
+				{Name: "title", Value: "a;b"},
+				{Name: "file", Filename: "a.txt", ContentType: "text/plain", File: "records/0001-part1.txt"},
+				{Name: "notes", File: "records/0001-part2.txt"},
+			},
+		},
+		"purge": {
+			Method: "PURGE",
+			URL:    "http://example.com/cache",
+		},
+	}
+}
+
+func TestGoString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain", `"plain"`},
+		{`{"a":1}`, "`{\"a\":1}`"},
+		{"two\nlines", "`two\nlines`"},
+		{"back`tick\"", "\"back`tick\\\"\""},
+		{"cr\r\n\"", `"cr\r\n\""`},
+		{"ctrl\x01\"", `"ctrl\x01\""`},
+		{"bad\xff\"", `"bad\xff\""`},
+	}
+	for _, tt := range tests {
+		got := goString(tt.in)
+		if got != tt.want {
+			t.Errorf("goString(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+		if unquoted, err := strconv.Unquote(got); err != nil || unquoted != tt.in {
+			t.Errorf("goString(%q) = %s reads back as %q, %v", tt.in, got, unquoted, err)
+		}
+	}
+}
+
+func TestJSString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain", `"plain"`},
+		{`a "b" \c`, `"a \"b\" \\c"`},
+		{"<script>&", `"<script>&"`},
+		{"line\nnext", `"line\nnext"`},
+		{"äö€", `"äö€"`},
+	}
+	for _, tt := range tests {
+		if got := jsString(tt.in); got != tt.want {
+			t.Errorf("jsString(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestJoinedHeader(t *testing.T) {
+	names, values := joinedHeader(Header{
+		{Name: "Accept", Value: "a"},
+		{Name: "Cookie", Value: "x=1"},
+		{Name: "accept", Value: "b"},
+		{Name: "cookie", Value: "y=2"},
+	})
+	if want := []string{"Accept", "Cookie"}; !slices.Equal(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+	if values["Accept"] != "a, b" || values["Cookie"] != "x=1; y=2" {
+		t.Errorf("values = %v", values)
+	}
+}
+
+func TestGoTestName(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{"POST", "/api/users", "TestPostApiUsers"},
+		{"GET", "/", "TestGet"},
+		{"DELETE", "/items/42/tags-all", "TestDeleteItems42TagsAll"},
+		{"GET", "/über", "TestGetÜber"},
+	}
+	for _, tt := range tests {
+		if got := goTestName(tt.method, tt.path); got != tt.want {
+			t.Errorf("goTestName(%s, %s) = %s, want %s", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestWriteGo(t *testing.T) {
+	for name, ex := range testExportRequests() {
+		t.Run(name, func(t *testing.T) {
+			buffer := &bytes.Buffer{}
+			if err := writeGo(buffer, ex); err != nil {
+				t.Fatal(err)
+			}
+			source := buffer.String()
+			typeCheckGo(t, source)
+			if !strings.Contains(source, "http.NewRequest("+strconv.Quote(ex.Method)+", "+strconv.Quote(ex.URL)) {
+				t.Errorf("request not in the program:\n%s", source)
+			}
+		})
+	}
+}
+
+func TestWriteGoTest(t *testing.T) {
+	responses := map[string]*RequestResponse{
+		"json": {
+			Status:   201,
+			Header:   Header{{Name: "Content-Type", Value: "application/json"}},
+			BodyJson: []byte(`{"id": 1}`),
+		},
+		"text": {Status: 200, Body: "100% done"},
+		"file": {Status: 200, BodyFile: "0001-response.bin"},
+	}
+	for name, resp := range responses {
+		t.Run(name, func(t *testing.T) {
+			ex := testExportRequests()["multipart"]
+			ex.Response = resp
+			ex.Dir = "records"
+			buffer := &bytes.Buffer{}
+			if err := writeGoTest(buffer, ex, "api", "handler"); err != nil {
+				t.Fatal(err)
+			}
+			source := buffer.String()
+			typeCheckGo(t, source)
+			for _, want := range []string{"package api", "func TestPostUpload(t *testing.T)", `httptest.NewRequest("POST", "/upload", body)`, "handler.ServeHTTP(rec, req)", "if rec.Code != " + strconv.Itoa(resp.Status)} {
+				if !strings.Contains(source, want) {
+					t.Errorf("%q not in the test:\n%s", want, source)
+				}
+			}
+		})
+	}
+
+	ex := testExportRequests()["get"]
+	if err := writeGoTest(&bytes.Buffer{}, ex, "main", "handler"); err == nil {
+		t.Error("expected an error for a record without a response")
+	}
+}
+
+func TestWritePython(t *testing.T) {
+	tests := map[string][]string{
+		"get":       {`url = "http://example.com/items?page=2"`, `"Cookie": "a=1; b=2",`, "response = requests.get(url, headers=headers)"},
+		"json":      {`data = "{\"name\":\"it's \\\"quoted\\\"\"}"`, "response = requests.post(url, headers=headers, data=data)"},
+		"file":      {`data = open("records/0001-body.bin", "rb")`, "response = requests.put(url, data=data)"},
+		"multipart": {`("title", (None, "a;b")),`, `("file", ("a.txt", open("records/0001-part1.txt", "rb"), "text/plain")),`, "response = requests.post(url, files=files)"},
+		"purge":     {`response = requests.request("PURGE", url)`},
+	}
+	requests := testExportRequests()
+	for name, wants := range tests {
+		buffer := &bytes.Buffer{}
+		if err := writePython(buffer, requests[name]); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range wants {
+			if !strings.Contains(buffer.String(), want) {
+				t.Errorf("%s: %q not in the script:\n%s", name, want, buffer)
+			}
+		}
+	}
+}
+
+func TestWriteJS(t *testing.T) {
+	tests := map[string][]string{
+		"get":       {`const response = await fetch("http://example.com/items?page=2", {`, `"Accept": "text/plain",`},
+		"json":      {`method: "POST",`, `body: "{\"name\":\"it's \\\"quoted\\\"\"}",`},
+		"file":      {`import { readFile } from "node:fs/promises";`, `body: await readFile("records/0001-body.bin"),`},
+		"multipart": {`form.append("title", "a;b");`, `form.append("file", new Blob([await readFile("records/0001-part1.txt")], { type: "text/plain" }), "a.txt");`, `form.append("notes", await readFile("records/0001-part2.txt", "utf8"));`, "body: form,"},
+	}
+	requests := testExportRequests()
+	for name, wants := range tests {
+		buffer := &bytes.Buffer{}
+		if err := writeJS(buffer, requests[name]); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range wants {
+			if !strings.Contains(buffer.String(), want) {
+				t.Errorf("%s: %q not in the module:\n%s", name, want, buffer)
+			}
+		}
+	}
+}
+
+func TestWriteHTTPie(t *testing.T) {
+	tests := map[string][]string{
+		"get":       {"http", "GET", "http://example.com/items?page=2", "Accept:text/plain", "Cookie:a=1", "cookie:b=2"},
+		"json":      {"http", "--raw", `{"name":"it's \"quoted\""}`, "POST", "http://example.com/items", "Content-Type:application/json"},
+		"file":      {"http", "PUT", "http://example.com/files/a.bin", "@records/0001-body.bin"},
+		"multipart": {"http", "--multipart", "POST", "http://example.com/upload", "title=a;b", "file@records/0001-part1.txt;type=text/plain", "notes=@records/0001-part2.txt"},
+	}
+	requests := testExportRequests()
+	for name, want := range tests {
+		buffer := &bytes.Buffer{}
+		if err := writeHTTPie(buffer, requests[name]); err != nil {
+			t.Fatal(err)
+		}
+		words, err := shellWords(buffer.String())
+		if err != nil {
+			t.Fatalf("%s: %s\n%s", name, err, buffer)
+		}
+		if !slices.Equal(words, want) {
+			t.Errorf("%s: words = %q, want %q", name, words, want)
+		}
+	}
+}
+
+func TestHTTPieKey(t *testing.T) {
+	if got, want := httpieKey(`a:b=c@d;e\f`), `a\:b\=c\@d\;e\\f`; got != want {
+		t.Errorf("httpieKey = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// serverConfig holds every setting of the server command. It is read from the
+// config file, flags given on the command line take precedence.
+type serverConfig struct {
+	Listen       string `json:"listen,omitempty"`
+	HTTPS        bool   `json:"https,omitempty"`
+	Cert         string `json:"cert,omitempty"`
+	Key          string `json:"key,omitempty"`
+	ClientCA     string `json:"client_ca,omitempty"`
+	ClientAuth   string `json:"client_auth,omitempty"`
+	SocketMode   string `json:"socket_mode,omitempty"`
+	RawHeaders   bool   `json:"raw_headers,omitempty"`
+	Save         string `json:"save,omitempty"`
+	Num          int    `json:"num,omitempty"`
+	MaxBody      int64  `json:"max_body,omitempty"`
+	Admin        string `json:"admin,omitempty"`
+	AdminRecords int    `json:"admin_records,omitempty"`
+	LogFormat    string `json:"log_format,omitempty"`
+	AccessLog    string `json:"access_log,omitempty"`
+	Jsonl        string `json:"jsonl,omitempty"`
+
+	TunnelListen string          `json:"tunnel_listen,omitempty"`
+	TunnelToken  string          `json:"tunnel_token,omitempty"`
+	TunnelCert   string          `json:"tunnel_cert,omitempty"`
+	TunnelKey    string          `json:"tunnel_key,omitempty"`
+	Responder    responderConfig `json:"responder"`
+	Filters      filterConfig    `json:"filters"`
+	Redact       redactConfig    `json:"redact"`
+	Verify       verifiers       `json:"verify,omitempty"`
+	Tee          teeConfig       `json:"tee"`
+
+	Listeners []*listenerConfig `json:"listeners,omitempty"`
+}
+
+// listenerConfig is one listening socket with its own profile. Unset
+// responder, filters and redaction are taken from the top level config, the
+// save directory is relative to the top level one.
+type listenerConfig struct {
+	Name       string           `json:"name,omitempty"`
+	Listen     string           `json:"listen,omitempty"`
+	HTTPS      bool             `json:"https,omitempty"`
+	Cert       string           `json:"cert,omitempty"`
+	Key        string           `json:"key,omitempty"`
+	ClientCA   string           `json:"client_ca,omitempty"`
+	ClientAuth string           `json:"client_auth,omitempty"`
+	SocketMode string           `json:"socket_mode,omitempty"`
+	RawHeaders bool             `json:"raw_headers,omitempty"`
+	Save       string           `json:"save,omitempty"`
+	MaxBody    int64            `json:"max_body,omitempty"`
+	Responder  *responderConfig `json:"responder,omitempty"`
+	Filters    *filterConfig    `json:"filters,omitempty"`
+	Redact     *redactConfig    `json:"redact,omitempty"`
+	Verify     verifiers        `json:"verify,omitempty"`
+	Tee        *teeConfig       `json:"tee,omitempty"`
+}
+
+func loadServerConfig(c *cli.Context) (*serverConfig, error) {
+	cfg := &serverConfig{}
+	if c.String("config") != "" {
+		if err := readConfigFile(c.String("config"), cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	setString := func(v *string, name string) {
+		if c.IsSet(name) || *v == "" {
+			*v = c.String(name)
+		}
+	}
+	setInt := func(v *int, name string) {
+		if c.IsSet(name) || *v == 0 {
+			*v = c.Int(name)
+		}
+	}
+
+	setString(&cfg.Listen, "listen")
+	if c.IsSet("https") {
+		cfg.HTTPS = c.Bool("https")
+	}
+	setString(&cfg.Cert, "cert")
+	setString(&cfg.Key, "key")
+	setString(&cfg.ClientCA, "client-ca")
+	setString(&cfg.ClientAuth, "client-auth")
+	setString(&cfg.SocketMode, "socket-mode")
+	if c.IsSet("raw-headers") {
+		cfg.RawHeaders = c.Bool("raw-headers")
+	}
+	setString(&cfg.Save, "save")
+	setInt(&cfg.Num, "num")
+	if c.IsSet("max-body") || cfg.MaxBody == 0 {
+		cfg.MaxBody = c.Int64("max-body")
+	}
+	setString(&cfg.Admin, "admin")
+	setInt(&cfg.AdminRecords, "admin-records")
+	setString(&cfg.LogFormat, "log-format")
+	setString(&cfg.AccessLog, "access-log")
+	setString(&cfg.Jsonl, "jsonl")
+	setString(&cfg.TunnelListen, "tunnel-listen")
+	setString(&cfg.TunnelToken, "tunnel-token")
+	setString(&cfg.TunnelCert, "tunnel-cert")
+	setString(&cfg.TunnelKey, "tunnel-key")
+
+	res := &cfg.Responder
+	setInt(&res.Status, "status")
+	setString(&res.Body, "body")
+	setString(&res.Proxy, "proxy")
+	if c.IsSet("tunnel") {
+		res.Tunnel = c.Bool("tunnel")
+	}
+	setString(&res.WWWRoot, "wwwroot")
+	setString(&res.Scenario, "scenario")
+	setString(&res.Playback, "playback")
+	if c.IsSet("match") || len(res.Match) == 0 {
+		res.Match = c.StringSlice("match")
+	}
+	setString(&res.OnMiss, "on-miss")
+
+	if c.IsSet("tee") {
+		cfg.Tee.Targets = c.StringSlice("tee")
+	}
+	setInt(&cfg.Tee.Queue, "tee-queue")
+	setInt(&cfg.Tee.Retries, "tee-retries")
+	setString(&cfg.Tee.Timeout, "tee-timeout")
+
+	if c.IsSet("verify") {
+		cfg.Verify = verifiers{{
+			Scheme: c.String("verify"),
+			Secret: c.String("verify-secret"),
+			Reject: c.Bool("verify-reject"),
+		}}
+	}
+
+	if err := cfg.prepare(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// readConfigFile reads a yaml (or json) file into cfg. The document is
+// converted to json first, so the config shares the field names and types of
+// records.
+func readConfigFile(filename string, cfg interface{}) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %s", err)
+	}
+
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %s", filename, err)
+	}
+	if doc == nil {
+		return nil
+	}
+
+	data, err = json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to convert config file %s: %s", filename, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("invalid config file %s: %s", filename, err)
+	}
+	return nil
+}
+
+// prepare resolves the listeners, without a listener list the top level
+// settings make up the only listener.
+func (cfg *serverConfig) prepare() error {
+	if cfg.TunnelListen != "" && cfg.TunnelToken == "" {
+		return errors.New("tunnel token is required for the tunnel endpoint")
+	}
+	if (cfg.TunnelCert == "") != (cfg.TunnelKey == "") {
+		return errors.New("both tunnel certificate and key are required")
+	}
+
+	if len(cfg.Listeners) == 0 {
+		cfg.Listeners = []*listenerConfig{{
+			Listen:     cfg.Listen,
+			HTTPS:      cfg.HTTPS,
+			Cert:       cfg.Cert,
+			Key:        cfg.Key,
+			ClientCA:   cfg.ClientCA,
+			ClientAuth: cfg.ClientAuth,
+			SocketMode: cfg.SocketMode,
+			RawHeaders: cfg.RawHeaders,
+		}}
+	}
+
+	names := make(map[string]bool)
+	for i, l := range cfg.Listeners {
+		if len(cfg.Listeners) > 1 {
+			if l.Name == "" {
+				return fmt.Errorf("listener %d: name is required", i)
+			}
+			if names[l.Name] {
+				return fmt.Errorf("listener %d: duplicated name '%s'", i, l.Name)
+			}
+			names[l.Name] = true
+		}
+
+		if l.Responder == nil {
+			l.Responder = &cfg.Responder
+		}
+		if l.Responder.Tunnel && cfg.TunnelListen == "" {
+			return fmt.Errorf("listener %d: tunnel responder requires the tunnel endpoint, set tunnel_listen", i)
+		}
+		if l.Filters == nil {
+			l.Filters = &cfg.Filters
+		}
+		if l.Redact == nil {
+			l.Redact = &cfg.Redact
+		}
+		if l.Verify == nil {
+			l.Verify = cfg.Verify
+		}
+		if l.Tee == nil {
+			l.Tee = &cfg.Tee
+		}
+
+		if l.MaxBody == 0 {
+			l.MaxBody = cfg.MaxBody
+		}
+		if l.Save == "" {
+			l.Save = l.Name
+		}
+		if !filepath.IsAbs(l.Save) {
+			l.Save = filepath.Join(cfg.Save, l.Save)
+		}
+
+		if err := l.prepare(); err != nil {
+			if l.Name != "" {
+				return fmt.Errorf("listener '%s': %s", l.Name, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// prepare fills the defaults depending on whether TLS is enabled.
+func (l *listenerConfig) prepare() error {
+	isTls := l.HTTPS || (l.Cert != "" && l.Key != "")
+	if isTls {
+		l.HTTPS = true
+		if l.Listen == "" {
+			l.Listen = ":443"
+		}
+		if l.Cert == "" && l.Key == "" {
+			l.Cert = "cert.pem"
+			l.Key = "key.pem"
+		}
+		if l.Cert == "" {
+			return errors.New("TLS certificate file is required")
+		}
+		if l.Key == "" {
+			return errors.New("TLS key file is required")
+		}
+	} else {
+		if l.Listen == "" {
+			l.Listen = ":8080"
+		}
+	}
+
+	if l.ClientAuth == "" {
+		l.ClientAuth = "none"
+		if l.ClientCA != "" {
+			l.ClientAuth = "verify"
+		}
+	}
+	if _, ok := clientAuthTypes[l.ClientAuth]; !ok {
+		return fmt.Errorf("unknown client auth '%s', expect 'none', 'request', 'require' or 'verify'", l.ClientAuth)
+	}
+	if l.ClientAuth != "none" && !l.HTTPS {
+		return errors.New("client auth requires HTTPS")
+	}
+	if l.ClientAuth == "verify" && l.ClientCA == "" {
+		return errors.New("client CA file is required to verify client certificates")
+	}
+	return l.Verify.prepare()
+}
+
+// restartSettings lists the changed settings which are only read at startup.
+func restartSettings(running, cfg *serverConfig) []string {
+	var changed []string
+	check := func(name string, a, b interface{}) {
+		if a != b {
+			changed = append(changed, name)
+		}
+	}
+	check("num", running.Num, cfg.Num)
+	check("admin", running.Admin, cfg.Admin)
+	check("admin_records", running.AdminRecords, cfg.AdminRecords)
+	check("log_format", running.LogFormat, cfg.LogFormat)
+	check("access_log", running.AccessLog, cfg.AccessLog)
+	check("jsonl", running.Jsonl, cfg.Jsonl)
+	check("tunnel_listen", running.TunnelListen, cfg.TunnelListen)
+	check("tunnel_token", running.TunnelToken, cfg.TunnelToken)
+	check("tunnel_cert", running.TunnelCert, cfg.TunnelCert)
+	check("tunnel_key", running.TunnelKey, cfg.TunnelKey)
+
+	if len(running.Listeners) != len(cfg.Listeners) {
+		return append(changed, "listeners")
+	}
+	for i, l := range cfg.Listeners {
+		old := running.Listeners[i]
+		prefix := ""
+		if l.Name != "" {
+			prefix = l.Name + "."
+		}
+		check(prefix+"name", old.Name, l.Name)
+		check(prefix+"listen", old.Listen, l.Listen)
+		check(prefix+"https", old.HTTPS, l.HTTPS)
+		check(prefix+"cert", old.Cert, l.Cert)
+		check(prefix+"key", old.Key, l.Key)
+		check(prefix+"client_ca", old.ClientCA, l.ClientCA)
+		check(prefix+"client_auth", old.ClientAuth, l.ClientAuth)
+		check(prefix+"socket_mode", old.SocketMode, l.SocketMode)
+		check(prefix+"raw_headers", old.RawHeaders, l.RawHeaders)
+		check(prefix+"save", old.Save, l.Save)
+		check(prefix+"max_body", old.MaxBody, l.MaxBody)
+		check(prefix+"tee", sameJson(old.Tee, l.Tee), true)
+	}
+	return changed
+}
+
+// watchConfig polls the config file and applies changes of the responders,
+// filters and redaction to the recorders without restarting the server.
+func watchConfig(c *cli.Context, filename string, running *serverConfig, recorders []*recorder) {
+	var modTime time.Time
+	if fi, err := os.Stat(filename); err == nil {
+		modTime = fi.ModTime()
+	}
+
+	for range time.Tick(2 * time.Second) {
+		fi, err := os.Stat(filename)
+		if err != nil || fi.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = fi.ModTime()
+
+		cfg, err := loadServerConfig(c)
+		if err != nil {
+			log.Printf("failed to reload config, keep running with the old one: %v", err)
+			continue
+		}
+		log.Printf("Config file '%s' changed", filename)
+
+		applyConfig(running, cfg, recorders)
+	}
+}
+
+// applyConfig applies a changed config to the running listeners, matched by
+// position and name.
+func applyConfig(running, cfg *serverConfig, recorders []*recorder) {
+	if changed := restartSettings(running, cfg); len(changed) > 0 {
+		log.Printf("Config of %s changed, restart the server to apply", strings.Join(changed, ", "))
+	}
+
+	for i, l := range cfg.Listeners {
+		if i >= len(running.Listeners) || running.Listeners[i].Name != l.Name {
+			break
+		}
+		applyListenerConfig(running.Listeners[i], l, recorders[i])
+	}
+}
+
+func applyListenerConfig(running, l *listenerConfig, rec *recorder) {
+	name := ""
+	if l.Name != "" {
+		name = fmt.Sprintf(" of listener '%s'", l.Name)
+	}
+
+	if !sameJson(running.Responder, l.Responder) {
+		if err := rec.setResponder(l.Responder); err != nil {
+			log.Printf("failed to reload responder%s: %v", name, err)
+		} else {
+			running.Responder = l.Responder
+			rec.setRoots(l)
+			log.Printf("Responder%s reloaded", name)
+		}
+	}
+
+	if !sameJson(running.Filters, l.Filters) || !sameJson(running.Redact, l.Redact) || !sameJson(running.Verify, l.Verify) {
+		rec.setRules(l.Filters, l.Redact, l.Verify)
+		running.Filters = l.Filters
+		running.Redact = l.Redact
+		running.Verify = l.Verify
+		log.Printf("Filters, redaction and verification%s reloaded", name)
+	}
+}
+
+func sameJson(a, b interface{}) bool {
+	dataA, errA := json.Marshal(a)
+	dataB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
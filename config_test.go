@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testListenersConfig = `
+save: %s
+max_body: 1024
+responder:
+  status: 204
+filters:
+  exclude_paths: [/health]
+listeners:
+  - name: api
+    listen: 127.0.0.1:0
+    responder:
+      status: 201
+      body: created
+  - name: hooks
+    listen: 127.0.0.1:0
+    save: /tmp/hooks
+    max_body: 64
+    filters:
+      methods: [POST]
+`
+
+// readTestConfig writes config to a file and reads it the way the server does.
+func readTestConfig(t *testing.T, config string) *serverConfig {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filename, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &serverConfig{}
+	if err := readConfigFile(filename, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestConfigListeners(t *testing.T) {
+	save := t.TempDir()
+	cfg := readTestConfig(t, strings.Replace(testListenersConfig, "%s", save, 1))
+
+	if len(cfg.Listeners) != 2 {
+		t.Fatalf("listeners = %d, want 2", len(cfg.Listeners))
+	}
+	api, hooks := cfg.Listeners[0], cfg.Listeners[1]
+
+	if api.Save != filepath.Join(save, "api") || hooks.Save != "/tmp/hooks" {
+		t.Errorf("save = %s, %s", api.Save, hooks.Save)
+	}
+	if api.MaxBody != 1024 || hooks.MaxBody != 64 {
+		t.Errorf("max body = %d, %d, want 1024, 64", api.MaxBody, hooks.MaxBody)
+	}
+	if api.Responder.Status != 201 || hooks.Responder.Status != 204 {
+		t.Errorf("responder status = %d, %d, want 201, 204", api.Responder.Status, hooks.Responder.Status)
+	}
+	if !slices.Equal(api.Filters.ExcludePaths, []string{"/health"}) || !slices.Equal(hooks.Filters.Methods, []string{"POST"}) || hooks.Filters.ExcludePaths != nil {
+		t.Errorf("filters = %+v, %+v", api.Filters, hooks.Filters)
+	}
+	if api.ClientAuth != "none" || api.HTTPS {
+		t.Errorf("api tls = %v %s", api.HTTPS, api.ClientAuth)
+	}
+}
+
+func TestConfigListenersInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *serverConfig
+		want string
+	}{
+		{
+			"no name",
+			&serverConfig{Listeners: []*listenerConfig{{Name: "a"}, {}}},
+			"name is required",
+		},
+		{
+			"duplicated name",
+			&serverConfig{Listeners: []*listenerConfig{{Name: "a"}, {Name: "a"}}},
+			"duplicated name",
+		},
+		{
+			"tunnel without endpoint",
+			&serverConfig{Listeners: []*listenerConfig{{Name: "a", Responder: &responderConfig{Tunnel: true}}}},
+			"tunnel_listen",
+		},
+		{
+			"client auth without https",
+			&serverConfig{Listeners: []*listenerConfig{{Name: "a", ClientAuth: "require"}}},
+			"requires HTTPS",
+		},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.prepare(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	save := t.TempDir()
+	config := strings.Replace(testListenersConfig, "%s", save, 1)
+	config = strings.Replace(config, "save: /tmp/hooks", "save: "+filepath.Join(save, "hooks"), 1)
+	running := readTestConfig(t, config)
+
+	logger, err := newRequestLogger("text", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorders []*recorder
+	for _, l := range running.Listeners {
+		rec, err := newRecorder(l, newRecordStore(0), logger, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorders = append(recorders, rec)
+	}
+	apiResponder := recorders[0].responder.Load()
+	apiFilters := recorders[0].filters.Load()
+
+	// only the responder of the hooks listener changes
+	changed := readTestConfig(t, strings.Replace(config, "    max_body: 64\n", "    max_body: 64\n    responder:\n      status: 202\n", 1))
+	if settings := restartSettings(running, changed); len(settings) != 0 {
+		t.Errorf("restart settings = %v, want none", settings)
+	}
+	applyConfig(running, changed, recorders)
+
+	if recorders[0].responder.Load() != apiResponder || recorders[0].filters.Load() != apiFilters {
+		t.Error("the unchanged api listener was reloaded")
+	}
+	w := httptest.NewRecorder()
+	recorders[1].ServeHTTP(w, httptest.NewRequest("POST", "/hook", nil))
+	if w.Code != 202 {
+		t.Errorf("hooks status = %d, want 202", w.Code)
+	}
+	w = httptest.NewRecorder()
+	recorders[0].ServeHTTP(w, httptest.NewRequest("POST", "/a", nil))
+	if w.Code != 201 {
+		t.Errorf("api status = %d, want 201", w.Code)
+	}
+
+	// a new address of one listener needs a restart
+	moved := readTestConfig(t, strings.Replace(config, "  - name: hooks\n    listen: 127.0.0.1:0", "  - name: hooks\n    listen: 127.0.0.1:9", 1))
+	if settings := restartSettings(running, moved); !slices.Equal(settings, []string{"hooks.listen"}) {
+		t.Errorf("restart settings = %v, want [hooks.listen]", settings)
+	}
+}
@@ -0,0 +1,711 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// writeCurl prints a curl command sending the request, one option a line.
+func writeCurl(w io.Writer, ex *exportRequest) error {
+	command := "curl"
+	hasBody := ex.Body != nil || ex.BodyFile != "" || ex.Parts != nil
+	switch {
+	case ex.Method == "HEAD":
+		command += " --head"
+	case ex.Method == "GET" && !hasBody, ex.Method == "POST" && hasBody:
+	default:
+		command += " -X " + shellQuote(ex.Method)
+	}
+	args := []string{command + " " + shellQuote(ex.URL)}
+
+	for _, f := range ex.Header {
+		if f.Value == "" {
+			// 'Name:' would remove the header
+			args = append(args, "-H "+shellQuote(f.Name+";"))
+		} else {
+			args = append(args, "-H "+shellQuote(f.Name+": "+f.Value))
+		}
+	}
+	if ex.Compressed {
+		args = append(args, "--compressed")
+	}
+
+	switch {
+	case ex.BodyFile != "":
+		args = append(args, "--data-binary "+shellQuote("@"+ex.BodyFile))
+	case ex.Body != nil:
+		args = append(args, "--data-raw "+shellQuote(string(ex.Body)))
+	}
+	for _, p := range ex.Parts {
+		args = append(args, curlFormArg(p))
+	}
+
+	_, err := fmt.Fprintln(w, strings.Join(args, " \\\n  "))
+	return err
+}
+
+func curlFormArg(p *exportPart) string {
+	if p.File == "" && p.Filename == "" && p.ContentType == "" {
+		return "--form-string " + shellQuote(p.Name+"="+p.Value)
+	}
+
+	// '<file' sends the content of a file without a file name
+	var value string
+	if p.File != "" && p.Filename == "" {
+		value = "<" + curlFormQuote(p.File)
+	} else if p.File != "" {
+		value = "@" + curlFormQuote(p.File)
+	} else {
+		value = curlFormQuote(p.Value)
+	}
+	if p.Filename != "" && (p.File == "" || filepath.Base(p.File) != p.Filename) {
+		value += ";filename=" + curlFormQuote(p.Filename)
+	}
+	if p.ContentType != "" {
+		value += ";type=" + p.ContentType
+	}
+	return "-F " + shellQuote(p.Name+"="+value)
+}
+
+// curlFormQuote double quotes a -F value curl would otherwise split at ';'
+// or read from a file.
+func curlFormQuote(s string) string {
+	if s == "" || (!strings.ContainsAny(s, ";,\"\\") && s[0] != '@' && s[0] != '<' && s[0] != '"') {
+		return s
+	}
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	return "\"" + strings.ReplaceAll(s, "\"", "\\\"") + "\""
+}
+
+// shellQuote quotes s for a POSIX shell, plain words are left as they are
+// and control characters other than newlines are written as $'\t'.
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@%+,", r))
+	}) < 0 {
+		return s
+	}
+	if strings.IndexFunc(s, func(r rune) bool { return r < ' ' && r != '\n' || r == 0x7f }) < 0 {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+
+	b := &strings.Builder{}
+	b.WriteString("$'")
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c < ' ' || c == 0x7f:
+			fmt.Fprintf(b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteString("'")
+	return b.String()
+}
+
+// shellWords splits a command line the way a POSIX shell does, with
+// single, double and $'...' quotes, backslash escapes and line
+// continuations. Variables and other expansions are left as they are.
+func shellWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case ch == '\\':
+			if i+1 >= len(s) {
+				return nil, errors.New("trailing backslash")
+			}
+			i++
+			if s[i] == '\r' && i+1 < len(s) && s[i+1] == '\n' {
+				i++
+			}
+			if s[i] != '\n' {
+				word.WriteByte(s[i])
+				inWord = true
+			}
+		case ch == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case ch == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) >= 0 {
+					i++
+					if s[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, errors.New("unterminated double quote")
+			}
+			inWord = true
+		case ch == '$' && i+1 < len(s) && s[i+1] == '\'':
+			n, err := ansiCQuoted(s[i+2:], &word)
+			if err != nil {
+				return nil, err
+			}
+			i += n + 1
+			inWord = true
+		default:
+			word.WriteByte(ch)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// ansiCQuoted decodes the inside of $'...' into word and returns the length
+// including the closing quote.
+func ansiCQuoted(s string, word *strings.Builder) (int, error) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'':
+			return i + 1, nil
+		case '\\':
+			if i+1 >= len(s) {
+				return 0, errors.New("unterminated $' quote")
+			}
+			i++
+			switch c := s[i]; c {
+			case 'n':
+				word.WriteByte('\n')
+			case 't':
+				word.WriteByte('\t')
+			case 'r':
+				word.WriteByte('\r')
+			case 'a':
+				word.WriteByte('\a')
+			case 'b':
+				word.WriteByte('\b')
+			case 'e', 'E':
+				word.WriteByte(0x1b)
+			case 'f':
+				word.WriteByte('\f')
+			case 'v':
+				word.WriteByte('\v')
+			case 'x', 'u', 'U':
+				digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+				j := i + 1
+				for j < len(s) && j < i+1+digits && isHexDigit(s[j]) {
+					j++
+				}
+				if j == i+1 {
+					word.WriteByte('\\')
+					word.WriteByte(c)
+					continue
+				}
+				n, _ := strconv.ParseUint(s[i+1:j], 16, 32)
+				if c == 'x' {
+					word.WriteByte(byte(n))
+				} else {
+					word.WriteRune(rune(n))
+				}
+				i = j - 1
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				j := i
+				for j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7' {
+					j++
+				}
+				n, _ := strconv.ParseUint(s[i:j], 8, 8)
+				word.WriteByte(byte(n))
+				i = j - 1
+			default:
+				// \\, \', \" and \? stand for the character
+				word.WriteByte(c)
+			}
+		default:
+			word.WriteByte(s[i])
+		}
+	}
+	return 0, errors.New("unterminated $' quote")
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// curl options the import does not need, with whether they take a value.
+var curlIgnoredOptions = map[string]bool{
+	"-s": false, "--silent": false, "-S": false, "--show-error": false,
+	"-v": false, "--verbose": false, "-L": false, "--location": false,
+	"--location-trusted": false, "-k": false, "--insecure": false,
+	"-i": false, "--include": false, "-f": false, "--fail": false,
+	"--fail-with-body": false, "-g": false, "--globoff": false,
+	"-N": false, "--no-buffer": false, "-#": false, "--progress-bar": false,
+	"-O": false, "--remote-name": false, "-J": false, "--remote-header-name": false,
+	"--http1.0": false, "--http1.1": false, "--http2": false,
+	"--http2-prior-knowledge": false, "--http3": false, "--no-keepalive": false,
+	"--raw": false, "--path-as-is": false, "--ssl-no-revoke": false,
+	"--tlsv1": false, "--tlsv1.2": false, "--tlsv1.3": false,
+
+	"-o": true, "--output": true, "-m": true, "--max-time": true,
+	"--connect-timeout": true, "-w": true, "--write-out": true,
+	"--retry": true, "--retry-delay": true, "--retry-max-time": true,
+	"-x": true, "--proxy": true, "-U": true, "--proxy-user": true,
+	"--cacert": true, "--capath": true, "-E": true, "--cert": true,
+	"--cert-type": true, "--key": true, "--key-type": true,
+	"--resolve": true, "--connect-to": true, "-c": true, "--cookie-jar": true,
+	"--limit-rate": true, "--max-redirs": true, "--interface": true,
+	"--unix-socket": true, "-D": true, "--dump-header": true,
+	"--trace": true, "--trace-ascii": true, "--stderr": true,
+	"-Y": true, "--speed-limit": true, "-y": true, "--speed-time": true,
+	"--tls-max": true, "--ciphers": true,
+}
+
+// curl options the import reads, with whether they take a value.
+var curlOptions = map[string]bool{
+	"-X": true, "--request": true, "-H": true, "--header": true,
+	"-d": true, "--data": true, "--data-ascii": true, "--data-binary": true,
+	"--data-raw": true, "--data-urlencode": true, "--json": true,
+	"-F": true, "--form": true, "--form-string": true,
+	"-T": true, "--upload-file": true,
+	"-u": true, "--user": true, "-A": true, "--user-agent": true,
+	"-e": true, "--referer": true, "-b": true, "--cookie": true,
+	"--url": true, "-G": false, "--get": false, "-I": false, "--head": false,
+	"--compressed": false,
+}
+
+// curlCommand is the request a parsed curl command line sends.
+type curlCommand struct {
+	method string
+	url    string
+	header Header
+
+	get        bool
+	head       bool
+	data       []string
+	form       []*curlFormField
+	upload     string
+	json       bool
+	compressed bool
+}
+
+type curlFormField struct {
+	name   string
+	value  string
+	file   string
+	text   bool
+	params map[string]string
+}
+
+// parseCurl reads the words of a curl command line, the leading 'curl' is
+// optional.
+func parseCurl(words []string) (*curlCommand, error) {
+	if len(words) > 0 && (words[0] == "curl" || strings.HasSuffix(words[0], "/curl")) {
+		words = words[1:]
+	}
+
+	cmd := &curlCommand{}
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if !strings.HasPrefix(word, "-") || word == "-" {
+			if cmd.url != "" {
+				return nil, fmt.Errorf("more than one url: %s", word)
+			}
+			cmd.url = word
+			continue
+		}
+
+		// '-sSL' is '-s -S -L' and '-XPOST' is '-X POST'
+		var options []string
+		var attached string
+		hasAttached := false
+		if strings.HasPrefix(word, "--") {
+			options = []string{word}
+		} else {
+			for j := 1; j < len(word); j++ {
+				option := "-" + word[j:j+1]
+				options = append(options, option)
+				if curlOptions[option] || curlIgnoredOptions[option] {
+					if j+1 < len(word) {
+						attached = word[j+1:]
+						hasAttached = true
+					}
+					break
+				}
+			}
+		}
+
+		for _, option := range options {
+			takesValue, known := curlOptions[option]
+			ignoredValue, ignored := curlIgnoredOptions[option]
+			if !known && !ignored {
+				return nil, fmt.Errorf("unsupported curl option %s", option)
+			}
+			if ignored {
+				takesValue = ignoredValue
+			}
+
+			var value string
+			if takesValue {
+				if hasAttached {
+					value = attached
+				} else {
+					if i+1 >= len(words) {
+						return nil, fmt.Errorf("curl option %s needs a value", option)
+					}
+					i++
+					value = words[i]
+				}
+			}
+			if ignored {
+				continue
+			}
+			if err := cmd.option(option, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if cmd.url == "" {
+		return nil, errors.New("no url in the curl command")
+	}
+	return cmd, nil
+}
+
+func (cmd *curlCommand) option(option, value string) error {
+	switch option {
+	case "-X", "--request":
+		cmd.method = value
+	case "-H", "--header":
+		name, v, ok := strings.Cut(value, ":")
+		if !ok {
+			// 'Name;' is a header with an empty value
+			if strings.HasSuffix(value, ";") {
+				cmd.header.Add(strings.TrimSuffix(value, ";"), "")
+				return nil
+			}
+			return fmt.Errorf("invalid header '%s'", value)
+		}
+		v = strings.TrimSpace(v)
+		if v == "" {
+			// 'Name:' only removes a header curl would send
+			cmd.header.Del(name)
+			return nil
+		}
+		cmd.header.Add(strings.TrimSpace(name), v)
+	case "-d", "--data", "--data-ascii":
+		if strings.HasPrefix(value, "@") {
+			data, err := readCurlFile(value[1:])
+			if err != nil {
+				return err
+			}
+			value = strings.NewReplacer("\r", "", "\n", "").Replace(string(data))
+		}
+		cmd.data = append(cmd.data, value)
+	case "--data-binary", "--json":
+		if strings.HasPrefix(value, "@") {
+			data, err := readCurlFile(value[1:])
+			if err != nil {
+				return err
+			}
+			value = string(data)
+		}
+		cmd.data = append(cmd.data, value)
+		cmd.json = cmd.json || option == "--json"
+	case "--data-raw":
+		cmd.data = append(cmd.data, value)
+	case "--data-urlencode":
+		data, err := curlURLEncode(value)
+		if err != nil {
+			return err
+		}
+		cmd.data = append(cmd.data, data)
+	case "-F", "--form", "--form-string":
+		field, err := parseCurlForm(value, option == "--form-string")
+		if err != nil {
+			return err
+		}
+		cmd.form = append(cmd.form, field)
+	case "-T", "--upload-file":
+		cmd.upload = value
+	case "-u", "--user":
+		cmd.header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(value)))
+	case "-A", "--user-agent":
+		cmd.header.Set("User-Agent", value)
+	case "-e", "--referer":
+		cmd.header.Set("Referer", value)
+	case "-b", "--cookie":
+		if !strings.Contains(value, "=") {
+			return fmt.Errorf("cookie files are not supported: %s", value)
+		}
+		cmd.header.Add("Cookie", value)
+	case "--url":
+		if cmd.url != "" {
+			return fmt.Errorf("more than one url: %s", value)
+		}
+		cmd.url = value
+	case "-G", "--get":
+		cmd.get = true
+	case "-I", "--head":
+		cmd.head = true
+	case "--compressed":
+		cmd.compressed = true
+	}
+	return nil
+}
+
+func readCurlFile(name string) ([]byte, error) {
+	if name == "-" {
+		return nil, errors.New("reading data from stdin is not supported")
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %s", err)
+	}
+	return data, nil
+}
+
+// curlURLEncode reads the 'content', '=content', 'name=content', '@file'
+// and 'name@file' forms of --data-urlencode.
+func curlURLEncode(value string) (string, error) {
+	eq := strings.IndexByte(value, '=')
+	at := strings.IndexByte(value, '@')
+	switch {
+	case eq >= 0 && (at < 0 || eq < at):
+		if eq == 0 {
+			return url.QueryEscape(value[1:]), nil
+		}
+		return value[:eq] + "=" + url.QueryEscape(value[eq+1:]), nil
+	case at >= 0:
+		data, err := readCurlFile(value[at+1:])
+		if err != nil {
+			return "", err
+		}
+		if at == 0 {
+			return url.QueryEscape(string(data)), nil
+		}
+		return value[:at] + "=" + url.QueryEscape(string(data)), nil
+	}
+	return url.QueryEscape(value), nil
+}
+
+// parseCurlForm reads 'name=value', 'name=@file' and 'name=<file' with the
+// ';type=' and ';filename=' parameters of -F.
+func parseCurlForm(value string, text bool) (*curlFormField, error) {
+	name, content, ok := strings.Cut(value, "=")
+	if !ok {
+		return nil, fmt.Errorf("invalid form field '%s'", value)
+	}
+	field := &curlFormField{name: name, text: text, params: map[string]string{}}
+	if text {
+		field.value = content
+		return field, nil
+	}
+
+	isFile := strings.HasPrefix(content, "@")
+	isFileContent := strings.HasPrefix(content, "<")
+	if isFile || isFileContent {
+		content = content[1:]
+	}
+
+	segments := splitCurlForm(content)
+	content = segments[0]
+	for _, segment := range segments[1:] {
+		k, v, _ := strings.Cut(segment, "=")
+		field.params[strings.TrimSpace(k)] = v
+	}
+
+	switch {
+	case isFile:
+		field.file = content
+	case isFileContent:
+		data, err := readCurlFile(content)
+		if err != nil {
+			return nil, err
+		}
+		field.value = string(data)
+	default:
+		field.value = content
+	}
+	return field, nil
+}
+
+// splitCurlForm splits at ';', a value starting with a double quote is read
+// up to the closing quote and may contain ';'.
+func splitCurlForm(s string) []string {
+	var segments []string
+	var b strings.Builder
+	valueStart := true
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"' && valueStart:
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+		case s[i] == ';':
+			segments = append(segments, b.String())
+			b.Reset()
+			valueStart = false
+			continue
+		case s[i] == '=' && len(segments) > 0:
+			b.WriteByte(s[i])
+			valueStart = true
+			continue
+		default:
+			b.WriteByte(s[i])
+		}
+		valueStart = false
+	}
+	return append(segments, b.String())
+}
+
+// request returns the method, url, headers and body curl would send.
+func (cmd *curlCommand) request() (string, *url.URL, Header, []byte, error) {
+	rawURL := cmd.url
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return "", nil, nil, nil, fmt.Errorf("failed to parse url: %s", err)
+	}
+	if target.Path == "" {
+		target.Path = "/"
+	}
+
+	header := cmd.header
+	if header == nil {
+		header = Header{}
+	}
+	if cmd.compressed && header.Get("Accept-Encoding") == "" {
+		header.Add("Accept-Encoding", "deflate, gzip")
+	}
+
+	method := "GET"
+	var body []byte
+	switch {
+	case cmd.get:
+		if len(cmd.data) > 0 {
+			query := strings.Join(cmd.data, "&")
+			if target.RawQuery != "" {
+				query = target.RawQuery + "&" + query
+			}
+			target.RawQuery = query
+		}
+	case cmd.form != nil:
+		method = "POST"
+		body, err = cmd.formBody(&header)
+		if err != nil {
+			return "", nil, nil, nil, err
+		}
+	case cmd.data != nil:
+		method = "POST"
+		body = []byte(strings.Join(cmd.data, "&"))
+		if cmd.json {
+			if header.Get("Content-Type") == "" {
+				header.Add("Content-Type", "application/json")
+			}
+			if header.Get("Accept") == "" {
+				header.Add("Accept", "application/json")
+			}
+		} else if header.Get("Content-Type") == "" {
+			header.Add("Content-Type", "application/x-www-form-urlencoded")
+		}
+	case cmd.upload != "":
+		method = "PUT"
+		if body, err = readCurlFile(cmd.upload); err != nil {
+			return "", nil, nil, nil, err
+		}
+		if strings.HasSuffix(target.Path, "/") {
+			target.Path += filepath.Base(cmd.upload)
+		}
+	}
+	if cmd.head {
+		method = "HEAD"
+	}
+	if cmd.method != "" {
+		method = cmd.method
+	}
+	return method, target, header, body, nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func (cmd *curlCommand) formBody(header *Header) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	mw := multipart.NewWriter(buffer)
+	for _, field := range cmd.form {
+		var content []byte
+		filename, hasFilename := field.params["filename"]
+		contentType := field.params["type"]
+		if field.file != "" {
+			data, err := readCurlFile(field.file)
+			if err != nil {
+				return nil, err
+			}
+			content = data
+			if !hasFilename {
+				filename = filepath.Base(field.file)
+			}
+			if contentType == "" {
+				contentType = mime.TypeByExtension(filepath.Ext(field.file))
+			}
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+		} else {
+			content = []byte(field.value)
+		}
+
+		h := textproto.MIMEHeader{}
+		disposition := fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(field.name))
+		if filename != "" {
+			disposition += fmt.Sprintf(`; filename="%s"`, quoteEscaper.Replace(filename))
+		}
+		h.Set("Content-Disposition", disposition)
+		if contentType != "" {
+			h.Set("Content-Type", contentType)
+		}
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	header.Set("Content-Type", mw.FormDataContentType())
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"github.com/urfave/cli/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newTestContext parses args with the flags of the req commands.
+func newTestContext(t *testing.T, args ...string) *cli.Context {
+	t.Helper()
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range slices.Concat(replayFlags(), transportFlags()) {
+		if err := f.Apply(set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(cli.NewApp(), set, nil)
+}
+
+func TestShellWords(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{"curl http://a", []string{"curl", "http://a"}, false},
+		{"  a   b\tc\n", []string{"a", "b", "c"}, false},
+		{`'single $x "q"'`, []string{`single $x "q"`}, false},
+		{`"double \"q\" \$x \\ \a"`, []string{`double "q" $x \ \a`}, false},
+		{`a\ b`, []string{"a b"}, false},
+		{"a \\\n  b", []string{"a", "b"}, false},
+		{`''`, []string{""}, false},
+		{`x'y'"z"`, []string{"xyz"}, false},
+		{`$'tab\there\n\x41\''`, []string{"tab\there\nA'"}, false},
+		{`$HOME`, []string{"$HOME"}, false},
+		{`'open`, nil, true},
+		{`"open`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := shellWords(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("shellWords(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("shellWords(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseCurl(t *testing.T) {
+	tests := []struct {
+		args       []string
+		wantMethod string
+		wantURL    string
+		wantHeader Header
+		wantBody   string
+		wantErr    bool
+	}{
+		{
+			args:       []string{"curl", "example.com"},
+			wantMethod: "GET",
+			wantURL:    "http://example.com/",
+			wantHeader: Header{},
+		},
+		{
+			args:       []string{"curl", "-sSL", "-XPUT", "https://example.com/a?b=1", "-H", "X-A: 1", "-H", "X-Empty;"},
+			wantMethod: "PUT",
+			wantURL:    "https://example.com/a?b=1",
+			wantHeader: Header{{Name: "X-A", Value: "1"}, {Name: "X-Empty", Value: ""}},
+		},
+		{
+			args:       []string{"curl", "http://h/", "-d", "a=1", "--data-urlencode", "b=x y"},
+			wantMethod: "POST",
+			wantURL:    "http://h/",
+			wantHeader: Header{{Name: "Content-Type", Value: "application/x-www-form-urlencoded"}},
+			wantBody:   "a=1&b=x+y",
+		},
+		{
+			args:       []string{"curl", "http://h/items", "-G", "-d", "page=2"},
+			wantMethod: "GET",
+			wantURL:    "http://h/items?page=2",
+			wantHeader: Header{},
+		},
+		{
+			args:       []string{"curl", "--json", `{"a":1}`, "http://h/"},
+			wantMethod: "POST",
+			wantURL:    "http://h/",
+			wantHeader: Header{{Name: "Content-Type", Value: "application/json"}, {Name: "Accept", Value: "application/json"}},
+			wantBody:   `{"a":1}`,
+		},
+		{
+			args:       []string{"curl", "-I", "-u", "user:pass", "http://h/"},
+			wantMethod: "HEAD",
+			wantURL:    "http://h/",
+			wantHeader: Header{{Name: "Authorization", Value: "Basic dXNlcjpwYXNz"}},
+		},
+		{args: []string{"curl", "-X"}, wantErr: true},
+		{args: []string{"curl", "--unknown", "http://h/"}, wantErr: true},
+		{args: []string{"curl", "-s"}, wantErr: true},
+		{args: []string{"curl", "http://a/", "http://b/"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		cmd, err := parseCurl(tt.args)
+		if err != nil {
+			if !tt.wantErr {
+				t.Errorf("parseCurl(%q): %s", tt.args, err)
+			}
+			continue
+		}
+		if tt.wantErr {
+			t.Errorf("parseCurl(%q): expected an error", tt.args)
+			continue
+		}
+		method, target, header, body, err := cmd.request()
+		if err != nil {
+			t.Errorf("parseCurl(%q) request: %s", tt.args, err)
+			continue
+		}
+		if method != tt.wantMethod || target.String() != tt.wantURL || string(body) != tt.wantBody || !slices.Equal(header, tt.wantHeader) {
+			t.Errorf("parseCurl(%q) = %s %s %v %q, want %s %s %v %q", tt.args,
+				method, target, header, body, tt.wantMethod, tt.wantURL, tt.wantHeader, tt.wantBody)
+		}
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	dir := t.TempDir()
+	upload := filepath.Join(dir, "upload.txt")
+	if err := os.WriteFile(upload, []byte("file content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"get", []string{"curl", "http://example.com/items?page=2", "-H", "Accept: text/plain"}},
+		{"json", []string{"curl", "-X", "PATCH", "http://example.com/items/1", "-H", "Content-Type: application/json", "-d", `{"name":"it's \"quoted\""}`}},
+		{"text", []string{"curl", "http://example.com/notes", "-H", "Content-Type: text/plain", "--data-binary", "line 1\nline 2\ttab"}},
+		{"form", []string{"curl", "http://example.com/upload", "-F", "title=a;b", "-F", "file=@" + upload}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recordDir := t.TempDir()
+			cmd, err := parseCurl(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filename, err := importRecord(cmd, recordDir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var record Record
+			if err := loadRecord(filename, &record); err != nil {
+				t.Fatal(err)
+			}
+
+			ex, err := newExportRequest(newTestContext(t), &record, recordDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buffer := &bytes.Buffer{}
+			if err := writeCurl(buffer, ex); err != nil {
+				t.Fatal(err)
+			}
+			words, err := shellWords(buffer.String())
+			if err != nil {
+				t.Fatalf("exported command %s: %s", buffer, err)
+			}
+			again, err := parseCurl(words)
+			if err != nil {
+				t.Fatalf("exported command %s: %s", buffer, err)
+			}
+			filename2, err := importRecord(again, recordDir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var record2 Record
+			if err := loadRecord(filename2, &record2); err != nil {
+				t.Fatal(err)
+			}
+
+			if record2.Method != record.Method || record2.URL != record.URL {
+				t.Errorf("request = %s %s, want %s %s", record2.Method, record2.URL, record.Method, record.URL)
+			}
+			got, want := requestBody(t, recordDir, record2.Request), requestBody(t, recordDir, record.Request)
+			if got != want {
+				t.Errorf("body = %q, want %q\nexported: %s", got, want, buffer)
+			}
+			for _, f := range record.Request.Header {
+				if f.Name != "Content-Type" && record2.Request.Header.Get(f.Name) != f.Value {
+					t.Errorf("header %s = %q, want %q", f.Name, record2.Request.Header.Get(f.Name), f.Value)
+				}
+			}
+		})
+	}
+}
+
+// requestBody is the body of a saved request in a comparable form, the
+// multipart boundary left out.
+func requestBody(t *testing.T, dir string, rr *RequestResponse) string {
+	t.Helper()
+	switch {
+	case rr.BodyFile != "":
+		data, err := os.ReadFile(filepath.Join(dir, rr.BodyFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	case rr.BodyJson != nil:
+		return string(rr.BodyJson)
+	case rr.BodyMultiPart != nil:
+		buffer := &bytes.Buffer{}
+		for _, part := range rr.BodyMultiPart {
+			buffer.WriteString(part.Header.Get("Content-Disposition") + "\n")
+			if part.ContentFile != "" {
+				data, err := os.ReadFile(filepath.Join(dir, part.ContentFile))
+				if err != nil {
+					t.Fatal(err)
+				}
+				buffer.Write(data)
+			} else {
+				buffer.WriteString(part.Content)
+			}
+			buffer.WriteString("\n")
+		}
+		return buffer.String()
+	}
+	return rr.Body
+}
+
+func TestExportBodyFileContentType(t *testing.T) {
+	tests := []struct {
+		file string
+		want []string
+	}{
+		{"0001-body.json", []string{"application/json"}},
+		{"0001-body.unknownext", nil},
+	}
+	for _, tt := range tests {
+		record := &Record{Method: "POST", URL: "http://example.com/", Request: &RequestResponse{BodyFile: tt.file}}
+		ex, err := newExportRequest(newTestContext(t), record, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ex.Header.Values("Content-Type"); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Content-Type = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+func exportCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "export",
+		Usage:     "Print a record as a command line or code sending the same request",
+		ArgsUsage: "[record]",
+		Flags: slices.Concat([]cli.Flag{
+			&cli.StringFlag{
+				Name:    "file",
+				Aliases: []string{"f"},
+				Usage:   "Request JSON file",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Output format: 'curl', 'go', 'python', 'js' or 'httpie'",
+				Value: "curl",
+			},
+			&cli.BoolFlag{
+				Name:     "test",
+				Usage:    "Print an httptest test of --handler asserting the recorded response",
+				Category: "go",
+			},
+			&cli.StringFlag{
+				Name:     "handler",
+				Usage:    "Expression of the http.Handler under test",
+				Value:    "handler",
+				Category: "go",
+			},
+			&cli.StringFlag{
+				Name:     "package",
+				Usage:    "Package of the test file",
+				Value:    "main",
+				Category: "go",
+			},
+		}, replayFlags()),
+		Action: func(c *cli.Context) error {
+			filename := c.String("file")
+			if filename == "" {
+				filename = c.Args().First()
+			}
+			if filename == "" {
+				return cli.Exit("no record given, use --file", 1)
+			}
+
+			var record Record
+			if err := loadRecord(filename, &record); err != nil {
+				return err
+			}
+			ex, err := newExportRequest(c, &record, filepath.Dir(filename))
+			if err != nil {
+				return err
+			}
+
+			if c.Bool("test") && c.String("format") != "go" {
+				return cli.Exit("--test needs --format go", 1)
+			}
+			switch c.String("format") {
+			case "curl":
+				return writeCurl(os.Stdout, ex)
+			case "go":
+				if c.Bool("test") {
+					return writeGoTest(os.Stdout, ex, c.String("package"), c.String("handler"))
+				}
+				return writeGo(os.Stdout, ex)
+			case "python":
+				return writePython(os.Stdout, ex)
+			case "js":
+				return writeJS(os.Stdout, ex)
+			case "httpie":
+				return writeHTTPie(os.Stdout, ex)
+			default:
+				return cli.Exit(fmt.Sprintf("unknown format '%s'", c.String("format")), 1)
+			}
+		},
+	}
+}
+
+func importCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "import",
+		Usage: "Create a record from a request given in another format",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "curl",
+				Usage:     "Create a record from a curl command line, '-' reads it from stdin",
+				ArgsUsage: "<command>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "dir",
+						Aliases: []string{"d"},
+						Usage:   "Directory of the record, numbered after the records in it",
+						Value:   ".",
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "Record file name, instead of a numbered one in --dir",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					words := c.Args().Slice()
+					if len(words) == 1 {
+						line := words[0]
+						if line == "-" {
+							data, err := io.ReadAll(os.Stdin)
+							if err != nil {
+								return fmt.Errorf("failed to read stdin: %s", err)
+							}
+							line = string(data)
+						}
+						var err error
+						if words, err = shellWords(line); err != nil {
+							return fmt.Errorf("failed to parse command line: %s", err)
+						}
+					}
+					if len(words) == 0 {
+						return cli.Exit("no curl command given", 1)
+					}
+
+					cmd, err := parseCurl(words)
+					if err != nil {
+						return err
+					}
+					filename, err := importRecord(cmd, c.String("dir"), c.String("output"))
+					if err != nil {
+						return err
+					}
+					fmt.Println(filename)
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// importRecord saves the request of a curl command as a record, bodies are
+// stored the way the server stores them.
+func importRecord(cmd *curlCommand, dir string, output string) (string, error) {
+	method, target, header, body, err := cmd.request()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	filename := filepath.Base(output)
+	if output != "" {
+		dir = filepath.Dir(output)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", fmt.Errorf("failed to create directory %s: %v", dir, err)
+		}
+	} else {
+		seq, err := lastRecordSeq(dir)
+		if err != nil {
+			return "", err
+		}
+		filename = recordFilename(seq+1, now, method, target.Path)
+	}
+
+	record := &Record{
+		Method:   method,
+		URL:      target.String(),
+		Time:     now.Format(time.RFC3339Nano),
+		Protocol: "HTTP/1.1",
+		Request:  &RequestResponse{},
+	}
+	basename := strings.TrimSuffix(filename, ".json")
+	if err := captureBody(record.Request, header, body, dir, basename); err != nil {
+		return "", fmt.Errorf("failed to save request body: %s", err)
+	}
+	if err := saveRecord(dir, filename, record); err != nil {
+		return "", fmt.Errorf("failed to save record: %s", err)
+	}
+	return filepath.Join(dir, filename), nil
+}
+
+// exportRequest is a record prepared for the exporters: placeholders are
+// resolved, headers the tools set themselves are removed and body files are
+// relative to the working directory.
+type exportRequest struct {
+	Method string
+	URL    string
+	Header Header
+
+	// at most one of the bodies is set
+	Body     []byte
+	BodyFile string
+	Parts    []*exportPart
+
+	// the response was compressed, the tool should ask for it again
+	Compressed bool
+
+	Response *RequestResponse
+	Dir      string
+}
+
+type exportPart struct {
+	Name        string
+	Filename    string
+	ContentType string
+	Value       string
+	File        string
+}
+
+func newExportRequest(c *cli.Context, record *Record, dir string) (*exportRequest, error) {
+	vars, err := loadVariables(c.StringSlice("env"), c.StringSlice("var"), c.Bool("expand"))
+	if err != nil {
+		return nil, err
+	}
+	record = vars.expandRecord(record)
+
+	target, err := exportURL(c, record)
+	if err != nil {
+		return nil, err
+	}
+
+	rr := record.Request
+	if rr == nil {
+		rr = &RequestResponse{}
+	}
+	ex := &exportRequest{
+		Method:   record.Method,
+		URL:      target,
+		Header:   slices.Clone(rr.Header),
+		Response: record.Response,
+		Dir:      dir,
+	}
+	ex.Header.Del("Host")
+	ex.Header.Del("Content-Length")
+	ex.Header.Del("Transfer-Encoding")
+
+	if c.String("basic") != "" {
+		ex.Header.Set("Authorization",
+			"Basic "+base64.StdEncoding.EncodeToString([]byte(c.String("basic"))))
+	}
+	if c.String("bearer") != "" {
+		ex.Header.Set("Authorization", "Bearer "+c.String("bearer"))
+	}
+	if record.Response != nil && record.Response.OriginalContentEncoding != "" {
+		ex.Compressed = true
+		ex.Header.Del("Accept-Encoding")
+	}
+
+	switch {
+	case rr.BodyFile != "":
+		ex.BodyFile = filepath.Join(dir, rr.BodyFile)
+		if ex.Header.Get("Content-Type") == "" {
+			if contentType := mime.TypeByExtension(filepath.Ext(rr.BodyFile)); contentType != "" {
+				ex.Header.Add("Content-Type", contentType)
+			}
+		}
+	case rr.BodyJson != nil:
+		if ex.Header.Get("Content-Type") == "" {
+			ex.Header.Add("Content-Type", "application/json")
+		}
+		buffer := &bytes.Buffer{}
+		if err := json.Compact(buffer, rr.BodyJson); err != nil {
+			return nil, fmt.Errorf("failed to parse json: %s", err)
+		}
+		ex.Body = buffer.Bytes()
+	case rr.BodyMultiPart != nil:
+		// the tools write the boundary themselves
+		ex.Header.Del("Content-Type")
+		for _, part := range rr.BodyMultiPart {
+			ex.Parts = append(ex.Parts, newExportPart(part, dir))
+		}
+	case rr.Body != "":
+		ex.Body = []byte(rr.Body)
+	}
+	return ex, nil
+}
+
+func newExportPart(part *MultiPart, dir string) *exportPart {
+	p := &exportPart{ContentType: part.Header.Get("Content-Type")}
+	if _, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition")); err == nil {
+		p.Name = params["name"]
+		p.Filename = params["filename"]
+	}
+
+	switch {
+	case part.ContentFile != "":
+		p.File = filepath.Join(dir, part.ContentFile)
+	case part.ContentJson != nil:
+		buffer := &bytes.Buffer{}
+		if err := json.Compact(buffer, part.ContentJson); err != nil {
+			buffer.Write(part.ContentJson)
+		}
+		p.Value = buffer.String()
+		if p.ContentType == "" {
+			p.ContentType = "application/json"
+		}
+	default:
+		p.Value = part.Content
+	}
+	return p
+}
+
+// exportURL is the absolute url of a record, the --server flag replaces the
+// host of records captured with one and is required for the others.
+func exportURL(c *cli.Context, record *Record) (string, error) {
+	recordURL, err := url.Parse(record.URL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse record url: %s", err)
+	}
+	if recordURL.IsAbs() && !c.IsSet("server") {
+		return recordURL.String(), nil
+	}
+
+	uri, err := parseUri(c)
+	if err != nil {
+		return "", err
+	}
+	if uri.Path == "" {
+		uri.Path = recordURL.Path
+		uri.RawPath = recordURL.RawPath
+		if uri.RawQuery == "" {
+			uri.RawQuery = recordURL.RawQuery
+		}
+	}
+	return uri.String(), nil
+}
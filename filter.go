@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const redactedValue = "[REDACTED]"
+
+// filterConfig decides which requests are recorded, filtered requests are
+// still answered by the responder.
+type filterConfig struct {
+	Methods      []string `json:"methods,omitempty"`
+	IncludePaths []string `json:"include_paths,omitempty"`
+	ExcludePaths []string `json:"exclude_paths,omitempty"`
+}
+
+func (f *filterConfig) match(r *http.Request) bool {
+	if len(f.Methods) > 0 && !slices.ContainsFunc(f.Methods, func(m string) bool {
+		return strings.EqualFold(m, r.Method)
+	}) {
+		return false
+	}
+	if len(f.IncludePaths) > 0 && !slices.ContainsFunc(f.IncludePaths, func(p string) bool {
+		return matchPath(p, r.URL.Path)
+	}) {
+		return false
+	}
+	if slices.ContainsFunc(f.ExcludePaths, func(p string) bool {
+		return matchPath(p, r.URL.Path)
+	}) {
+		return false
+	}
+	return true
+}
+
+// matchPath matches a path against a glob pattern, a trailing '*' matches
+// any suffix including slashes.
+func matchPath(pattern, p string) bool {
+	if strings.HasSuffix(pattern, "*") && strings.HasPrefix(p, strings.TrimSuffix(pattern, "*")) {
+		return true
+	}
+	ok, _ := path.Match(pattern, p)
+	return ok
+}
+
+// redactConfig masks secrets before records are written, the query names
+// also apply to fields of form bodies.
+type redactConfig struct {
+	Headers    []string `json:"headers,omitempty"`
+	Query      []string `json:"query,omitempty"`
+	JsonFields []string `json:"json_fields,omitempty"`
+}
+
+func (rc *redactConfig) empty() bool {
+	return len(rc.Headers) == 0 && len(rc.Query) == 0 && len(rc.JsonFields) == 0
+}
+
+// apply redacts the record and the form bodies it saved in dir.
+func (rc *redactConfig) apply(record *Record, dir string) {
+	if rc.empty() {
+		return
+	}
+
+	if len(rc.Query) > 0 {
+		if uri, err := url.Parse(record.URL); err == nil && uri.RawQuery != "" {
+			uri.RawQuery = rc.redactQuery(uri.RawQuery)
+			record.URL = uri.String()
+		}
+	}
+
+	for _, rr := range []*RequestResponse{record.Request, record.Response} {
+		if rr == nil {
+			continue
+		}
+		rc.redactHeader(rr.Header)
+		rr.BodyJson = rc.redactJson(rr.BodyJson)
+		if isContentForm(rr.Header.Get("Content-Type")) {
+			rr.Body = rc.redactQuery(rr.Body)
+			if rr.BodyFile != "" {
+				rc.redactFile(filepath.Join(dir, rr.BodyFile), rc.redactQuery)
+			}
+		}
+		for _, part := range rr.BodyMultiPart {
+			rc.redactHeader(part.Header)
+			part.ContentJson = rc.redactJson(part.ContentJson)
+			rc.redactPart(part, dir)
+		}
+	}
+}
+
+// redactQuery masks the values of the query names in an url encoded
+// string, the parameters are edited in place to keep their order.
+func (rc *redactConfig) redactQuery(raw string) string {
+	if raw == "" || len(rc.Query) == 0 {
+		return raw
+	}
+
+	params := strings.Split(raw, "&")
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if slices.Contains(rc.Query, name) {
+			params[i] = key + "=" + url.QueryEscape(redactedValue)
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// formField is the name of a multipart form field, false for uploaded files.
+func formField(part *MultiPart) (string, bool) {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil || params["filename"] != "" {
+		return "", false
+	}
+	return params["name"], true
+}
+
+// redactPart masks a multipart form field named like a query parameter,
+// uploaded files are kept.
+func (rc *redactConfig) redactPart(part *MultiPart, dir string) {
+	if name, ok := formField(part); !ok || !slices.Contains(rc.Query, name) {
+		return
+	}
+
+	switch {
+	case part.ContentFile != "":
+		rc.redactFile(filepath.Join(dir, part.ContentFile), func(string) string {
+			return redactedValue
+		})
+	case part.ContentJson != nil:
+		part.ContentJson = json.RawMessage(`"` + redactedValue + `"`)
+	default:
+		part.Content = redactedValue
+	}
+}
+
+// redactFile rewrites a saved body through redact.
+func (rc *redactConfig) redactFile(filename string, redact func(string) string) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		log.Printf("failed to redact '%s': %v", filename, err)
+		return
+	}
+	if err := os.WriteFile(filename, []byte(redact(string(data))), 0644); err != nil {
+		log.Printf("failed to redact '%s': %v", filename, err)
+	}
+}
+
+func (rc *redactConfig) redactHeader(header Header) {
+	for _, name := range rc.Headers {
+		for i := range header {
+			if strings.EqualFold(header[i].Name, name) {
+				header[i].Value = redactedValue
+			}
+		}
+	}
+}
+
+func (rc *redactConfig) redactJson(data json.RawMessage) json.RawMessage {
+	if data == nil || len(rc.JsonFields) == 0 {
+		return data
+	}
+
+	buffer := &bytes.Buffer{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := rc.redactJsonValue(dec, buffer); err != nil {
+		return data
+	}
+
+	// keep the indentation style of recorded bodies
+	indented := &bytes.Buffer{}
+	if err := json.Indent(indented, buffer.Bytes(), "", "  "); err != nil {
+		return buffer.Bytes()
+	}
+	return indented.Bytes()
+}
+
+// redactJsonValue copies one json value from dec to w, field order is kept.
+func (rc *redactConfig) redactJsonValue(dec *json.Decoder, w io.Writer) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		_, _ = io.WriteString(w, "{")
+		for i := 0; dec.More(); i++ {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				_, _ = io.WriteString(w, ",")
+			}
+			name, _ := json.Marshal(key)
+			_, _ = fmt.Fprintf(w, "%s:", name)
+
+			if slices.Contains(rc.JsonFields, key.(string)) {
+				var skip json.RawMessage
+				if err := dec.Decode(&skip); err != nil {
+					return err
+				}
+				_, _ = io.WriteString(w, `"`+redactedValue+`"`)
+				continue
+			}
+			if err := rc.redactJsonValue(dec, w); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		_, _ = io.WriteString(w, "}")
+		return err
+	case json.Delim('['):
+		_, _ = io.WriteString(w, "[")
+		for i := 0; dec.More(); i++ {
+			if i > 0 {
+				_, _ = io.WriteString(w, ",")
+			}
+			if err := rc.redactJsonValue(dec, w); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		_, _ = io.WriteString(w, "]")
+		return err
+	default:
+		data, err := json.Marshal(tok)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRedactQuery(t *testing.T) {
+	rc := &redactConfig{Query: []string{"token", "api key"}}
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"", ""},
+		{"z=1&token=abc&a=2", "z=1&token=%5BREDACTED%5D&a=2"},
+		{"token=a&token=b", "token=%5BREDACTED%5D&token=%5BREDACTED%5D"},
+		{"api+key=x&api%20key=y", "api+key=%5BREDACTED%5D&api%20key=%5BREDACTED%5D"},
+		{"token&flag", "token=%5BREDACTED%5D&flag"},
+		{"b=%zz&c", "b=%zz&c"},
+	}
+	for _, tt := range tests {
+		if got := rc.redactQuery(tt.raw); got != tt.want {
+			t.Errorf("redactQuery(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestRedactRecord(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a-body.dat"), []byte("user=a&password=secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a-multipart_0.dat"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	form := Header{{Name: "Content-Type", Value: "application/x-www-form-urlencoded"}}
+	record := &Record{
+		URL: "/login?z=1&password=secret&a=2",
+		Request: &RequestResponse{
+			Header:   append(form, Header{{Name: "Authorization", Value: "Bearer x"}}...),
+			BodyFile: "a-body.dat",
+		},
+		Response: &RequestResponse{
+			Header: form,
+			Body:   "password=secret",
+		},
+	}
+	multipart := &RequestResponse{
+		BodyMultiPart: []*MultiPart{
+			{Header: Header{{Name: "Content-Disposition", Value: `form-data; name="password"`}}, ContentFile: "a-multipart_0.dat"},
+			{Header: Header{{Name: "Content-Disposition", Value: `form-data; name="password"`}}, Content: "secret"},
+			{Header: Header{{Name: "Content-Disposition", Value: `form-data; name="user"`}}, Content: "a"},
+		},
+	}
+
+	rc := &redactConfig{Headers: []string{"authorization"}, Query: []string{"password"}}
+	rc.apply(record, dir)
+	rc.apply(&Record{URL: "/", Request: multipart}, dir)
+
+	if record.URL != "/login?z=1&password=%5BREDACTED%5D&a=2" {
+		t.Errorf("url = %s", record.URL)
+	}
+	if v := record.Request.Header.Get("Authorization"); v != redactedValue {
+		t.Errorf("header = %s", v)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a-body.dat")); string(data) != "user=a&password=%5BREDACTED%5D" {
+		t.Errorf("body file = %s", data)
+	}
+	if record.Response.Body != "password=%5BREDACTED%5D" {
+		t.Errorf("body = %s", record.Response.Body)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a-multipart_0.dat")); string(data) != redactedValue {
+		t.Errorf("multipart file = %s", data)
+	}
+	if multipart.BodyMultiPart[1].Content != redactedValue || multipart.BodyMultiPart[2].Content != "a" {
+		t.Errorf("multipart fields = %q, %q", multipart.BodyMultiPart[1].Content, multipart.BodyMultiPart[2].Content)
+	}
+}
+
+func TestRedactKeepsTeeCopy(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a-body.dat"), []byte("password=secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	record := &Record{
+		URL: "/login",
+		Request: &RequestResponse{
+			Header:   Header{{Name: "Content-Type", Value: "application/x-www-form-urlencoded"}},
+			BodyFile: "a-body.dat",
+		},
+	}
+
+	c := cloneRequestRecord(record, dir)
+	(&redactConfig{Query: []string{"password"}}).apply(record, dir)
+	if c.Request.BodyFile != "" || c.Request.Body != "password=secret" {
+		t.Errorf("tee copy = %q, file %q", c.Request.Body, c.Request.BodyFile)
+	}
+}
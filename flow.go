@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+func flowCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "flow",
+		Usage:     "Replay records step by step, passing values from responses to later requests",
+		ArgsUsage: "<flow file>",
+		Flags: slices.Concat(replayFlags(), []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "no-color",
+				Usage: "Disable colored output",
+			},
+			&cli.BoolFlag{
+				Name:  "show-values",
+				Usage: "Print the extracted values instead of masking them",
+			},
+		}, transportFlags()),
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return cli.Exit("expect one flow file", 1)
+			}
+
+			f := &flow{}
+			if err := readConfigFile(c.Args().First(), f); err != nil {
+				return err
+			}
+			if err := f.prepare(filepath.Dir(c.Args().First())); err != nil {
+				return err
+			}
+
+			rp, err := newReplayer(c)
+			if err != nil {
+				return err
+			}
+			defer rp.close()
+
+			p := &tailPrinter{color: !c.Bool("no-color") && isTerminal(os.Stdout)}
+			if !f.run(c, rp, p) {
+				return cli.Exit("", 1)
+			}
+			return nil
+		},
+	}
+}
+
+// flow is a yaml (or json) file of steps sending one record each. Values
+// extracted from a response are variables of the later steps.
+type flow struct {
+	// defaults of variables not given by --var, --env, the environment or a step
+	Vars  map[string]string `json:"vars,omitempty"`
+	Steps []*flowStep       `json:"steps"`
+}
+
+type flowStep struct {
+	Name    string         `json:"name,omitempty"`
+	Record  string         `json:"record"`
+	Status  int            `json:"status,omitempty"`
+	Extract []*flowExtract `json:"extract,omitempty"`
+
+	entry *recordEntry
+}
+
+// flowExtract takes one value from a response, exactly one source is set.
+type flowExtract struct {
+	Var    string `json:"var"`
+	Json   string `json:"json,omitempty"`
+	Header string `json:"header,omitempty"`
+	Cookie string `json:"cookie,omitempty"`
+	Regex  string `json:"regex,omitempty"`
+
+	path    jsonPath
+	pattern *regexp.Regexp
+}
+
+// prepare loads the records, relative to dir, and checks the extractions
+// before anything is sent.
+func (f *flow) prepare(dir string) error {
+	if len(f.Steps) == 0 {
+		return errors.New("flow has no steps")
+	}
+
+	for i, step := range f.Steps {
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step %d", i+1)
+		}
+		if step.Record == "" {
+			return fmt.Errorf("%s: record is required", step.Name)
+		}
+
+		filename := step.Record
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(dir, filename)
+		}
+		var record Record
+		if err := loadRecord(filename, &record); err != nil {
+			return fmt.Errorf("%s: %s", step.Name, err)
+		}
+		seq, _ := fileSeq(filepath.Base(filename))
+		step.entry = &recordEntry{Seq: seq, File: filepath.Base(filename), Dir: filepath.Dir(filename), Record: &record}
+
+		for _, ex := range step.Extract {
+			if err := ex.prepare(); err != nil {
+				return fmt.Errorf("%s: %s", step.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (ex *flowExtract) prepare() error {
+	if ex.Var == "" {
+		return errors.New("extract needs a var")
+	}
+
+	sources := 0
+	for _, s := range []string{ex.Json, ex.Header, ex.Cookie, ex.Regex} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("extract %s: set exactly one of json, header, cookie or regex", ex.Var)
+	}
+
+	var err error
+	if ex.Json != "" {
+		if ex.path, err = parseJSONPath(ex.Json); err != nil {
+			return fmt.Errorf("extract %s: %s", ex.Var, err)
+		}
+	}
+	if ex.Regex != "" {
+		if ex.pattern, err = regexp.Compile(ex.Regex); err != nil {
+			return fmt.Errorf("extract %s: invalid regex: %s", ex.Var, err)
+		}
+	}
+	return nil
+}
+
+// run sends the steps in order and stops at the first failing one, it
+// reports whether every step passed.
+func (f *flow) run(c *cli.Context, rp *replayer, p *tailPrinter) bool {
+	rp.vars.mu.Lock()
+	// a flow passes values between its steps
+	rp.vars.enabled = true
+	rp.vars.defaults = f.Vars
+	rp.vars.mu.Unlock()
+
+	for i, step := range f.Steps {
+		record := step.entry.Record
+		start := time.Now()
+		values, status, err := step.run(c, rp)
+		duration := time.Since(start).Round(time.Microsecond)
+
+		if err != nil {
+			fmt.Printf("%s %s: %s %s %s\n", p.paint(colorRed, "FAIL"), step.Name, record.Method, record.URL, p.paint(colorDim, duration.String()))
+			fmt.Printf("     %s\n", err)
+			if skipped := len(f.Steps) - i - 1; skipped > 0 {
+				fmt.Printf("%d steps not run\n", skipped)
+			}
+			return false
+		}
+
+		fmt.Printf("%s %s: %s %s %d %s\n", p.paint(colorGreen, "PASS"), step.Name, record.Method, record.URL, status, p.paint(colorDim, duration.String()))
+		for _, ex := range step.Extract {
+			value := maskValue(values[ex.Var])
+			if c.Bool("show-values") {
+				value = abbreviate(values[ex.Var], 60)
+			}
+			fmt.Printf("     %s = %s\n", ex.Var, value)
+		}
+	}
+	fmt.Printf("%d steps passed\n", len(f.Steps))
+	return true
+}
+
+// run sends the record of the step and sets the extracted variables.
+func (step *flowStep) run(c *cli.Context, rp *replayer) (map[string]string, int, error) {
+	resp, _, err := rp.do(c.Context, step.entry)
+	if err != nil {
+		return nil, 0, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to read response body: %s", err)
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" {
+		if decoded, err := decodeContent(encoding, body); err == nil {
+			body = decoded
+		}
+	}
+
+	if step.Status != 0 && resp.StatusCode != step.Status {
+		return nil, resp.StatusCode, fmt.Errorf("expected status %d, got %d\n     body: %s", step.Status, resp.StatusCode, abbreviate(string(body), 200))
+	}
+
+	values := make(map[string]string)
+	var doc interface{}
+	var docErr error
+	docDecoded := false
+	for _, ex := range step.Extract {
+		if ex.path != nil && !docDecoded {
+			docErr = decodeJsonNumber(body, &doc)
+			docDecoded = true
+		}
+
+		value, err := ex.extract(resp, body, doc, docErr)
+		if err != nil {
+			return nil, resp.StatusCode, fmt.Errorf("extract %s: %s (status %d)\n     body: %s", ex.Var, err, resp.StatusCode, abbreviate(string(body), 200))
+		}
+		values[ex.Var] = value
+		rp.vars.set(ex.Var, value)
+	}
+	return values, resp.StatusCode, nil
+}
+
+func (ex *flowExtract) extract(resp *http.Response, body []byte, doc interface{}, docErr error) (string, error) {
+	switch {
+	case ex.path != nil:
+		if docErr != nil {
+			return "", fmt.Errorf("response body is no JSON: %s", docErr)
+		}
+		found := ex.path.find(doc)
+		if len(found) == 0 {
+			return "", fmt.Errorf("%s not found in the response body", ex.Json)
+		}
+		return jsonText(found[0]), nil
+	case ex.Header != "":
+		if values := resp.Header.Values(ex.Header); len(values) > 0 {
+			return values[0], nil
+		}
+		return "", fmt.Errorf("no %s header in the response", ex.Header)
+	case ex.Cookie != "":
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == ex.Cookie {
+				return cookie.Value, nil
+			}
+		}
+		return "", fmt.Errorf("no %s cookie in the response", ex.Cookie)
+	default:
+		m := ex.pattern.FindSubmatch(body)
+		if m == nil {
+			return "", fmt.Errorf("regex %s does not match the response body", ex.Regex)
+		}
+		if len(m) > 1 {
+			return string(m[1]), nil
+		}
+		return string(m[0]), nil
+	}
+}
+
+// jsonText is a string as it is and any other value as JSON.
+func jsonText(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func abbreviate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n]) + "..."
+	}
+	return s
+}
+
+// maskValue hides an extracted value, which is often a token, and keeps
+// only its length.
+func maskValue(s string) string {
+	return fmt.Sprintf("******** (%d chars)", utf8.RuneCountInString(s))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAbbreviate(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"a  b\n\tc", 10, "a b c"},
+		{"abcdef", 3, "abc..."},
+		{"äöüß€", 3, "äöü..."},
+		{"日本語のテキスト", 4, "日本語の..."},
+	}
+	for _, tt := range tests {
+		if got := abbreviate(tt.in, tt.n); got != tt.want {
+			t.Errorf("abbreviate(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestMaskValue(t *testing.T) {
+	got := maskValue("secret-token")
+	if strings.Contains(got, "secret") {
+		t.Errorf("maskValue shows the value: %q", got)
+	}
+	if want := "******** (12 chars)"; got != want {
+		t.Errorf("maskValue = %q, want %q", got, want)
+	}
+}
+
+func TestFlowExtractPrepare(t *testing.T) {
+	tests := []struct {
+		ex      flowExtract
+		wantErr bool
+	}{
+		{flowExtract{Var: "id", Json: "$.id"}, false},
+		{flowExtract{Var: "token", Header: "X-Token"}, false},
+		{flowExtract{Var: "session", Cookie: "sid"}, false},
+		{flowExtract{Var: "csrf", Regex: `name="csrf" value="([^"]+)"`}, false},
+		{flowExtract{Json: "$.id"}, true},
+		{flowExtract{Var: "id"}, true},
+		{flowExtract{Var: "id", Json: "$.id", Header: "X-Id"}, true},
+		{flowExtract{Var: "id", Regex: "("}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.ex.prepare(); (err != nil) != tt.wantErr {
+			t.Errorf("prepare(%+v) error = %v, want error %v", tt.ex, err, tt.wantErr)
+		}
+	}
+}
@@ -2,7 +2,10 @@ module request_recorder
 
 go 1.22
 
-require github.com/urfave/cli/v2 v2.27.3
+require (
+	github.com/urfave/cli/v2 v2.27.3
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.4 // indirect
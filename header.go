@@ -1,55 +1,58 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/textproto"
+	"slices"
+	"sort"
+	"strings"
 )
 
-type Header map[string]interface{}
+// HeaderField is one header line, Name keeps the casing it was received with.
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+// Header is an ordered list of header fields, so records keep the order,
+// casing and repetition of the headers. In JSON it is an object mapping names
+// to a string or an array of strings, or a list of [name, value] pairs if the
+// object cannot keep the order.
+type Header []HeaderField
 
 func (h Header) ToHttpHeader() http.Header {
 	header := make(http.Header, len(h))
-	for k, v := range h {
-		switch v := v.(type) {
-		case string:
-			header[k] = []string{v}
-		case []string:
-			header[k] = v
-		}
+	for _, f := range h {
+		header.Add(f.Name, f.Value)
 	}
 	return header
 }
 
 func (h Header) ToMIMEHeader() textproto.MIMEHeader {
-	header := make(textproto.MIMEHeader, len(h))
-	for k, v := range h {
-		switch v := v.(type) {
-		case string:
-			header.Set(k, v)
-		case []string:
-			header[k] = v
-		}
-	}
-	return header
+	return textproto.MIMEHeader(h.ToHttpHeader())
 }
 
+// FromHttpHeader replaces the fields, the order of an http.Header is unknown
+// so the names are sorted.
 func (h *Header) FromHttpHeader(header http.Header) {
+	*h = Header{}
 	if len(header) == 0 {
 		return
 	}
 
-	if *h == nil {
-		*h = make(Header)
+	names := make([]string, 0, len(header))
+	for k := range header {
+		names = append(names, k)
 	}
+	sort.Strings(names)
 
-	for k, v := range header {
-		if len(v) == 0 {
-			continue
-		}
-		if len(v) == 1 {
-			(*h)[k] = v[0]
-		} else {
-			(*h)[k] = v
+	for _, k := range names {
+		for _, v := range header[k] {
+			*h = append(*h, HeaderField{Name: k, Value: v})
 		}
 	}
 }
@@ -58,22 +61,185 @@ func (h *Header) FromMIMEHeader(header textproto.MIMEHeader) {
 	h.FromHttpHeader(http.Header(header))
 }
 
-func (h *Header) Get(key string) string {
-	if *h == nil {
-		return ""
+func (h Header) Get(key string) string {
+	for _, f := range h {
+		if strings.EqualFold(f.Name, key) {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+func (h Header) Values(key string) []string {
+	var values []string
+	for _, f := range h {
+		if strings.EqualFold(f.Name, key) {
+			values = append(values, f.Value)
+		}
 	}
-	v, ok := (*h)[key]
-	if !ok {
-		return ""
+	return values
+}
+
+// Set replaces the value of the first field named key in place and removes
+// the others, the field is appended if there is none.
+func (h *Header) Set(key, value string) {
+	found := false
+	*h = slices.DeleteFunc(*h, func(f HeaderField) bool {
+		if !strings.EqualFold(f.Name, key) {
+			return false
+		}
+		if found {
+			return true
+		}
+		found = true
+		return false
+	})
+	for i, f := range *h {
+		if strings.EqualFold(f.Name, key) {
+			(*h)[i].Value = value
+		}
 	}
-	if s, ok := v.(string); ok {
-		return s
+	if !found {
+		h.Add(key, value)
 	}
-	if s, ok := v.([]string); ok {
-		if len(s) == 0 {
-			return ""
+}
+
+func (h *Header) Add(key, value string) {
+	*h = append(*h, HeaderField{Name: key, Value: value})
+}
+
+func (h *Header) Del(key string) {
+	*h = slices.DeleteFunc(*h, func(f HeaderField) bool {
+		return strings.EqualFold(f.Name, key)
+	})
+}
+
+// grouped reports whether the object form keeps the fields as they are: the
+// fields of a name are adjacent and use the same casing.
+func (h Header) grouped() bool {
+	seen := make(map[string]string, len(h))
+	for i, f := range h {
+		key := strings.ToLower(f.Name)
+		name, ok := seen[key]
+		if !ok {
+			seen[key] = f.Name
+			continue
+		}
+		if name != f.Name || !strings.EqualFold(h[i-1].Name, f.Name) {
+			return false
 		}
-		return s[0]
 	}
-	return ""
+	return true
+}
+
+func (h Header) MarshalJSON() ([]byte, error) {
+	if len(h) == 0 {
+		return []byte("[]"), nil
+	}
+
+	if !h.grouped() {
+		pairs := make([][2]string, len(h))
+		for i, f := range h {
+			pairs[i] = [2]string{f.Name, f.Value}
+		}
+		return json.Marshal(pairs)
+	}
+
+	buffer := &bytes.Buffer{}
+	buffer.WriteByte('{')
+	for i := 0; i < len(h); {
+		j := i + 1
+		for j < len(h) && h[j].Name == h[i].Name {
+			j++
+		}
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+
+		name, _ := json.Marshal(h[i].Name)
+		buffer.Write(name)
+		buffer.WriteByte(':')
+
+		var value []byte
+		if j-i == 1 {
+			value, _ = json.Marshal(h[i].Value)
+		} else {
+			values := make([]string, 0, j-i)
+			for _, f := range h[i:j] {
+				values = append(values, f.Value)
+			}
+			value, _ = json.Marshal(values)
+		}
+		buffer.Write(value)
+		i = j
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+func (h *Header) UnmarshalJSON(data []byte) error {
+	*h = nil
+
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		return nil
+	case len(data) > 0 && data[0] == '[':
+		var pairs [][]string
+		if err := json.Unmarshal(data, &pairs); err != nil {
+			return fmt.Errorf("invalid header list: %s", err)
+		}
+		for _, pair := range pairs {
+			if len(pair) != 2 {
+				return errors.New("invalid header list: expect [name, value] pairs")
+			}
+			h.Add(pair[0], pair[1])
+		}
+		*h = slices.Clip(*h)
+		if *h == nil {
+			*h = Header{}
+		}
+		return nil
+	}
+
+	// decode the object token by token to keep the order of the names
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return errors.New("invalid header: expect an object or a list of pairs")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name := tok.(string)
+
+		var value interface{}
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		switch v := value.(type) {
+		case nil:
+		case string:
+			h.Add(name, v)
+		case []interface{}:
+			for _, item := range v {
+				h.Add(name, headerValue(item))
+			}
+		default:
+			h.Add(name, headerValue(v))
+		}
+	}
+	if *h == nil {
+		*h = Header{}
+	}
+	return nil
+}
+
+func headerValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
 }
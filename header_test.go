@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"slices"
+	"testing"
+)
+
+func TestHeaderJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  Header
+		json    string
+		grouped bool
+	}{
+		{"empty", Header{}, `[]`, true},
+		{"nil", nil, `[]`, true},
+		{"object keeps order", Header{{"X-B", "1"}, {"X-A", "2"}}, `{"X-B":"1","X-A":"2"}`, true},
+		{"repeated name", Header{{"Accept", "a"}, {"Accept", "b"}, {"X", "c"}}, `{"Accept":["a","b"],"X":"c"}`, true},
+		{"interleaved names", Header{{"A", "1"}, {"B", "2"}, {"A", "3"}}, `[["A","1"],["B","2"],["A","3"]]`, false},
+		{"mixed casing", Header{{"x-id", "1"}, {"X-Id", "2"}}, `[["x-id","1"],["X-Id","2"]]`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.header.grouped(); got != tt.grouped {
+				t.Errorf("grouped = %v, want %v", got, tt.grouped)
+			}
+			data, err := json.Marshal(tt.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.json {
+				t.Errorf("json = %s, want %s", data, tt.json)
+			}
+
+			var back Header
+			if err := json.Unmarshal(data, &back); err != nil {
+				t.Fatal(err)
+			}
+			if back == nil || !slices.Equal(back, tt.header) {
+				t.Errorf("round trip = %#v, want %#v", back, tt.header)
+			}
+		})
+	}
+}
+
+func TestHeaderUnmarshalJSON(t *testing.T) {
+	var h Header
+	if err := json.Unmarshal([]byte(`{"A": ["1", 2], "B": null, "C": true}`), &h); err != nil {
+		t.Fatal(err)
+	}
+	want := Header{{"A", "1"}, {"A", "2"}, {"C", "true"}}
+	if !slices.Equal(h, want) {
+		t.Errorf("header = %v, want %v", h, want)
+	}
+
+	for _, data := range []string{`[["A"]]`, `"A"`, `[1]`} {
+		if err := json.Unmarshal([]byte(data), &h); err == nil {
+			t.Errorf("%s is accepted", data)
+		}
+	}
+}
+
+func TestHeaderSet(t *testing.T) {
+	h := Header{{"a", "1"}, {"B", "2"}, {"A", "3"}}
+	h.Set("A", "x")
+	if want := (Header{{"a", "x"}, {"B", "2"}}); !slices.Equal(h, want) {
+		t.Errorf("header = %v, want %v", h, want)
+	}
+	h.Set("C", "y")
+	h.Del("b")
+	if want := (Header{{"a", "x"}, {"C", "y"}}); !slices.Equal(h, want) {
+		t.Errorf("header = %v, want %v", h, want)
+	}
+}
+
+func TestCaptureMultiPartHeader(t *testing.T) {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	body.WriteString("--" + mw.Boundary() + "\r\n" +
+		"content-disposition: form-data; name=\"a\"\r\n" +
+		"X-Z: 1\r\n" +
+		"X-A: 2\r\n\r\n" +
+		"one\r\n" +
+		"--" + mw.Boundary() + "\r\n\r\n" +
+		"two\r\n" +
+		"--" + mw.Boundary() + "--\r\n")
+
+	rr := &RequestResponse{}
+	header := Header{
+		{"Host", "example.com"},
+		{"Content-Type", mw.FormDataContentType()},
+		{"Content-Length", "100"},
+		{"Transfer-Encoding", "chunked"},
+	}
+	if err := captureBody(rr, header, body.Bytes(), t.TempDir(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	// the framing fields are kept for --ordered-headers
+	if !slices.Equal(rr.Header, header) {
+		t.Errorf("header = %v, want %v", rr.Header, header)
+	}
+	if len(rr.BodyMultiPart) != 2 {
+		t.Fatalf("parts = %d, want 2", len(rr.BodyMultiPart))
+	}
+	want := Header{{"content-disposition", `form-data; name="a"`}, {"X-Z", "1"}, {"X-A", "2"}}
+	if got := rr.BodyMultiPart[0].Header; !slices.Equal(got, want) {
+		t.Errorf("part header = %v, want %v", got, want)
+	}
+	if got := rr.BodyMultiPart[1]; len(got.Header) != 0 || got.Content != "two" {
+		t.Errorf("second part = %v %q", got.Header, got.Content)
+	}
+}
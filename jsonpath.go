@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is the small part of JSONPath the client commands need: '$'
+// followed by '.name', "['name']", '[0]', wildcards '.*' and '[*]', and the
+// recursive descent '..name'.
+type jsonPath []pathSegment
+
+type pathSegment struct {
+	kind  int
+	key   string
+	index int
+}
+
+const (
+	segmentKey = iota
+	segmentIndex
+	segmentWildcard
+	segmentDescend
+)
+
+func parseJSONPath(s string) (jsonPath, error) {
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("invalid JSONPath '%s': must start with '$'", s)
+	}
+
+	var p jsonPath
+	rest := s[1:]
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			p = append(p, pathSegment{kind: segmentDescend})
+			rest = rest[2:]
+			if strings.HasPrefix(rest, "[") {
+				continue
+			}
+		case rest[0] == '.':
+			rest = rest[1:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSONPath '%s': missing ']'", s)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+
+			switch {
+			case inner == "*":
+				p = append(p, pathSegment{kind: segmentWildcard})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				p = append(p, pathSegment{kind: segmentKey, key: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid JSONPath '%s': bad index '%s'", s, inner)
+				}
+				p = append(p, pathSegment{kind: segmentIndex, index: index})
+			}
+			continue
+		default:
+			return nil, fmt.Errorf("invalid JSONPath '%s': unexpected '%c'", s, rest[0])
+		}
+
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		name := rest[:end]
+		rest = rest[end:]
+		if name == "" {
+			return nil, fmt.Errorf("invalid JSONPath '%s': empty name", s)
+		}
+		if name == "*" {
+			p = append(p, pathSegment{kind: segmentWildcard})
+		} else {
+			p = append(p, pathSegment{kind: segmentKey, key: name})
+		}
+	}
+
+	if len(p) > 0 && p[len(p)-1].kind == segmentDescend {
+		return nil, fmt.Errorf("invalid JSONPath '%s': '..' needs a name", s)
+	}
+	return p, nil
+}
+
+// match reports whether the path selects the value at location, a list of
+// object keys and array indexes.
+func (p jsonPath) match(location []interface{}) bool {
+	if len(p) == 0 {
+		return len(location) == 0
+	}
+
+	if p[0].kind == segmentDescend {
+		for i := range location {
+			if p[1].matchOne(location[i]) && p[2:].match(location[i+1:]) {
+				return true
+			}
+		}
+		return false
+	}
+	return len(location) > 0 && p[0].matchOne(location[0]) && p[1:].match(location[1:])
+}
+
+func (s pathSegment) matchOne(step interface{}) bool {
+	switch s.kind {
+	case segmentWildcard:
+		return true
+	case segmentKey:
+		key, ok := step.(string)
+		return ok && key == s.key
+	case segmentIndex:
+		index, ok := step.(int)
+		return ok && index == s.index
+	}
+	return false
+}
+
+// formatJSONPath writes a location the way parseJSONPath reads it.
+func formatJSONPath(location []interface{}) string {
+	b := &strings.Builder{}
+	b.WriteString("$")
+	for _, step := range location {
+		switch v := step.(type) {
+		case int:
+			fmt.Fprintf(b, "[%d]", v)
+		case string:
+			if v != "" && !strings.ContainsAny(v, ".[]'\" ") {
+				b.WriteString("." + v)
+			} else {
+				fmt.Fprintf(b, "['%s']", v)
+			}
+		}
+	}
+	return b.String()
+}
+
+// find returns the values the path selects in v, a decoded JSON document.
+func (p jsonPath) find(v interface{}) []interface{} {
+	if len(p) == 0 {
+		return []interface{}{v}
+	}
+
+	var found []interface{}
+	switch p[0].kind {
+	case segmentDescend:
+		// apply the rest here and at every level below
+		found = append(found, p[1:].find(v)...)
+		for _, child := range jsonChildren(v) {
+			found = append(found, p.find(child)...)
+		}
+	case segmentWildcard:
+		for _, child := range jsonChildren(v) {
+			found = append(found, p[1:].find(child)...)
+		}
+	case segmentKey:
+		if m, ok := v.(map[string]interface{}); ok {
+			if child, ok := m[p[0].key]; ok {
+				found = append(found, p[1:].find(child)...)
+			}
+		}
+	case segmentIndex:
+		if list, ok := v.([]interface{}); ok {
+			i := p[0].index
+			if i < 0 {
+				i += len(list)
+			}
+			if i >= 0 && i < len(list) {
+				found = append(found, p[1:].find(list[i])...)
+			}
+		}
+	}
+	return found
+}
+
+// jsonChildren lists the members of an object, sorted by key, or an array.
+func jsonChildren(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		children := make([]interface{}, len(keys))
+		for i, k := range keys {
+			children[i] = v[k]
+		}
+		return children
+	case []interface{}:
+		return v
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		s    string
+		want jsonPath
+	}{
+		{"$", nil},
+		{"$.a.b", jsonPath{{kind: segmentKey, key: "a"}, {kind: segmentKey, key: "b"}}},
+		{"$['a.b'][0]", jsonPath{{kind: segmentKey, key: "a.b"}, {kind: segmentIndex, index: 0}}},
+		{`$["x"][-1]`, jsonPath{{kind: segmentKey, key: "x"}, {kind: segmentIndex, index: -1}}},
+		{"$.*[*]", jsonPath{{kind: segmentWildcard}, {kind: segmentWildcard}}},
+		{"$..id", jsonPath{{kind: segmentDescend}, {kind: segmentKey, key: "id"}}},
+		{"$..[1]", jsonPath{{kind: segmentDescend}, {kind: segmentIndex, index: 1}}},
+	}
+	for _, tt := range tests {
+		got, err := parseJSONPath(tt.s)
+		if err != nil {
+			t.Errorf("parseJSONPath(%q): %v", tt.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseJSONPath(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "a.b", "$.", "$..", "$.a[", "$[x]", "$a", "$.a..b.."} {
+		if _, err := parseJSONPath(s); err == nil {
+			t.Errorf("parseJSONPath(%q) is accepted", s)
+		}
+	}
+}
+
+func TestJSONPathMatch(t *testing.T) {
+	tests := []struct {
+		path     string
+		location []interface{}
+		want     bool
+	}{
+		{"$", nil, true},
+		{"$.a", []interface{}{"a"}, true},
+		{"$.a", []interface{}{"a", "b"}, false},
+		{"$.a[0]", []interface{}{"a", 0}, true},
+		{"$.a[0]", []interface{}{"a", "0"}, false},
+		{"$.*.b", []interface{}{"x", "b"}, true},
+		{"$..id", []interface{}{"id"}, true},
+		{"$..id", []interface{}{"items", 3, "id"}, true},
+		{"$..id", []interface{}{"items", 3, "id", "x"}, false},
+		{"$..items[*].id", []interface{}{"data", "items", 2, "id"}, true},
+		{"$..items[*].id", []interface{}{"data", "items", "id"}, false},
+	}
+	for _, tt := range tests {
+		p, err := parseJSONPath(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.match(tt.location); got != tt.want {
+			t.Errorf("%s match %v = %v, want %v", tt.path, tt.location, got, tt.want)
+		}
+	}
+}
+
+func TestJSONPathFind(t *testing.T) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(`{"id": 1, "items": [{"id": 2, "tags": ["a", "b"]}, {"id": 3}], "meta": {"id": 4}}`), &doc); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want []interface{}
+	}{
+		{"$.items[0].id", []interface{}{2.0}},
+		{"$.items[-1].id", []interface{}{3.0}},
+		{"$.items[5]", nil},
+		{"$.items[*].id", []interface{}{2.0, 3.0}},
+		{"$..id", []interface{}{1.0, 2.0, 3.0, 4.0}},
+		{"$..tags[1]", []interface{}{"b"}},
+		{"$.missing", nil},
+	}
+	for _, tt := range tests {
+		p, err := parseJSONPath(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.find(doc); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFormatJSONPath(t *testing.T) {
+	location := []interface{}{"a", 0, "b.c", "", "d"}
+	s := formatJSONPath(location)
+	if s != "$.a[0]['b.c'][''].d" {
+		t.Errorf("formatJSONPath = %s", s)
+	}
+	p, err := parseJSONPath(s)
+	if err != nil || !p.match(location) {
+		t.Errorf("%s does not parse back to its location: %v", s, err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listen opens the socket of a listener. Besides TCP addresses it accepts
+// 'unix:<path>' and 'systemd[:<name or index>]' for socket activation.
+func listen(l *listenerConfig) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(l.Listen, "unix:"):
+		return listenUnix(strings.TrimPrefix(l.Listen, "unix:"), l.SocketMode)
+	case l.Listen == "systemd" || strings.HasPrefix(l.Listen, "systemd:"):
+		return systemdListener(strings.TrimPrefix(strings.TrimPrefix(l.Listen, "systemd"), ":"))
+	default:
+		return net.Listen("tcp", l.Listen)
+	}
+}
+
+func listenUnix(path string, mode string) (net.Listener, error) {
+	// a socket file left by a previous run blocks listening
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %v", path, err)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != "" {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("invalid socket mode '%s': %v", mode, err)
+		}
+		if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("failed to change socket mode: %v", err)
+		}
+	}
+	return ln, nil
+}
+
+// the sockets passed by systemd, see sd_listen_fds(3)
+var systemdSockets struct {
+	once  sync.Once
+	files []*os.File
+	names []string
+	err   error
+}
+
+const systemdFirstFd = 3
+
+func loadSystemdSockets() {
+	s := &systemdSockets
+	s.names, s.err = systemdNames()
+	for i, name := range s.names {
+		s.files = append(s.files, os.NewFile(uintptr(systemdFirstFd+i), name))
+	}
+}
+
+// systemdNames reads the number and names of the passed sockets from the
+// environment, sockets without a name get an empty one.
+func systemdNames() ([]string, error) {
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, errors.New("LISTEN_PID does not match this process")
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, errors.New("no sockets passed, LISTEN_FDS is not set")
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	result := make([]string, n)
+	copy(result, names)
+	return result, nil
+}
+
+// systemdListener takes over an activated socket, selected by its
+// FileDescriptorName or index, the first one if empty.
+func systemdListener(name string) (net.Listener, error) {
+	s := &systemdSockets
+	s.once.Do(loadSystemdSockets)
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	i := 0
+	if name != "" {
+		var err error
+		if i, err = strconv.Atoi(name); err != nil {
+			i = -1
+			for j, n := range s.names {
+				if n == name {
+					i = j
+					break
+				}
+			}
+		}
+	}
+	if i < 0 || i >= len(s.files) {
+		return nil, fmt.Errorf("systemd socket '%s' not found", name)
+	}
+	if s.files[i] == nil {
+		return nil, fmt.Errorf("systemd socket '%s' is already used", name)
+	}
+
+	f := s.files[i]
+	s.files[i] = nil
+	defer f.Close()
+
+	return net.FileListener(f)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// shortTempDir is a temporary directory with a path short enough for unix
+// sockets.
+func shortTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "rr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(shortTempDir(t), "rr.sock")
+
+	ln, err := listenUnix(path, "660")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0660 {
+		t.Errorf("socket mode = %s, want a socket with 0660", fi.Mode())
+	}
+
+	// a crashed server leaves its socket behind
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("stale socket: %v", err)
+	}
+	ln, err = listenUnix(path, "")
+	if err != nil {
+		t.Fatalf("listen on stale socket: %v", err)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	ln.Close()
+}
+
+func TestListenUnixInvalid(t *testing.T) {
+	dir := shortTempDir(t)
+
+	// a regular file is never removed
+	path := filepath.Join(dir, "file")
+	if err := os.WriteFile(path, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ln, err := listenUnix(path, ""); err == nil {
+		ln.Close()
+		t.Error("listening over a regular file succeeded")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "keep" {
+		t.Error("regular file was replaced")
+	}
+
+	if ln, err := listenUnix(filepath.Join(dir, "bad.sock"), "rw"); err == nil {
+		ln.Close()
+		t.Error("invalid mode accepted")
+	}
+}
+
+func TestSystemdNames(t *testing.T) {
+	tests := []struct {
+		pid     string
+		fds     string
+		names   string
+		want    []string
+		wantErr bool
+	}{
+		{"", "2", "http:admin", []string{"http", "admin"}, false},
+		{"self", "3", "http", []string{"http", "", ""}, false},
+		{"", "1", "a:b", []string{"a"}, false},
+		{"", "1", "", []string{""}, false},
+		{"1", "1", "", nil, true},
+		{"", "", "", nil, true},
+		{"", "0", "", nil, true},
+	}
+	for _, tt := range tests {
+		pid := tt.pid
+		if pid == "self" {
+			pid = strconv.Itoa(os.Getpid())
+		}
+		t.Setenv("LISTEN_PID", pid)
+		t.Setenv("LISTEN_FDS", tt.fds)
+		t.Setenv("LISTEN_FDNAMES", tt.names)
+
+		got, err := systemdNames()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pid %q fds %q: error = %v, want error %v", tt.pid, tt.fds, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("pid %q fds %q names %q = %q, want %q", tt.pid, tt.fds, tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestSystemdListener(t *testing.T) {
+	// stands in for the sockets systemd passes
+	var files []*os.File
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := ln.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln.Close()
+		files = append(files, f)
+	}
+
+	s := &systemdSockets
+	s.once.Do(func() {})
+	savedFiles, savedNames, savedErr := s.files, s.names, s.err
+	s.files, s.names, s.err = files, []string{"http", "admin"}, nil
+	t.Cleanup(func() {
+		s.files, s.names, s.err = savedFiles, savedNames, savedErr
+	})
+
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"admin", false},
+		{"0", false},
+		{"admin", true},
+		{"", true},
+		{"2", true},
+		{"other", true},
+	}
+	for _, tt := range tests {
+		ln, err := systemdListener(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("systemdListener(%q) error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if ln != nil {
+			ln.Close()
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestLog describes one handled request for the request loggers, Seq and
+// File are empty when the request was not recorded.
+type requestLog struct {
+	Seq      int
+	Time     time.Time
+	Request  *http.Request
+	Status   int
+	Bytes    int
+	Duration time.Duration
+	File     string
+}
+
+// requestLogger writes a line per recorded request, either structured with
+// slog or in the Apache combined format, optionally also to an access log.
+type requestLogger struct {
+	logger   *slog.Logger
+	combined io.Writer
+	access   io.Writer
+
+	mu sync.Mutex
+}
+
+func newRequestLogger(format string, accessLog string) (*requestLogger, error) {
+	l := &requestLogger{}
+
+	switch format {
+	case "", "text":
+		// slog writes through the standard logger, keeping its time format
+		l.logger = slog.Default()
+	case "json":
+		l.logger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+		slog.SetDefault(l.logger)
+	case "combined":
+		l.combined = os.Stdout
+	default:
+		return nil, fmt.Errorf("unknown log format '%s', expect 'text', 'json' or 'combined'", format)
+	}
+
+	if accessLog != "" {
+		f, err := os.OpenFile(accessLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open access log: %s", err)
+		}
+		l.access = f
+		log.Printf("Access log writes to '%s'", accessLog)
+	}
+	return l, nil
+}
+
+func (l *requestLogger) log(e *requestLog) {
+	if l.logger != nil {
+		l.logger.Info("request",
+			"seq", e.Seq,
+			"method", e.Request.Method,
+			"path", e.Request.URL.Path,
+			"status", e.Status,
+			"bytes", e.Bytes,
+			"duration", e.Duration.Seconds(),
+			"remote", e.Request.RemoteAddr,
+			"file", e.File)
+	}
+
+	if l.combined == nil && l.access == nil {
+		return
+	}
+	line := combinedLogLine(e)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, w := range []io.Writer{l.combined, l.access} {
+		if w == nil {
+			continue
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			log.Printf("failed to write access log: %v", err)
+		}
+	}
+}
+
+// combinedLogLine formats the request like the Apache combined log format:
+// host ident user [time] "request" status bytes "referer" "user-agent"
+func combinedLogLine(e *requestLog) string {
+	r := e.Request
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if host == "" || host == "@" {
+		host = "-"
+	}
+
+	user := "-"
+	if name, _, ok := r.BasicAuth(); ok && name != "" {
+		user = name
+	}
+
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = fmt.Sprint(e.Bytes)
+	}
+
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return logEscape(s)
+	}
+
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		host,
+		logEscape(user),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method,
+		logEscape(r.RequestURI),
+		r.Proto,
+		e.Status,
+		bytes,
+		orDash(r.Referer()),
+		orDash(r.UserAgent()))
+}
+
+var logEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, "\n", `\n`, "\r", `\r`)
+
+func logEscape(s string) string {
+	return logEscaper.Replace(s)
+}
@@ -18,6 +18,13 @@ func main() {
 	app.Commands = []*cli.Command{
 		serverCmd(),
 		clientCmd(),
+		tailCmd(),
+		relayCmd(),
+		tunnelCmd(),
+		benchCmd(),
+		flowCmd(),
+		exportCmd(),
+		importCmd(),
 	}
 	err := app.Run(os.Args)
 	if err != nil {
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the metrics are written in the Prometheus text exposition format
+var (
+	requestsTotal = newCounterVec("request_recorder_requests_total",
+		"Recorded requests by listener, method, route and status.",
+		"listener", "method", "route", "status")
+	filteredTotal = newCounterVec("request_recorder_filtered_requests_total",
+		"Requests answered without recording because of the filters.",
+		"listener", "method")
+	captureFailures = newCounterVec("request_recorder_capture_failures_total",
+		"Failures while capturing requests, by stage.",
+		"listener", "stage")
+	teeDeliveries = newCounterVec("request_recorder_tee_deliveries_total",
+		"Requests forwarded to tee targets, by result.",
+		"target", "result")
+	requestBodySize = newHistogramVec("request_recorder_request_body_bytes",
+		"Size of request bodies.",
+		sizeBuckets, "listener")
+	responseBodySize = newHistogramVec("request_recorder_response_body_bytes",
+		"Size of response bodies.",
+		sizeBuckets, "listener")
+	captureDuration = newHistogramVec("request_recorder_capture_duration_seconds",
+		"Time spent capturing and saving a request, the responder excluded.",
+		durationBuckets, "listener")
+	storageDuration = newHistogramVec("request_recorder_storage_write_duration_seconds",
+		"Time spent writing record and body files.",
+		durationBuckets, "kind")
+)
+
+var (
+	sizeBuckets     = []float64{0, 64, 256, 1024, 4096, 16384, 65536, 262144, 1 << 20, 4 << 20, 16 << 20}
+	durationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+)
+
+type metricSeries struct {
+	labels []string
+	value  float64
+
+	// histograms only
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type metricVec struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+func newCounterVec(name, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: "counter", labels: labels}
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets}
+}
+
+// with returns the series of the label values, the caller holds the lock.
+func (m *metricVec) with(values []string) *metricSeries {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s: expect %d labels, got %d", m.name, len(m.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		if m.series == nil {
+			m.series = make(map[string]*metricSeries)
+		}
+		s = &metricSeries{labels: values}
+		if m.kind == "histogram" {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metricVec) inc(labels ...string) {
+	m.mu.Lock()
+	m.with(labels).value++
+	m.mu.Unlock()
+}
+
+func (m *metricVec) observe(v float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.with(labels)
+	for i, upper := range m.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (m *metricVec) observeSince(start time.Time, labels ...string) {
+	m.observe(time.Since(start).Seconds(), labels...)
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := m.series[k]
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.labels), formatValue(s.value))
+			continue
+		}
+
+		names := append(m.labels[:len(m.labels):len(m.labels)], "le")
+		for i, upper := range m.buckets {
+			values := append(s.labels[:len(s.labels):len(s.labels)], formatValue(upper))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(names, values), s.counts[i])
+		}
+		values := append(s.labels[:len(s.labels):len(s.labels)], "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(names, values), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labels), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.labels), s.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var idSegment = regexp.MustCompile(`^([0-9]+|[0-9a-fA-F]{16,}|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})$`)
+
+// metricRoute keeps the route label bounded, ids in the path are replaced by
+// ':id' and long paths are cut.
+func metricRoute(p string) string {
+	segments := strings.Split(p, "/")
+	if len(segments) > 6 {
+		segments = append(segments[:6], "*")
+	}
+	for i, s := range segments {
+		if idSegment.MatchString(s) {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// metricMethods are the method label values, other methods are counted as
+// 'OTHER'.
+var metricMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE"}
+
+func metricMethod(method string) string {
+	if slices.Contains(metricMethods, method) {
+		return method
+	}
+	return "OTHER"
+}
+
+// maxMetricRoutes caps the distinct route labels, routes seen after the cap
+// is reached are counted as 'other'.
+const maxMetricRoutes = 200
+
+var metricRoutes = &routeSet{limit: maxMetricRoutes}
+
+type routeSet struct {
+	limit int
+
+	mu     sync.Mutex
+	routes map[string]struct{}
+}
+
+func (s *routeSet) label(route string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.routes[route]; ok {
+		return route
+	}
+	if len(s.routes) >= s.limit {
+		return "other"
+	}
+	if s.routes == nil {
+		s.routes = make(map[string]struct{})
+	}
+	s.routes[route] = struct{}{}
+	return route
+}
+
+func metricsHandler(store *recordStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		for _, m := range []*metricVec{
+			requestsTotal,
+			filteredTotal,
+			captureFailures,
+			teeDeliveries,
+			requestBodySize,
+			responseBodySize,
+			captureDuration,
+			storageDuration,
+		} {
+			m.write(w)
+		}
+
+		fmt.Fprintf(w, "# HELP request_recorder_admin_buffered_records Recent records kept in memory for the admin api, at most --admin-records.\n")
+		fmt.Fprintf(w, "# TYPE request_recorder_admin_buffered_records gauge\n")
+		fmt.Fprintf(w, "request_recorder_admin_buffered_records %d\n", store.len())
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricMethod(t *testing.T) {
+	for method, want := range map[string]string{
+		"GET":      "GET",
+		"DELETE":   "DELETE",
+		"get":      "OTHER",
+		"PROPFIND": "OTHER",
+		"":         "OTHER",
+	} {
+		if got := metricMethod(method); got != want {
+			t.Errorf("metricMethod(%q) = %q, want %q", method, got, want)
+		}
+	}
+}
+
+func TestMetricRoute(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/users/42", "/users/:id"},
+		{"/users/550e8400-e29b-41d4-a716-446655440000/orders", "/users/:id/orders"},
+		{"/a/b/c/d/e/f/g", "/a/b/c/d/e/*"},
+		{"/static/app.js", "/static/app.js"},
+	}
+	for _, tt := range tests {
+		if got := metricRoute(tt.path); got != tt.want {
+			t.Errorf("metricRoute(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRouteSetLimit(t *testing.T) {
+	s := &routeSet{limit: 3}
+	for i := 0; i < 3; i++ {
+		route := fmt.Sprintf("/r%d", i)
+		if got := s.label(route); got != route {
+			t.Errorf("label(%q) = %q", route, got)
+		}
+	}
+	if got := s.label("/r3"); got != "other" {
+		t.Errorf("route over the limit = %q, want other", got)
+	}
+	if got := s.label("/r1"); got != "/r1" {
+		t.Errorf("known route = %q, want /r1", got)
+	}
+}
+
+func TestMetricsBufferedRecords(t *testing.T) {
+	store := newRecordStore(2)
+	for seq := 1; seq <= 3; seq++ {
+		store.add(&recordEntry{Seq: seq, Record: &Record{}})
+	}
+	w := httptest.NewRecorder()
+	metricsHandler(store)(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), "\nrequest_recorder_admin_buffered_records 2\n") {
+		t.Errorf("buffered records missing from:\n%s", w.Body)
+	}
+}
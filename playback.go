@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+type playbackEntry struct {
+	dir    string
+	record *Record
+	body   []byte
+}
+
+type playback struct {
+	query     bool
+	headers   []string
+	body      bool
+	jsonBody  bool
+	onMiss    string
+	missProxy http.HandlerFunc
+
+	mu      sync.RWMutex
+	entries []*playbackEntry
+}
+
+func newPlayback(dir string, match []string, onMiss string, proxy string) (*playback, error) {
+	p := &playback{onMiss: onMiss}
+
+	for _, m := range match {
+		switch {
+		case m == "method" || m == "path":
+			// always matched
+		case m == "query":
+			p.query = true
+		case m == "body":
+			p.body = true
+		case m == "json":
+			p.jsonBody = true
+		case strings.HasPrefix(m, "header:"):
+			p.headers = append(p.headers, textproto.CanonicalMIMEHeaderKey(strings.TrimPrefix(m, "header:")))
+		default:
+			return nil, fmt.Errorf("unknown playback match '%s'", m)
+		}
+	}
+
+	switch onMiss {
+	case "404", "fail":
+	case "proxy":
+		if proxy == "" {
+			return nil, fmt.Errorf("playback miss behavior 'proxy' requires a proxy url")
+		}
+		var err error
+		p.missProxy, err = proxyResponse(proxy)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown playback miss behavior '%s'", onMiss)
+	}
+
+	if err := p.load(dir); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *playback) load(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory %s: %v", dir, err)
+	}
+
+	for _, d := range entries {
+		if d.IsDir() || filepath.Ext(d.Name()) != ".json" {
+			continue
+		}
+
+		// attachments may be json files too, skip everything not looking like a record
+		var record Record
+		if err := loadRecord(filepath.Join(dir, d.Name()), &record); err != nil {
+			continue
+		}
+		if record.Method == "" || record.Request == nil || record.Response == nil {
+			continue
+		}
+
+		if err := p.add(&record, dir); err != nil {
+			return fmt.Errorf("failed to load %s: %v", d.Name(), err)
+		}
+	}
+
+	log.Printf("Playback %d recorded responses from '%s'", len(p.entries), dir)
+	return nil
+}
+
+func (p *playback) add(record *Record, dir string) error {
+	entry := &playbackEntry{dir: dir, record: record}
+	if p.body || p.jsonBody {
+		body, err := readRecordBody(record.Request, dir)
+		if err != nil {
+			return err
+		}
+		entry.body = body
+	}
+
+	p.mu.Lock()
+	p.entries = append(p.entries, entry)
+	p.mu.Unlock()
+	return nil
+}
+
+// learn keeps a proxied exchange, so later matching requests are served from it.
+func (p *playback) learn(record *Record, dir string) {
+	if p.onMiss != "proxy" {
+		return
+	}
+	if err := p.add(record, dir); err != nil {
+		log.Printf("failed to add playback record: %v", err)
+	}
+}
+
+func (p *playback) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("failed to read body"))
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if entry := p.find(r, body); entry != nil {
+		if err := writeRecordedResponse(w, entry.record.Response, entry.dir); err != nil {
+			log.Printf("failed to play back response: %v", err)
+		}
+		return
+	}
+
+	switch p.onMiss {
+	case "proxy":
+		if ex := exchangeFromContext(r.Context()); ex != nil {
+			ex.playbackMiss = true
+		}
+		p.missProxy(w, r)
+	case "fail":
+		log.Printf("PLAYBACK MISS: no recorded response for %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("playback miss: no recorded response matches the request"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("no recorded response matches the request"))
+	}
+}
+
+func (p *playback) find(r *http.Request, body []byte) *playbackEntry {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, entry := range p.entries {
+		if p.matches(entry, r, body) {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (p *playback) matches(entry *playbackEntry, r *http.Request, body []byte) bool {
+	record := entry.record
+	if record.Method != r.Method {
+		return false
+	}
+
+	uri, err := url.Parse(record.URL)
+	if err != nil || uri.Path != r.URL.Path {
+		return false
+	}
+	if p.query && !reflect.DeepEqual(uri.Query(), r.URL.Query()) {
+		return false
+	}
+
+	for _, name := range p.headers {
+		if record.Request.Header.Get(name) != r.Header.Get(name) {
+			return false
+		}
+	}
+
+	if p.body {
+		if record.Request.BodyJson != nil {
+			if !equalJson(entry.body, body) {
+				return false
+			}
+		} else if !bytes.Equal(entry.body, body) {
+			return false
+		}
+	}
+	if p.jsonBody && !jsonSubset(entry.body, body) {
+		return false
+	}
+
+	return true
+}
+
+func readRecordBody(rr *RequestResponse, dir string) ([]byte, error) {
+	body, err := parseRecordBody(rr, rr.Header.ToHttpHeader(), dir)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+func writeRecordedResponse(w http.ResponseWriter, rr *RequestResponse, dir string) error {
+	header := rr.Header.ToHttpHeader()
+	body, err := parseRecordBody(rr, header, dir)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("failed to load recorded response"))
+		return err
+	}
+	defer body.Close()
+
+	// the stored body may differ from the original bytes on the wire
+	header.Del("Content-Length")
+	header.Del("Transfer-Encoding")
+	header.Del("Date")
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+
+	status := rr.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+
+	_, err = io.Copy(w, body)
+	return err
+}
+
+func equalJson(a, b []byte) bool {
+	var bufA, bufB bytes.Buffer
+	if json.Compact(&bufA, a) != nil || json.Compact(&bufB, b) != nil {
+		return false
+	}
+	return bytes.Equal(bufA.Bytes(), bufB.Bytes())
+}
+
+// jsonSubset reports whether every field of want is present in got.
+func jsonSubset(want, got []byte) bool {
+	var vWant, vGot interface{}
+	if err := decodeJsonNumber(want, &vWant); err != nil {
+		return false
+	}
+	if err := decodeJsonNumber(got, &vGot); err != nil {
+		return false
+	}
+	return jsonContains(vWant, vGot)
+}
+
+func decodeJsonNumber(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func jsonContains(want, got interface{}) bool {
+	switch want := want.(type) {
+	case map[string]interface{}:
+		got, ok := got.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range want {
+			gv, ok := got[k]
+			if !ok || !jsonContains(v, gv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		got, ok := got.([]interface{})
+		if !ok || len(got) != len(want) {
+			return false
+		}
+		for i := range want {
+			if !jsonContains(want[i], got[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(want, got)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeTestRecord(t *testing.T, dir, name string, record *Record) {
+	t.Helper()
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlaybackMatch(t *testing.T) {
+	dir := t.TempDir()
+	writeTestRecord(t, dir, "0001_a.json", &Record{
+		Method: "GET",
+		URL:    "/items?page=1",
+		Request: &RequestResponse{
+			Header: Header{{Name: "X-Tenant", Value: "a"}},
+		},
+		Response: &RequestResponse{Status: 200, Body: "tenant a page 1"},
+	})
+	writeTestRecord(t, dir, "0002_b.json", &Record{
+		Method: "GET",
+		URL:    "/items?page=2",
+		Request: &RequestResponse{
+			Header: Header{{Name: "X-Tenant", Value: "b"}},
+		},
+		Response: &RequestResponse{Status: 200, Body: "tenant b page 2"},
+	})
+	writeTestRecord(t, dir, "0003_c.json", &Record{
+		Method: "POST",
+		URL:    "/orders",
+		Request: &RequestResponse{
+			Header:   Header{{Name: "Content-Type", Value: "application/json"}},
+			BodyJson: json.RawMessage(`{"id": 1, "items": [{"sku": "x"}]}`),
+		},
+		Response: &RequestResponse{Status: 201, Body: "created"},
+	})
+	// not a record, skipped when loading
+	if err := os.WriteFile(filepath.Join(dir, "attachment.json"), []byte(`{"a": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		match  []string
+		method string
+		target string
+		header map[string]string
+		body   string
+		status int
+		want   string
+	}{
+		{"method and path", nil, "GET", "/items?page=9", nil, "", 200, "tenant a page 1"},
+		{"wrong method", nil, "DELETE", "/items", nil, "", 404, ""},
+		{"wrong path", nil, "GET", "/other", nil, "", 404, ""},
+		{"query", []string{"query"}, "GET", "/items?page=2", nil, "", 200, "tenant b page 2"},
+		{"query miss", []string{"query"}, "GET", "/items?page=3", nil, "", 404, ""},
+		{"header", []string{"header:x-tenant"}, "GET", "/items", map[string]string{"X-Tenant": "b"}, "", 200, "tenant b page 2"},
+		{"body json ignores spacing", []string{"body"}, "POST", "/orders", nil, `{"id":1,"items":[{"sku":"x"}]}`, 201, "created"},
+		{"body differs", []string{"body"}, "POST", "/orders", nil, `{"id":2,"items":[{"sku":"x"}]}`, 404, ""},
+		{"json superset", []string{"json"}, "POST", "/orders", nil, `{"id":1,"extra":true,"items":[{"sku":"x","qty":2}]}`, 201, "created"},
+		{"json missing field", []string{"json"}, "POST", "/orders", nil, `{"items":[{"sku":"x"}]}`, 404, ""},
+		{"json number", []string{"json"}, "POST", "/orders", nil, `{"id":1.0,"items":[{"sku":"x"}]}`, 404, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newPlayback(dir, tt.match, "404", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(p.entries) != 3 {
+				t.Fatalf("loaded %d records, want 3", len(p.entries))
+			}
+
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.want != "" && w.Body.String() != tt.want {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestPlaybackMiss(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := newPlayback(dir, nil, "proxy", ""); err == nil {
+		t.Error("proxy miss behavior without proxy url is accepted")
+	}
+	if _, err := newPlayback(dir, []string{"cookie"}, "404", ""); err == nil {
+		t.Error("unknown match is accepted")
+	}
+
+	p, err := newPlayback(dir, nil, "fail", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+}
+
+func TestWriteRecordedResponseContentType(t *testing.T) {
+	tests := []struct {
+		rr   *RequestResponse
+		want []string
+	}{
+		{&RequestResponse{Status: 204}, nil},
+		{&RequestResponse{Status: 200, Body: "no type recorded"}, nil},
+		{&RequestResponse{Status: 200, Header: Header{{Name: "Content-Type", Value: "text/html"}}, Body: "<p>"}, []string{"text/html"}},
+		{&RequestResponse{Status: 200, BodyJson: json.RawMessage(`{"a":1}`)}, []string{"application/json"}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		if err := writeRecordedResponse(w, tt.rr, t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Header().Values("Content-Type"); !slices.Equal(got, tt.want) {
+			t.Errorf("status %d body %q: Content-Type = %q, want %q", tt.rr.Status, tt.rr.Body, got, tt.want)
+		}
+	}
+}
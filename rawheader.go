@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// net/http canonicalizes header names and loses their order, a listener with
+// raw_headers keeps the bytes read from each connection so the handler can
+// parse the request head as it was sent.
+const (
+	rawHeaderWindow = 2 << 20
+	rawHeaderKeep   = 1<<20 + 64<<10
+)
+
+type rawConnKey struct{}
+
+type rawHeaderListener struct {
+	net.Listener
+}
+
+func (l rawHeaderListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &rawHeaderConn{Conn: c}, nil
+}
+
+// rawHeaderContext makes the connection of a request available to rawHeader.
+func rawHeaderContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, rawConnKey{}, c)
+}
+
+type rawHeaderConn struct {
+	net.Conn
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (c *rawHeaderConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		c.buf = append(c.buf, p[:n]...)
+		// bodies pass through as well, only keep enough for the next head
+		if len(c.buf) > rawHeaderWindow {
+			c.buf = append(c.buf[:0], c.buf[len(c.buf)-rawHeaderKeep:]...)
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// header finds the head of r in the bytes read so far and drops everything
+// up to its end.
+func (c *rawHeaderConn) header(r *http.Request) (Header, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	requestLine := []byte(r.Method + " " + r.RequestURI + " " + r.Proto + "\r\n")
+	start := 0
+	for {
+		i := bytes.Index(c.buf[start:], requestLine)
+		if i < 0 {
+			return nil, false
+		}
+		start += i
+		if start == 0 || c.buf[start-1] == '\n' {
+			break
+		}
+		start++
+	}
+
+	head := c.buf[start+len(requestLine):]
+	end := bytes.Index(head, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, false
+	}
+	header := parseRawHeader(string(head[:end]))
+	c.buf = c.buf[start+len(requestLine)+end+4:]
+	return header, true
+}
+
+func parseRawHeader(head string) Header {
+	header := Header{}
+	for _, line := range strings.Split(head, "\r\n") {
+		if line == "" {
+			continue
+		}
+		// obsolete line folding continues the previous value
+		if (line[0] == ' ' || line[0] == '\t') && len(header) > 0 {
+			header[len(header)-1].Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		header.Add(name, strings.TrimSpace(value))
+	}
+	return header
+}
+
+// rawPartHeads returns the headers of the parts of a multipart body as they
+// were written, with order and casing. Only CRLF line ends are handled, the
+// caller checks the heads against the parsed parts.
+func rawPartHeads(body []byte, boundary string) []Header {
+	delim := []byte("--" + boundary)
+	var heads []Header
+	rest := body
+	for {
+		i := bytes.Index(rest, delim)
+		if i < 0 {
+			return heads
+		}
+		lineStart := i == 0 || rest[i-1] == '\n'
+		rest = rest[i+len(delim):]
+		if !lineStart {
+			continue
+		}
+		if bytes.HasPrefix(rest, []byte("--")) {
+			return heads
+		}
+
+		// skip the rest of the boundary line
+		eol := bytes.Index(rest, []byte("\r\n"))
+		if eol < 0 {
+			return heads
+		}
+		rest = rest[eol+2:]
+
+		end := bytes.Index(rest, []byte("\r\n\r\n"))
+		if bytes.HasPrefix(rest, []byte("\r\n")) {
+			end = -2
+		} else if end < 0 {
+			return heads
+		}
+		heads = append(heads, parseRawHeader(string(rest[:max(end, 0)])))
+		rest = rest[end+4:]
+	}
+}
+
+// rawHeader returns the header of r as received, or the fields of r.Header
+// when the listener does not keep raw headers. The TLS state hidden by the
+// connection wrapper is restored as well.
+func rawHeader(r *http.Request) Header {
+	var header Header
+	c, ok := r.Context().Value(rawConnKey{}).(*rawHeaderConn)
+	if ok {
+		if tc, isTLS := c.Conn.(*tls.Conn); isTLS && r.TLS == nil {
+			state := tc.ConnectionState()
+			r.TLS = &state
+		}
+		if h, found := c.header(r); found {
+			return h
+		}
+	}
+
+	header.FromHttpHeader(r.Header)
+	return header
+}
+
+// orderedTransport writes the request head itself, so the headers go out in
+// the recorded order and casing. Values changed on the request, like the
+// authorization of --basic, are written where the recorded field was.
+type orderedTransport struct {
+	base  *http.Transport
+	order Header
+}
+
+func (t *orderedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := t.dial(req)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(req.Context(), func() {
+		conn.Close()
+	})
+
+	w := bufio.NewWriter(conn)
+	chunked := t.writeHead(w, req, len(body))
+	if chunked {
+		if len(body) > 0 {
+			fmt.Fprintf(w, "%x\r\n", len(body))
+			w.Write(body)
+			w.WriteString("\r\n")
+		}
+		w.WriteString("0\r\n\r\n")
+	} else {
+		w.Write(body)
+	}
+	if err := w.Flush(); err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+	resp.Body = &orderedBody{ReadCloser: resp.Body, conn: conn, stop: stop}
+	return resp, nil
+}
+
+func (t *orderedTransport) dial(req *http.Request) (net.Conn, error) {
+	addr := req.URL.Host
+	if req.URL.Port() == "" {
+		port := "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(req.URL.Hostname(), port)
+	}
+
+	dialContext := t.base.DialContext
+	if dialContext == nil {
+		dialContext = (&net.Dialer{}).DialContext
+	}
+	if req.URL.Scheme != "https" {
+		return dialContext(req.Context(), "tcp", addr)
+	}
+	if t.base.DialTLSContext != nil {
+		return t.base.DialTLSContext(req.Context(), "tcp", addr)
+	}
+
+	rawConn, err := dialContext(req.Context(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{}
+	if t.base.TLSClientConfig != nil {
+		config = t.base.TLSClientConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = req.URL.Hostname()
+	}
+	config.NextProtos = []string{"http/1.1"}
+	conn := tls.Client(rawConn, config)
+	if err := conn.HandshakeContext(req.Context()); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// writeHead writes the request line and the headers, it reports whether the
+// body is sent chunked like the recorded request was.
+func (t *orderedTransport) writeHead(w *bufio.Writer, req *http.Request, length int) bool {
+	fmt.Fprintf(w, "%s %s HTTP/1.1\r\n", req.Method, req.URL.RequestURI())
+
+	chunked := isChunked(t.order.Values("Transfer-Encoding"))
+	sendLength := !chunked && (length > 0 || req.Method == http.MethodPost ||
+		req.Method == http.MethodPut || req.Method == http.MethodPatch)
+
+	remaining := req.Header.Clone()
+	if remaining == nil {
+		remaining = http.Header{}
+	}
+	written := make(map[string]bool)
+	writeField := func(name, value string) {
+		fmt.Fprintf(w, "%s: %s\r\n", name, value)
+	}
+
+	if t.order.Get("Host") == "" {
+		writeField("Host", req.Host)
+	}
+	for _, f := range t.order {
+		key := http.CanonicalHeaderKey(f.Name)
+		switch key {
+		case "Host":
+			if !written[key] {
+				writeField(f.Name, req.Host)
+			}
+		case "Content-Length":
+			if sendLength && !written[key] {
+				writeField(f.Name, strconv.Itoa(length))
+			}
+		case "Transfer-Encoding":
+			if chunked && !written[key] {
+				writeField(f.Name, "chunked")
+			}
+		default:
+			values := remaining[key]
+			if slices.Equal(values, t.order.Values(key)) {
+				// unchanged, every field is written as recorded
+				if len(values) > 0 {
+					writeField(f.Name, f.Value)
+				}
+			} else if !written[key] {
+				for _, v := range values {
+					writeField(f.Name, v)
+				}
+			}
+		}
+		written[key] = true
+	}
+
+	var names []string
+	for k := range remaining {
+		k = http.CanonicalHeaderKey(k)
+		if !written[k] && k != "Host" && k != "Content-Length" && k != "Transfer-Encoding" {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		for _, v := range remaining[k] {
+			writeField(k, v)
+		}
+	}
+	if sendLength && !written["Content-Length"] {
+		writeField("Content-Length", strconv.Itoa(length))
+	}
+	w.WriteString("\r\n")
+	return chunked
+}
+
+// isChunked reports whether chunked is the last transfer coding, the body of
+// the record is stored decoded so only the chunking is sent again.
+func isChunked(values []string) bool {
+	codings := strings.Split(strings.Join(values, ","), ",")
+	return strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+}
+
+// orderedBody closes the connection with the response, it is never reused.
+type orderedBody struct {
+	io.ReadCloser
+	conn net.Conn
+	stop func() bool
+}
+
+func (b *orderedBody) Close() error {
+	b.stop()
+	err := b.ReadCloser.Close()
+	b.conn.Close()
+	return err
+}
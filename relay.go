@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func relayCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "relay",
+		Usage: "Deliver recorded requests to a target in order, waiting for it to come online",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:     "source",
+				Aliases:  []string{"i"},
+				Usage:    "Save directory or JSONL journal of a server",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "target",
+				Aliases:  []string{"t"},
+				Usage:    "Target url, the path of the records is used when it has no path",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "cursor",
+				Usage: "File keeping the delivered records, default is '<source>.cursor'",
+			},
+			&cli.IntFlag{
+				Name:  "from",
+				Usage: "Start with this record number instead of the cursor",
+			},
+			&cli.StringFlag{
+				Name:  "dead-letter",
+				Usage: "Directory receiving the records the target rejects or keeps failing on",
+			},
+			&cli.IntFlag{
+				Name:  "retries",
+				Usage: "Retries on 5xx, 408 and 429 before a record goes to the dead letters, connection errors are retried forever",
+				Value: 5,
+			},
+			&cli.DurationFlag{
+				Name:  "backoff",
+				Usage: "First retry delay, doubled on every retry",
+				Value: time.Second,
+			},
+			&cli.DurationFlag{
+				Name:  "max-backoff",
+				Usage: "Maximum retry delay",
+				Value: time.Minute,
+			},
+			&cli.DurationFlag{
+				Name:  "interval",
+				Usage: "Poll interval of the source",
+				Value: 2 * time.Second,
+			},
+			&cli.DurationFlag{
+				Name:  "gap-wait",
+				Usage: "Wait for a missing record number this long before skipping it, slow requests are saved after later ones",
+				Value: time.Minute,
+			},
+			&cli.BoolFlag{
+				Name:  "once",
+				Usage: "Exit after delivering the pending records",
+			},
+		}, transportFlags()...),
+		Action: func(c *cli.Context) error {
+			target, err := url.Parse(c.String("target"))
+			if err != nil || target.Scheme == "" || target.Host == "" {
+				return cli.Exit(fmt.Sprintf("invalid target url '%s'", c.String("target")), 1)
+			}
+
+			transport, err := clientTransport(c)
+			if err != nil {
+				return err
+			}
+
+			r := &relay{
+				source:     c.String("source"),
+				target:     target,
+				cursorFile: c.String("cursor"),
+				deadLetter: c.String("dead-letter"),
+				retries:    c.Int("retries"),
+				backoff:    c.Duration("backoff"),
+				maxBackoff: c.Duration("max-backoff"),
+				gapWait:    c.Duration("gap-wait"),
+				client: &http.Client{
+					Transport: transport,
+					CheckRedirect: func(req *http.Request, via []*http.Request) error {
+						return http.ErrUseLastResponse
+					},
+				},
+			}
+			if r.cursorFile == "" {
+				r.cursorFile = strings.TrimSuffix(r.source, string(filepath.Separator)) + ".cursor"
+			}
+
+			cursor, err := r.readCursor()
+			if err != nil {
+				return err
+			}
+			if c.IsSet("from") {
+				cursor = &relayCursor{Seq: c.Int("from") - 1}
+			}
+
+			ctx, stop := signal.NotifyContext(c.Context, os.Interrupt)
+			defer stop()
+
+			return r.run(ctx, cursor, c.Duration("interval"), c.Bool("once"))
+		},
+	}
+}
+
+type relay struct {
+	source     string
+	target     *url.URL
+	client     *http.Client
+	cursorFile string
+	deadLetter string
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	gapWait    time.Duration
+
+	// the missing record number the cursor waits for
+	gap      int
+	gapSince time.Time
+}
+
+// relayCursor is the delivery state: every record up to Seq and the ones in
+// Delivered are done. Offset is where reading a journal source continues.
+type relayCursor struct {
+	Seq       int   `json:"seq"`
+	Delivered []int `json:"delivered,omitempty"`
+	Offset    int64 `json:"offset,omitempty"`
+}
+
+// deadLetterEntry is one line of errors.jsonl in the dead letter directory.
+type deadLetterEntry struct {
+	Seq   int    `json:"seq"`
+	File  string `json:"file"`
+	Time  string `json:"time"`
+	Error string `json:"error"`
+}
+
+func (r *relay) run(ctx context.Context, cursor *relayCursor, interval time.Duration, once bool) error {
+	log.Printf("Relaying records after #%04d from '%s' to '%s'", cursor.Seq, r.source, r.target)
+
+	delivered := make(map[int]bool)
+	for _, seq := range cursor.Delivered {
+		delivered[seq] = true
+	}
+	for {
+		entries, offset, err := readRecordSource(r.source, cursor.Seq, cursor.Offset)
+		if err != nil {
+			return err
+		}
+
+		// a record saved late is delivered when it shows up, records are
+		// numbered when they arrive but saved after their response
+		for _, entry := range entries {
+			if entry.Seq <= cursor.Seq || delivered[entry.Seq] {
+				continue
+			}
+			if err := r.deliver(ctx, entry); err != nil {
+				// only cancellation stops a delivery, the cursor stays
+				return nil
+			}
+			delivered[entry.Seq] = true
+			r.advance(cursor, delivered)
+			if err := r.writeCursor(cursor); err != nil {
+				return err
+			}
+		}
+		cursor.Offset = offset
+		r.advance(cursor, delivered)
+		if err := r.writeCursor(cursor); err != nil {
+			return err
+		}
+
+		if once {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// advance moves the cursor over the delivered records, a missing record
+// number is skipped once it was missing for gapWait.
+func (r *relay) advance(cursor *relayCursor, delivered map[int]bool) {
+	for len(delivered) > 0 {
+		next := cursor.Seq + 1
+		if delivered[next] {
+			delete(delivered, next)
+			cursor.Seq = next
+			continue
+		}
+
+		if r.gap != next {
+			r.gap, r.gapSince = next, time.Now()
+		}
+		if time.Since(r.gapSince) < r.gapWait {
+			break
+		}
+		log.Printf("#%04d did not show up in %s, skipped", next, r.gapWait)
+		cursor.Seq = next
+	}
+
+	cursor.Delivered = cursor.Delivered[:0]
+	for seq := range delivered {
+		cursor.Delivered = append(cursor.Delivered, seq)
+	}
+	sort.Ints(cursor.Delivered)
+}
+
+// deliver sends one record until the target accepts or finally rejects it.
+func (r *relay) deliver(ctx context.Context, entry *recordEntry) error {
+	backoff := r.backoff
+	failures := 0
+	for {
+		req, err := newRequest(entry.Record, r.target, entry.Dir)
+		if err != nil {
+			r.reject(entry, err.Error())
+			return nil
+		}
+
+		status, err := r.send(ctx, req)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		switch {
+		case err != nil:
+			log.Printf("#%04d %s %s: %v, retry in %s", entry.Seq, entry.Record.Method, entry.Record.URL, err, backoff)
+		case status < 400:
+			log.Printf("#%04d %s %s delivered: %d", entry.Seq, entry.Record.Method, entry.Record.URL, status)
+			return nil
+		case status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests:
+			r.reject(entry, fmt.Sprintf("rejected with status %d", status))
+			return nil
+		default:
+			failures++
+			if failures > r.retries {
+				r.reject(entry, fmt.Sprintf("failed with status %d after %d attempts", status, failures))
+				return nil
+			}
+			log.Printf("#%04d %s %s failed: %d, retry in %s", entry.Seq, entry.Record.Method, entry.Record.URL, status, backoff)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, r.maxBackoff)
+	}
+}
+
+func (r *relay) send(ctx context.Context, req *http.Request) (int, error) {
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// reject moves a record with its attachments to the dead letter directory.
+func (r *relay) reject(entry *recordEntry, reason string) {
+	log.Printf("#%04d %s %s %s", entry.Seq, entry.Record.Method, entry.Record.URL, reason)
+	if r.deadLetter == "" {
+		return
+	}
+
+	if err := r.saveDeadLetter(entry, reason); err != nil {
+		log.Printf("failed to save dead letter #%04d: %v", entry.Seq, err)
+	}
+}
+
+func (r *relay) saveDeadLetter(entry *recordEntry, reason string) error {
+	if err := os.MkdirAll(r.deadLetter, 0755); err != nil {
+		return err
+	}
+
+	if err := saveRecord(r.deadLetter, entry.File, entry.Record); err != nil {
+		return err
+	}
+	for _, name := range entry.files() {
+		data, err := os.ReadFile(filepath.Join(entry.Dir, name))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(r.deadLetter, name), data, 0644); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(&deadLetterEntry{
+		Seq:   entry.Seq,
+		File:  entry.File,
+		Time:  time.Now().Format(time.RFC3339),
+		Error: reason,
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(r.deadLetter, "errors.jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// readCursor reads the cursor file, a file holding just a number is the
+// cursor of an older version.
+func (r *relay) readCursor() (*relayCursor, error) {
+	data, err := os.ReadFile(r.cursorFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return &relayCursor{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cursor: %s", err)
+	}
+
+	cursor := &relayCursor{}
+	if seq, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+		cursor.Seq = seq
+		return cursor, nil
+	}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, fmt.Errorf("invalid cursor file %s: %s", r.cursorFile, err)
+	}
+	return cursor, nil
+}
+
+// writeCursor replaces the cursor file atomically.
+func (r *relay) writeCursor(cursor *relayCursor) error {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+	tmp := r.cursorFile + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write cursor: %s", err)
+	}
+	if err := os.Rename(tmp, r.cursorFile); err != nil {
+		return fmt.Errorf("failed to write cursor: %s", err)
+	}
+	return nil
+}
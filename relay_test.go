@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+type relayTarget struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func newRelayTarget(t *testing.T) (*relayTarget, *url.URL) {
+	rt := &relayTarget{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rt.mu.Lock()
+		rt.paths = append(rt.paths, r.URL.Path)
+		rt.mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	target, _ := url.Parse(server.URL)
+	return rt, target
+}
+
+func (rt *relayTarget) take() []string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	paths := rt.paths
+	rt.paths = nil
+	return paths
+}
+
+func newTestRelay(source string, target *url.URL, gapWait time.Duration) *relay {
+	return &relay{
+		source:     source,
+		target:     target,
+		client:     http.DefaultClient,
+		cursorFile: source + ".cursor",
+		backoff:    time.Millisecond,
+		maxBackoff: time.Millisecond,
+		gapWait:    gapWait,
+	}
+}
+
+func runRelayOnce(t *testing.T, r *relay) *relayCursor {
+	t.Helper()
+	cursor, err := r.readCursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.run(context.Background(), cursor, 0, true); err != nil {
+		t.Fatal(err)
+	}
+	if cursor, err = r.readCursor(); err != nil {
+		t.Fatal(err)
+	}
+	return cursor
+}
+
+func writeRelayRecord(t *testing.T, dir string, seq int) {
+	writeTestRecord(t, dir, fmt.Sprintf("%04d_r.json", seq), &Record{Method: "GET", URL: fmt.Sprintf("/%d", seq)})
+}
+
+func TestRelayLateRecord(t *testing.T) {
+	rt, target := newRelayTarget(t)
+	dir := filepath.Join(t.TempDir(), "records")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRelay(dir, target, time.Hour)
+
+	for _, seq := range []int{1, 2, 4} {
+		writeRelayRecord(t, dir, seq)
+	}
+	cursor := runRelayOnce(t, r)
+	if got := rt.take(); !slices.Equal(got, []string{"/1", "/2", "/4"}) {
+		t.Errorf("delivered %v", got)
+	}
+	if cursor.Seq != 2 || !slices.Equal(cursor.Delivered, []int{4}) {
+		t.Errorf("cursor = %+v, want seq 2 with 4 delivered", cursor)
+	}
+
+	// the slow request is saved after the later one
+	writeRelayRecord(t, dir, 3)
+	writeRelayRecord(t, dir, 5)
+	cursor = runRelayOnce(t, newTestRelay(dir, target, time.Hour))
+	if got := rt.take(); !slices.Equal(got, []string{"/3", "/5"}) {
+		t.Errorf("delivered %v", got)
+	}
+	if cursor.Seq != 5 || len(cursor.Delivered) != 0 {
+		t.Errorf("cursor = %+v, want seq 5", cursor)
+	}
+}
+
+func TestRelaySkipsGap(t *testing.T) {
+	_, target := newRelayTarget(t)
+	dir := t.TempDir()
+	writeRelayRecord(t, dir, 1)
+	writeRelayRecord(t, dir, 3)
+
+	cursor := runRelayOnce(t, newTestRelay(dir, target, 0))
+	if cursor.Seq != 3 || len(cursor.Delivered) != 0 {
+		t.Errorf("cursor = %+v, want the missing record skipped", cursor)
+	}
+}
+
+func TestRelayJournalOffset(t *testing.T) {
+	rt, target := newRelayTarget(t)
+	filename := filepath.Join(t.TempDir(), "records.jsonl")
+	line := func(seq int) string {
+		data, _ := json.Marshal(&journalEntry{Seq: seq, Record: &Record{Method: "GET", URL: fmt.Sprintf("/%d", seq)}})
+		return string(data) + "\n"
+	}
+	appendJournal := func(s string) {
+		f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	appendJournal(line(1) + line(2))
+	r := newTestRelay(filename, target, time.Hour)
+	cursor := runRelayOnce(t, r)
+	size := int64(len(line(1) + line(2)))
+	if cursor.Seq != 2 || cursor.Offset != size {
+		t.Errorf("cursor = %+v, want seq 2 at offset %d", cursor, size)
+	}
+
+	// a line still being written is read on the next poll
+	partial := line(3)
+	appendJournal(partial[:10])
+	cursor = runRelayOnce(t, r)
+	if cursor.Offset != size {
+		t.Errorf("offset = %d, want %d", cursor.Offset, size)
+	}
+	appendJournal(partial[10:])
+	cursor = runRelayOnce(t, r)
+	if got := rt.take(); !slices.Equal(got, []string{"/1", "/2", "/3"}) {
+		t.Errorf("delivered %v", got)
+	}
+	if cursor.Seq != 3 {
+		t.Errorf("cursor = %+v, want seq 3", cursor)
+	}
+}
+
+func TestRelayCursorFile(t *testing.T) {
+	r := &relay{cursorFile: filepath.Join(t.TempDir(), "cursor")}
+
+	cursor, err := r.readCursor()
+	if err != nil || cursor.Seq != 0 {
+		t.Fatalf("missing cursor = %+v, %v", cursor, err)
+	}
+
+	// written by older versions
+	if err := os.WriteFile(r.cursorFile, []byte("12\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cursor, err = r.readCursor(); err != nil || cursor.Seq != 12 {
+		t.Errorf("plain cursor = %+v, %v", cursor, err)
+	}
+
+	want := &relayCursor{Seq: 3, Delivered: []int{5, 6}, Offset: 100}
+	if err := r.writeCursor(want); err != nil {
+		t.Fatal(err)
+	}
+	if cursor, err = r.readCursor(); err != nil || cursor.Seq != 3 || !slices.Equal(cursor.Delivered, want.Delivered) || cursor.Offset != 100 {
+		t.Errorf("cursor = %+v, %v", cursor, err)
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// replayer sends records to the target of the req command.
+type replayer struct {
+	uri       *url.URL
+	transport *http.Transport
+	client    *http.Client
+	basic     string
+	bearer    string
+	ordered   bool
+	vars      *variables
+	check     *responseCheck
+
+	// --save and --update
+	saveDir string
+	update  bool
+	mu      sync.Mutex
+	seq     int
+}
+
+func newReplayer(c *cli.Context) (*replayer, error) {
+	vars, err := loadVariables(c.StringSlice("env"), c.StringSlice("var"), c.Bool("expand"))
+	if err != nil {
+		return nil, err
+	}
+	if server := vars.expand(c.String("server"), make(builtins), nil); server != c.String("server") {
+		if err := c.Set("server", server); err != nil {
+			return nil, err
+		}
+	}
+
+	uri, err := parseUri(c)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := clientTransport(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var check *responseCheck
+	if c.Bool("check") {
+		if check, err = newResponseCheck(c); err != nil {
+			return nil, err
+		}
+	}
+
+	rp := &replayer{
+		uri:       uri,
+		transport: transport,
+		client:    &http.Client{Transport: transport},
+		basic:     c.String("basic"),
+		bearer:    c.String("bearer"),
+		ordered:   c.Bool("ordered-headers"),
+		vars:      vars,
+		check:     check,
+		saveDir:   c.String("save"),
+		update:    c.Bool("update"),
+	}
+	if rp.saveDir != "" {
+		if rp.seq, err = lastRecordSeq(rp.saveDir); err != nil {
+			return nil, err
+		}
+	}
+	return rp, nil
+}
+
+// lastRecordSeq creates dir if needed and returns the highest record number
+// in it.
+func lastRecordSeq(dir string) (int, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create directory %s: %v", dir, err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open directory %s: %v", dir, err)
+	}
+
+	last := 0
+	for _, f := range files {
+		if seq, ok := fileSeq(f.Name()); ok && filepath.Ext(f.Name()) == ".json" {
+			last = max(last, seq)
+		}
+	}
+	return last, nil
+}
+
+// keeping reports whether the exchanges are saved and need the sent body.
+func (rp *replayer) keeping() bool {
+	return rp.saveDir != "" || rp.update
+}
+
+func (rp *replayer) close() {
+	rp.transport.CloseIdleConnections()
+}
+
+func (rp *replayer) newRequest(entry *recordEntry) (*http.Request, error) {
+	req, err := newRequest(rp.vars.expandRecord(entry.Record), rp.uri, entry.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if rp.basic != "" {
+		req.Header.Set("Authorization",
+			"Basic "+base64.StdEncoding.EncodeToString([]byte(rp.basic)))
+	}
+	if rp.bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+rp.bearer)
+	}
+	return req, nil
+}
+
+// do sends the record, the request body is returned as well if the exchange
+// is kept.
+func (rp *replayer) do(ctx context.Context, entry *recordEntry) (*http.Response, []byte, error) {
+	req, err := rp.newRequest(entry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sent []byte
+	if rp.keeping() && req.Body != nil {
+		sent, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read request body: %s", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(sent))
+		req.ContentLength = int64(len(sent))
+	}
+
+	client := rp.client
+	if rp.ordered && entry.Record.Request != nil {
+		client = &http.Client{
+			Transport: &orderedTransport{base: rp.transport, order: entry.Record.Request.Header},
+		}
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	return resp, sent, nil
+}
+
+// keep saves the exchange as a new record in --save and replaces the
+// response of the source record with --update. The bodies are classified
+// the way the server does.
+func (rp *replayer) keep(entry *recordEntry, resp *http.Response, sent, body []byte, start time.Time) error {
+	var respHeader Header
+	respHeader.FromHttpHeader(resp.Header)
+
+	if rp.saveDir != "" {
+		req := resp.Request
+		rp.mu.Lock()
+		rp.seq++
+		seq := rp.seq
+		rp.mu.Unlock()
+
+		filename := recordFilename(seq, start, req.Method, req.URL.Path)
+		basename := strings.TrimSuffix(filename, ".json")
+		record := &Record{
+			Method:   req.Method,
+			URL:      req.URL.RequestURI(),
+			Time:     start.Format(time.RFC3339Nano),
+			Protocol: resp.Proto,
+			Request:  &RequestResponse{},
+			Response: &RequestResponse{Status: resp.StatusCode},
+		}
+
+		var header Header
+		header.FromHttpHeader(req.Header)
+		if err := captureBody(record.Request, header, sent, rp.saveDir, basename); err != nil {
+			return fmt.Errorf("failed to save request body: %s", err)
+		}
+		if err := captureBody(record.Response, slices.Clone(respHeader), body, rp.saveDir, basename+"-response"); err != nil {
+			return fmt.Errorf("failed to save response body: %s", err)
+		}
+		if err := saveRecord(rp.saveDir, filename, record); err != nil {
+			return fmt.Errorf("failed to save record: %s", err)
+		}
+		log.Printf("Saved #%04d to '%s'", seq, filepath.Join(rp.saveDir, filename))
+	}
+
+	if rp.update {
+		if entry.File == "" {
+			return fmt.Errorf("record #%04d has no file to update", entry.Seq)
+		}
+		record := *entry.Record
+		record.Response = &RequestResponse{Status: resp.StatusCode}
+		basename := strings.TrimSuffix(entry.File, ".json")
+		if err := captureBody(record.Response, respHeader, body, entry.Dir, basename+"-response"); err != nil {
+			return fmt.Errorf("failed to save response body: %s", err)
+		}
+		if err := saveRecord(entry.Dir, entry.File, &record); err != nil {
+			return fmt.Errorf("failed to update record: %s", err)
+		}
+		// a body of another type is saved under another name
+		for _, name := range bodyFiles(entry.Record.Response) {
+			if slices.Contains(bodyFiles(record.Response), name) {
+				continue
+			}
+			if err := os.Remove(filepath.Join(entry.Dir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("failed to remove old response file: %v", err)
+			}
+		}
+		log.Printf("Updated the response of '%s'", filepath.Join(entry.Dir, entry.File))
+	}
+	return nil
+}
+
+// batchResult is the outcome of one record in a batch, a record never sent
+// because the batch stopped has no result.
+type batchResult struct {
+	status   int
+	duration time.Duration
+	err      error
+
+	// timed replays only, how late the request was sent
+	timed bool
+	drift time.Duration
+
+	// with --check the differences to the recorded response
+	checked    bool
+	mismatches int
+}
+
+// failed reports whether the record stops a batch without --keep-going.
+func (r *batchResult) failed() bool {
+	if r.checked {
+		return r.err != nil || r.mismatches > 0
+	}
+	return r.err != nil || r.status >= 500
+}
+
+type batchOptions struct {
+	concurrency int
+	keepGoing   bool
+
+	// send offsets of the entries from the start, nil sends them at once
+	schedule []time.Duration
+}
+
+// replayAll sends the entries in order with up to concurrency requests in
+// flight, a concurrency of 0 does not limit them. Entries of a schedule are
+// handed out when they are due. Unless keepGoing is set no more records are
+// sent after a failure.
+func (rp *replayer) replayAll(ctx context.Context, entries []*recordEntry, opts *batchOptions) []*batchResult {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type job struct {
+		i  int
+		at time.Time
+	}
+	results := make([]*batchResult, len(entries))
+	run := func(j job) {
+		res := rp.replay(ctx, entries[j.i], j.at)
+		results[j.i] = res
+		if res.failed() && !opts.keepGoing {
+			cancel()
+		}
+	}
+
+	jobs := make(chan job)
+	wg := &sync.WaitGroup{}
+	for range max(opts.concurrency, 0) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				run(j)
+			}
+		}()
+	}
+
+	begin := time.Now()
+dispatch:
+	for i := range entries {
+		j := job{i: i}
+		if opts.schedule != nil {
+			j.at = begin.Add(opts.schedule[i])
+			timer := time.NewTimer(time.Until(j.at))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				break dispatch
+			case <-timer.C:
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		if opts.concurrency <= 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				run(j)
+			}()
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case jobs <- j:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// replay sends one record of a batch, at is when it should have been sent
+// for timed replays.
+func (rp *replayer) replay(ctx context.Context, entry *recordEntry, at time.Time) *batchResult {
+	record := entry.Record
+	start := time.Now()
+	res := &batchResult{}
+	drift := ""
+	if !at.IsZero() {
+		res.timed = true
+		res.drift = start.Sub(at)
+		drift = fmt.Sprintf(" (drift %s)", res.drift.Round(time.Microsecond))
+	}
+
+	resp, sent, err := rp.do(ctx, entry)
+	var body []byte
+	if err == nil {
+		if rp.check != nil || rp.keeping() {
+			body, err = io.ReadAll(resp.Body)
+		} else {
+			_, err = io.Copy(io.Discard, resp.Body)
+		}
+		resp.Body.Close()
+		res.status = resp.StatusCode
+	}
+	res.duration = time.Since(start)
+	if err == nil && rp.keeping() {
+		err = rp.keep(entry, resp, sent, body, start)
+	}
+	if err == nil && rp.check != nil {
+		var mismatches []*mismatch
+		if mismatches, err = rp.check.compare(entry, resp, body); err == nil {
+			res.checked = true
+			res.mismatches = len(mismatches)
+			os.Stdout.WriteString(rp.check.report(entry, mismatches))
+		}
+	}
+	res.err = err
+
+	if err != nil {
+		log.Printf("#%04d %s %s: %v%s", entry.Seq, record.Method, record.URL, err, drift)
+	} else {
+		log.Printf("#%04d %s %s: %s %s%s", entry.Seq, record.Method, record.URL, resp.Status, res.duration.Round(time.Microsecond), drift)
+	}
+	return res
+}
+
+// printReplaySummary prints the count and durations of the results by status.
+func printReplaySummary(w io.Writer, results []*batchResult, elapsed time.Duration) {
+	type row struct {
+		count                   int
+		total, fastest, slowest time.Duration
+	}
+	rows := make(map[string]*row)
+	skipped := 0
+	checked, differ := 0, 0
+	timed := 0
+	var totalDrift, maxDrift time.Duration
+	for _, res := range results {
+		if res == nil {
+			skipped++
+			continue
+		}
+		if res.checked {
+			checked++
+			if res.mismatches > 0 {
+				differ++
+			}
+		}
+		if res.timed {
+			timed++
+			totalDrift += res.drift
+			maxDrift = max(maxDrift, res.drift)
+		}
+		key := "error"
+		if res.err == nil {
+			key = strconv.Itoa(res.status)
+		}
+		r, ok := rows[key]
+		if !ok {
+			r = &row{fastest: res.duration}
+			rows[key] = r
+		}
+		r.count++
+		r.total += res.duration
+		r.fastest = min(r.fastest, res.duration)
+		r.slowest = max(r.slowest, res.duration)
+	}
+
+	keys := make([]string, 0, len(rows))
+	for k := range rows {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tCOUNT\tMIN\tAVG\tMAX")
+	for _, k := range keys {
+		r := rows[k]
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", k, r.count,
+			r.fastest.Round(time.Microsecond),
+			(r.total / time.Duration(r.count)).Round(time.Microsecond),
+			r.slowest.Round(time.Microsecond))
+	}
+	if skipped > 0 {
+		fmt.Fprintf(tw, "skipped\t%d\t\t\t\n", skipped)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d records in %s\n", len(results), elapsed.Round(time.Millisecond))
+	if checked > 0 {
+		fmt.Fprintf(w, "%d of %d responses differ from the records\n", differ, checked)
+	}
+	if timed > 0 {
+		fmt.Fprintf(w, "schedule drift: avg %s, max %s\n",
+			(totalDrift / time.Duration(timed)).Round(time.Microsecond),
+			maxDrift.Round(time.Microsecond))
+	}
+}
+
+// replaySchedule keeps the gaps between the times the entries were received,
+// divided by speed and capped at maxGap. Entries without a valid time, or
+// received before the latest one so far, follow the previous one at once.
+func replaySchedule(entries []*recordEntry, speed float64, maxGap time.Duration) []time.Duration {
+	schedule := make([]time.Duration, len(entries))
+	var offset time.Duration
+	var last time.Time
+	for i, entry := range entries {
+		t, err := time.Parse(time.RFC3339Nano, entry.Record.Time)
+		// an entry received before an earlier one goes right after it, the
+		// next gap is measured from the latest time
+		if err == nil && t.After(last) {
+			if !last.IsZero() {
+				gap := time.Duration(float64(t.Sub(last)) / speed)
+				if maxGap > 0 {
+					gap = min(gap, maxGap)
+				}
+				offset += gap
+			}
+			last = t
+		}
+		schedule[i] = offset
+	}
+	return schedule
+}
+
+// parseSpeed parses speed multipliers like '2', '0.5x' or '10x'.
+func parseSpeed(s string) (float64, error) {
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "x"), 64)
+	if err != nil || speed <= 0 || math.IsInf(speed, 0) {
+		return 0, fmt.Errorf("invalid speed '%s', expect a positive multiplier like '2x'", s)
+	}
+	return speed, nil
+}
+
+// replayBatch is the req command for more than one record.
+func replayBatch(c *cli.Context, rp *replayer, entries []*recordEntry) error {
+	opts := &batchOptions{
+		concurrency: max(c.Int("concurrency"), 1),
+		keepGoing:   c.Bool("keep-going"),
+	}
+	if c.Bool("timing") {
+		speed, err := parseSpeed(c.String("speed"))
+		if err != nil {
+			return err
+		}
+		opts.schedule = replaySchedule(entries, speed, c.Duration("max-gap"))
+		// a slow response must not hold back the next request
+		if !c.IsSet("concurrency") {
+			opts.concurrency = 0
+		}
+	}
+
+	start := time.Now()
+	results := rp.replayAll(c.Context, entries, opts)
+	printReplaySummary(os.Stdout, results, time.Since(start))
+
+	for _, res := range results {
+		if res == nil || res.failed() {
+			return cli.Exit("", 1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplaySchedule(t *testing.T) {
+	entry := func(tm string) *recordEntry {
+		return &recordEntry{Record: &Record{Time: tm}}
+	}
+	entries := []*recordEntry{
+		entry("2024-01-01T00:00:00Z"),
+		entry("2024-01-01T00:00:02Z"),
+		// saved late, received before the previous one
+		entry("2024-01-01T00:00:01Z"),
+		entry("2024-01-01T00:00:03Z"),
+		entry("invalid"),
+		entry("2024-01-01T00:01:03Z"),
+	}
+
+	tests := []struct {
+		speed  float64
+		maxGap time.Duration
+		want   []time.Duration
+	}{
+		{1, 0, []time.Duration{0, 2 * time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second, 63 * time.Second}},
+		{2, 0, []time.Duration{0, time.Second, time.Second, 1500 * time.Millisecond, 1500 * time.Millisecond, 31500 * time.Millisecond}},
+		{1, 5 * time.Second, []time.Duration{0, 2 * time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second, 8 * time.Second}},
+	}
+	for _, tt := range tests {
+		if got := replaySchedule(entries, tt.speed, tt.maxGap); !slices.Equal(got, tt.want) {
+			t.Errorf("speed %v max gap %s: schedule = %v, want %v", tt.speed, tt.maxGap, got, tt.want)
+		}
+	}
+}
+
+func TestReplayAllTimed(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		order = append(order, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	uri, _ := url.Parse(server.URL)
+	transport := &http.Transport{}
+	rp := &replayer{uri: uri, transport: transport, client: &http.Client{Transport: transport}, vars: &variables{}}
+	defer rp.close()
+
+	entries := []*recordEntry{
+		{Seq: 1, Record: &Record{Method: "GET", URL: "/slow"}},
+		{Seq: 2, Record: &Record{Method: "GET", URL: "/b"}},
+		{Seq: 3, Record: &Record{Method: "GET", URL: "/c"}},
+	}
+	opts := &batchOptions{schedule: []time.Duration{0, 20 * time.Millisecond, 40 * time.Millisecond}}
+
+	start := time.Now()
+	results := rp.replayAll(context.Background(), entries, opts)
+	elapsed := time.Since(start)
+
+	for i, res := range results {
+		if res == nil || res.failed() {
+			t.Fatalf("result %d = %+v", i, res)
+		}
+		if res.drift > 20*time.Millisecond {
+			t.Errorf("result %d drift %s, the slow response held it back", i, res.drift)
+		}
+	}
+	if want := []string{"/slow", "/b", "/c"}; !slices.Equal(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+	if elapsed < 40*time.Millisecond {
+		t.Errorf("replay took %s, the schedule is not kept", elapsed)
+	}
+}
+
+func TestReplayKeep(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	saveDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "0001-response.bin"), []byte{0, 1, 2}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := &Record{
+		Method:   "GET",
+		URL:      "/items?page=2",
+		Response: &RequestResponse{Status: 200, BodyFile: "0001-response.bin"},
+	}
+	writeTestRecord(t, dir, "0001.json", old)
+
+	uri, _ := url.Parse(server.URL)
+	transport := &http.Transport{}
+	rp := &replayer{uri: uri, transport: transport, client: &http.Client{Transport: transport}, vars: &variables{}, saveDir: saveDir, update: true}
+	defer rp.close()
+
+	entry := &recordEntry{Seq: 1, Dir: dir, File: "0001.json", Record: old}
+	if res := rp.replay(context.Background(), entry, time.Time{}); res.failed() {
+		t.Fatalf("replay failed: %+v", res)
+	}
+
+	var updated Record
+	if err := loadRecord(filepath.Join(dir, "0001.json"), &updated); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "0001-response.bin")); !os.IsNotExist(err) {
+		t.Errorf("old response body kept: %v", err)
+	}
+	for _, name := range bodyFiles(updated.Response) {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("new response body: %v", err)
+		}
+	}
+
+	saved, err := filepath.Glob(filepath.Join(saveDir, "*.json"))
+	if err != nil || len(saved) != 1 {
+		t.Fatalf("saved records = %v, %v", saved, err)
+	}
+	var record Record
+	if err := loadRecord(saved[0], &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.URL != "/items?page=2" {
+		t.Errorf("saved url = %q, want the request uri", record.URL)
+	}
+}
+
+func TestReplayOrderedHeaders(t *testing.T) {
+	rawServer := func(handler http.Handler) *httptest.Server {
+		server := httptest.NewUnstartedServer(handler)
+		server.Listener = rawHeaderListener{server.Listener}
+		server.Config.ConnContext = rawHeaderContext
+		server.Start()
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	// record a request sent with a lowercase host first and a chunked body
+	rec, dir := newTestRecorder(t, 0)
+	recording := rawServer(rec)
+	conn, err := net.Dial("tcp", recording.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	head := "POST /items HTTP/1.1\r\nhost: example.com\r\nX-B: 2\r\ntransfer-encoding: chunked\r\ncontent-type: text/plain\r\nx-a: 1\r\n\r\n"
+	if _, err := conn.Write([]byte(head + "5\r\nhello\r\n0\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	saved, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(saved) != 1 {
+		t.Fatalf("saved records = %v, %v", saved, err)
+	}
+	var record Record
+	if err := loadRecord(saved[0], &record); err != nil {
+		t.Fatal(err)
+	}
+
+	var got Header
+	var body []byte
+	var chunked bool
+	target := rawServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = rawHeader(r)
+		body, _ = io.ReadAll(r.Body)
+		chunked = slices.Equal(r.TransferEncoding, []string{"chunked"})
+	}))
+
+	uri, _ := url.Parse(target.URL)
+	transport := &http.Transport{}
+	rp := &replayer{uri: uri, transport: transport, client: &http.Client{Transport: transport}, vars: &variables{}, ordered: true}
+	defer rp.close()
+	entry := &recordEntry{Seq: 1, Dir: filepath.Dir(saved[0]), File: filepath.Base(saved[0]), Record: &record}
+	if res := rp.replay(context.Background(), entry, time.Time{}); res.failed() {
+		t.Fatalf("replay failed: %+v", res)
+	}
+
+	want := Header{
+		{Name: "host", Value: uri.Host},
+		{Name: "X-B", Value: "2"},
+		{Name: "transfer-encoding", Value: "chunked"},
+		{Name: "content-type", Value: "text/plain"},
+		{Name: "x-a", Value: "1"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("replayed header = %v, want %v", got, want)
+	}
+	if string(body) != "hello" || !chunked {
+		t.Errorf("replayed body = %q, chunked %v", body, chunked)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// responderConfig describes how the server answers captured requests, it can
+// be replaced at runtime through the admin api.
+type responderConfig struct {
+	Status   int         `json:"status,omitempty"`
+	Body     string      `json:"body,omitempty"`
+	Proxy    string      `json:"proxy,omitempty"`
+	Tunnel   bool        `json:"tunnel,omitempty"`
+	WWWRoot  string      `json:"wwwroot,omitempty"`
+	Playback string      `json:"playback,omitempty"`
+	Match    []string    `json:"match,omitempty"`
+	OnMiss   string      `json:"on_miss,omitempty"`
+	Scenario string      `json:"scenario,omitempty"`
+	Routes   []*scenario `json:"routes,omitempty"`
+}
+
+type responder struct {
+	config    *responderConfig
+	handler   http.HandlerFunc
+	playback  *playback
+	scenarios *scenarioSet
+}
+
+func newResponder(cfg *responderConfig) (*responder, error) {
+	res := &responder{config: cfg}
+
+	var err error
+	if cfg.Playback != "" {
+		onMiss := cfg.OnMiss
+		if onMiss == "" {
+			onMiss = "404"
+		}
+		res.playback, err = newPlayback(cfg.Playback, cfg.Match, onMiss, cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		res.handler = res.playback.ServeHTTP
+	} else if cfg.Proxy != "" {
+		res.handler, err = proxyResponse(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+	} else if cfg.Tunnel {
+		res.handler = tunnelResponse()
+	} else if cfg.WWWRoot != "" {
+		res.handler, err = staticResponse(cfg.WWWRoot)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		status := cfg.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status < 100 || status > 999 {
+			return nil, fmt.Errorf("invalid status code %d", status)
+		}
+		res.handler = simpleResponse(status, cfg.Body)
+	}
+
+	if cfg.Scenario != "" {
+		res.scenarios, err = loadScenarios(cfg.Scenario)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.Routes) > 0 {
+		// the routes keep their state, copy them so the config stays untouched
+		var routes []*scenario
+		data, _ := json.Marshal(cfg.Routes)
+		if err := json.Unmarshal(data, &routes); err != nil {
+			return nil, err
+		}
+
+		if res.scenarios == nil {
+			res.scenarios = &scenarioSet{}
+		}
+		if err := res.scenarios.add(routes...); err != nil {
+			return nil, err
+		}
+	}
+	if res.scenarios != nil {
+		res.handler = scenarioResponse(res.scenarios, res.handler)
+	}
+
+	return res, nil
+}
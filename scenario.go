@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// scenario answers a route with an ordered list of responses, the position in
+// the list is tracked per key, so retry and polling flows can be simulated.
+type scenario struct {
+	Name      string             `json:"name"`
+	Method    string             `json:"method,omitempty"`
+	Path      string             `json:"path"`
+	Key       string             `json:"key,omitempty"`
+	OnEnd     string             `json:"on_end,omitempty"`
+	Responses []*RequestResponse `json:"responses"`
+
+	// body files of the responses are relative to dir
+	dir string
+
+	mu    sync.Mutex
+	state map[string]int
+}
+
+type scenarioSet struct {
+	scenarios []*scenario
+}
+
+func loadScenarios(filename string) (*scenarioSet, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open scenario file: %s", err)
+	}
+	defer f.Close()
+
+	var scenarios []*scenario
+	if err := json.NewDecoder(f).Decode(&scenarios); err != nil {
+		return nil, fmt.Errorf("failed to decode scenario file: %s", err)
+	}
+	for _, s := range scenarios {
+		s.dir = filepath.Dir(filename)
+	}
+
+	set := &scenarioSet{}
+	if err := set.add(scenarios...); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+func (set *scenarioSet) add(scenarios ...*scenario) error {
+	for _, s := range scenarios {
+		if s.Name == "" {
+			s.Name = fmt.Sprintf("scenario_%d", len(set.scenarios))
+		}
+		if err := s.validate(); err != nil {
+			return err
+		}
+		set.scenarios = append(set.scenarios, s)
+	}
+	return nil
+}
+
+func (s *scenario) validate() error {
+	if s.Path == "" {
+		return fmt.Errorf("scenario '%s': path is required", s.Name)
+	}
+	if len(s.Responses) == 0 {
+		return fmt.Errorf("scenario '%s': at least one response is required", s.Name)
+	}
+
+	switch s.OnEnd {
+	case "":
+		s.OnEnd = "stick"
+	case "cycle", "stick", "reset":
+	default:
+		return fmt.Errorf("scenario '%s': unknown on_end '%s', expect 'cycle', 'stick' or 'reset'", s.Name, s.OnEnd)
+	}
+
+	switch {
+	case s.Key == "":
+		s.Key = "global"
+	case s.Key == "global" || s.Key == "ip":
+	case strings.HasPrefix(s.Key, "header:"):
+	default:
+		return fmt.Errorf("scenario '%s': unknown key '%s', expect 'global', 'ip' or 'header:<name>'", s.Name, s.Key)
+	}
+
+	return nil
+}
+
+func (s *scenario) match(r *http.Request) bool {
+	if s.Method != "" && !strings.EqualFold(s.Method, r.Method) {
+		return false
+	}
+	return matchPath(s.Path, r.URL.Path)
+}
+
+func (s *scenario) stateKey(r *http.Request) string {
+	switch {
+	case s.Key == "ip":
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	case strings.HasPrefix(s.Key, "header:"):
+		return r.Header.Get(strings.TrimPrefix(s.Key, "header:"))
+	default:
+		return ""
+	}
+}
+
+// next returns the response for this request and advances the sequence,
+// nil means the sequence is exhausted and waits for a reset.
+func (s *scenario) next(r *http.Request) *RequestResponse {
+	key := s.stateKey(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == nil {
+		s.state = make(map[string]int)
+	}
+	n := s.state[key]
+
+	var idx int
+	switch s.OnEnd {
+	case "cycle":
+		idx = n % len(s.Responses)
+	case "reset":
+		if n >= len(s.Responses) {
+			return nil
+		}
+		idx = n
+	default:
+		idx = min(n, len(s.Responses)-1)
+	}
+
+	s.state[key] = n + 1
+	return s.Responses[idx]
+}
+
+func (s *scenario) reset() {
+	s.mu.Lock()
+	s.state = nil
+	s.mu.Unlock()
+}
+
+// Reset rewinds the named scenario, or all of them if name is empty.
+func (set *scenarioSet) Reset(name string) bool {
+	found := false
+	for _, s := range set.scenarios {
+		if name == "" || s.Name == name {
+			s.reset()
+			found = true
+		}
+	}
+	return found
+}
+
+func scenarioResponse(set *scenarioSet, next http.HandlerFunc) http.HandlerFunc {
+	log.Printf("Scenario routes: %d", len(set.scenarios))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, s := range set.scenarios {
+			if !s.match(r) {
+				continue
+			}
+
+			rr := s.next(r)
+			if rr == nil {
+				continue
+			}
+			if ex := exchangeFromContext(r.Context()); ex != nil {
+				ex.route = s.Name
+			}
+			if err := writeRecordedResponse(w, rr, s.dir); err != nil {
+				log.Printf("failed to write scenario '%s' response: %v", s.Name, err)
+			}
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testScenario(onEnd, key string, bodies ...string) *scenario {
+	s := &scenario{Name: "poll", Method: "GET", Path: "/jobs/*", OnEnd: onEnd, Key: key}
+	for _, body := range bodies {
+		s.Responses = append(s.Responses, &RequestResponse{Status: 200, Body: body})
+	}
+	return s
+}
+
+func nextBodies(s *scenario, n int, r *http.Request) []string {
+	var bodies []string
+	for i := 0; i < n; i++ {
+		rr := s.next(r)
+		if rr == nil {
+			bodies = append(bodies, "<end>")
+		} else {
+			bodies = append(bodies, rr.Body)
+		}
+	}
+	return bodies
+}
+
+func TestScenarioSequence(t *testing.T) {
+	tests := []struct {
+		onEnd string
+		want  string
+	}{
+		{"", "pending running done done done"},
+		{"stick", "pending running done done done"},
+		{"cycle", "pending running done pending running"},
+		{"reset", "pending running done <end> <end>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.onEnd, func(t *testing.T) {
+			set := &scenarioSet{}
+			if err := set.add(testScenario(tt.onEnd, "", "pending", "running", "done")); err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("GET", "/jobs/1", nil)
+			got := strings.Join(nextBodies(set.scenarios[0], 5, r), " ")
+			if got != tt.want {
+				t.Errorf("responses = %s, want %s", got, tt.want)
+			}
+
+			if !set.Reset("poll") {
+				t.Fatal("Reset did not find the scenario")
+			}
+			if got := set.scenarios[0].next(r).Body; got != "pending" {
+				t.Errorf("after reset = %s, want pending", got)
+			}
+		})
+	}
+}
+
+func TestScenarioStateKey(t *testing.T) {
+	s := testScenario("stick", "header:X-Client", "first", "second")
+	if err := s.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	a := httptest.NewRequest("GET", "/jobs/1", nil)
+	a.Header.Set("X-Client", "a")
+	b := httptest.NewRequest("GET", "/jobs/1", nil)
+	b.Header.Set("X-Client", "b")
+
+	got := []string{s.next(a).Body, s.next(a).Body, s.next(b).Body}
+	if strings.Join(got, " ") != "first second first" {
+		t.Errorf("responses = %v, want every client to start at the first response", got)
+	}
+
+	s = testScenario("stick", "ip", "first", "second")
+	if err := s.validate(); err != nil {
+		t.Fatal(err)
+	}
+	a = httptest.NewRequest("GET", "/jobs/1", nil)
+	a.RemoteAddr = "10.0.0.1:1000"
+	a2 := httptest.NewRequest("GET", "/jobs/1", nil)
+	a2.RemoteAddr = "10.0.0.1:2000"
+	if s.next(a).Body != "first" || s.next(a2).Body != "second" {
+		t.Error("requests of one ip on different ports do not share the state")
+	}
+}
+
+func TestScenarioValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		s    *scenario
+	}{
+		{"no path", &scenario{Name: "a", Responses: []*RequestResponse{{}}}},
+		{"no responses", &scenario{Name: "a", Path: "/"}},
+		{"on_end", &scenario{Name: "a", Path: "/", OnEnd: "loop", Responses: []*RequestResponse{{}}}},
+		{"key", &scenario{Name: "a", Path: "/", Key: "cookie:a", Responses: []*RequestResponse{{}}}},
+	}
+	for _, tt := range tests {
+		if err := tt.s.validate(); err == nil {
+			t.Errorf("%s: invalid scenario is accepted", tt.name)
+		}
+	}
+}
+
+func TestScenarioResponse(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "scenario.json")
+	data := `[{"name": "retry", "method": "POST", "path": "/hook", "on_end": "reset",
+		"responses": [{"status": 503, "body": "busy"}, {"status": 200, "body": "ok"}]}]`
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := loadScenarios(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := scenarioResponse(set, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	var got []int
+	for _, target := range []string{"/hook", "/hook", "/hook", "/other"} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", target, nil))
+		got = append(got, w.Code)
+	}
+	// the exhausted sequence and unmatched paths fall through
+	want := []int{503, 200, 418, 418}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("statuses = %v, want %v", got, want)
+		}
+	}
+}
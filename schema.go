@@ -3,17 +3,23 @@ package main
 import "encoding/json"
 
 type Record struct {
-	Method   string           `json:"method"`
-	URL      string           `json:"url"`
-	Time     string           `json:"time"`
-	Protocol string           `json:"protocol"`
-	Request  *RequestResponse `json:"request,omitempty"`
-	Response *RequestResponse `json:"response,omitempty"`
+	Method       string           `json:"method"`
+	URL          string           `json:"url"`
+	Time         string           `json:"time"`
+	Protocol     string           `json:"protocol"`
+	Listener     string           `json:"listener,omitempty"`
+	TLS          *TLSInfo         `json:"tls,omitempty"`
+	Verification *Verification    `json:"verification,omitempty"`
+	TeeFile      string           `json:"tee_file,omitempty"`
+	Request      *RequestResponse `json:"request,omitempty"`
+	Response     *RequestResponse `json:"response,omitempty"`
 }
 
 type RequestResponse struct {
+	Status                  int             `json:"status,omitempty"`
 	Header                  Header          `json:"header"`
 	OriginalContentEncoding string          `json:"original_content_encoding,omitempty"`
+	Truncated               bool            `json:"truncated,omitempty"`
 	Body                    string          `json:"body,omitempty"`
 	BodyFile                string          `json:"body_file,omitempty"`
 	BodyJson                json.RawMessage `json:"body_json,omitempty"`
@@ -26,3 +32,10 @@ type MultiPart struct {
 	ContentFile string          `json:"content_file,omitempty"`
 	ContentJson json.RawMessage `json:"content_json,omitempty"`
 }
+
+func (r *Record) status() int {
+	if r.Response == nil {
+		return 0
+	}
+	return r.Response.Status
+}
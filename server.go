@@ -1,6 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,10 +13,14 @@ import (
 	"io"
 	"log"
 	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/http/httputil"
+	"net/textproto"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -24,12 +33,32 @@ func serverCmd() *cli.Command {
 		Name:  "server",
 		Usage: "Start server",
 		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "config",
+				Usage: "YAML config file with every setting of the flags plus filters and redaction, reloaded on change",
+			},
 			&cli.StringFlag{
 				Name:    "listen",
 				Aliases: []string{"l"},
-				Usage:   "Listen address, default is ':8080' or ':443' for https",
+				Usage:   "Listen address, default is ':8080' or ':443' for https, also 'unix:<path>' or 'systemd[:<name>]' for socket activation",
 				Value:   "",
 			},
+			&cli.StringFlag{
+				Name:  "client-ca",
+				Usage: "CA file to verify client certificates, enables '--client-auth verify' by default",
+			},
+			&cli.StringFlag{
+				Name:  "client-auth",
+				Usage: "Client certificate policy: 'none', 'request', 'require' (any certificate) or 'verify'",
+			},
+			&cli.StringFlag{
+				Name:  "socket-mode",
+				Usage: "File mode of the unix socket, e.g. '0660'",
+			},
+			&cli.BoolFlag{
+				Name:  "raw-headers",
+				Usage: "Record request headers in the order and casing they were sent, disables HTTP/2",
+			},
 			&cli.BoolFlag{
 				Name:     "https",
 				Aliases:  []string{"H"},
@@ -62,6 +91,12 @@ func serverCmd() *cli.Command {
 				Value:    0,
 				Category: "save request file",
 			},
+			&cli.Int64Flag{
+				Name:     "max-body",
+				Usage:    "Largest request body in bytes, larger requests are answered with 413, responses are recorded up to this size",
+				Value:    32 << 20,
+				Category: "save request file",
+			},
 			&cli.IntFlag{
 				Name:     "status",
 				Aliases:  []string{"S"},
@@ -82,52 +117,188 @@ func serverCmd() *cli.Command {
 				Usage:    "Proxy request to another server",
 				Category: "response",
 			},
+			&cli.BoolFlag{
+				Name:     "tunnel",
+				Usage:    "Answer requests through the connected 'tunnel' clients, requires --tunnel-listen",
+				Category: "response",
+			},
 			&cli.StringFlag{
 				Name:     "wwwroot",
 				Aliases:  []string{"w"},
 				Usage:    "Static files directory",
 				Category: "response",
 			},
+			&cli.StringFlag{
+				Name:     "scenario",
+				Usage:    "JSON file of routes answering with a sequence of responses, other requests fall through",
+				Category: "response",
+			},
+			&cli.StringFlag{
+				Name:     "playback",
+				Usage:    "Serve recorded responses from request files in this directory",
+				Category: "playback",
+			},
+			&cli.StringSliceFlag{
+				Name:     "match",
+				Usage:    "Also match playback requests by 'query', 'header:<name>', 'body' or 'json' (body is a superset of the recorded json)",
+				Category: "playback",
+			},
+			&cli.StringFlag{
+				Name:     "on-miss",
+				Usage:    "Playback miss behavior: '404', 'proxy' (forward to --proxy and record) or 'fail'",
+				Value:    "404",
+				Category: "playback",
+			},
+			&cli.StringFlag{
+				Name:     "admin",
+				Aliases:  []string{"A"},
+				Usage:    "Admin listen address serving the web UI, e.g. 'localhost:9090'",
+				Category: "admin",
+			},
+			&cli.IntFlag{
+				Name:     "admin-records",
+				Usage:    "Records kept in memory for the web UI, older ones stay on disk only",
+				Value:    10000,
+				Category: "admin",
+			},
+			&cli.StringSliceFlag{
+				Name:     "tee",
+				Usage:    "Also forward captured requests to this url, can be repeated",
+				Category: "tee",
+			},
+			&cli.IntFlag{
+				Name:     "tee-queue",
+				Usage:    "Requests queued per tee target before dropping",
+				Value:    100,
+				Category: "tee",
+			},
+			&cli.IntFlag{
+				Name:     "tee-retries",
+				Usage:    "Retries of a tee delivery on connection errors and 5xx",
+				Value:    2,
+				Category: "tee",
+			},
+			&cli.StringFlag{
+				Name:     "tee-timeout",
+				Usage:    "Timeout of a tee delivery",
+				Value:    "10s",
+				Category: "tee",
+			},
+			&cli.StringFlag{
+				Name:     "verify",
+				Usage:    "Verify webhook signatures: 'github', 'stripe' or 'slack', see the config file for generic hmac",
+				Category: "verify",
+			},
+			&cli.StringFlag{
+				Name:     "verify-secret",
+				Usage:    "Secret of the webhook signatures",
+				Category: "verify",
+			},
+			&cli.BoolFlag{
+				Name:     "verify-reject",
+				Usage:    "Answer requests with an invalid signature with 401",
+				Category: "verify",
+			},
+			&cli.StringFlag{
+				Name:     "tunnel-listen",
+				Usage:    "Listen address of the endpoint 'tunnel' clients connect to",
+				Category: "tunnel",
+			},
+			&cli.StringFlag{
+				Name:     "tunnel-token",
+				Usage:    "Token the tunnel clients have to present",
+				EnvVars:  []string{"TUNNEL_TOKEN"},
+				Category: "tunnel",
+			},
+			&cli.StringFlag{
+				Name:     "tunnel-cert",
+				Usage:    "TLS certificate file of the tunnel endpoint",
+				Category: "tunnel",
+			},
+			&cli.StringFlag{
+				Name:     "tunnel-key",
+				Usage:    "TLS key file of the tunnel endpoint",
+				Category: "tunnel",
+			},
+			&cli.StringFlag{
+				Name:  "jsonl",
+				Usage: "Also append every record to this JSONL file, e.g. as a source of 'relay'",
+			},
+			&cli.StringFlag{
+				Name:     "log-format",
+				Usage:    "Request log format: 'text', 'json' or 'combined' (Apache combined log on stdout)",
+				Value:    "text",
+				Category: "log",
+			},
+			&cli.StringFlag{
+				Name:     "access-log",
+				Usage:    "Append requests to this file in the Apache combined log format",
+				Category: "log",
+			},
 		},
 		Action: func(c *cli.Context) error {
-			isTls := c.Bool("https") || (c.String("cert") != "" && c.String("key") != "")
-			if isTls {
-				if c.String("listen") == "" {
-					_ = c.Set("listen", ":443")
-				}
-				if c.String("cert") == "" && c.String("key") == "" {
-					_ = c.Set("cert", "cert.pem")
-					_ = c.Set("key", "key.pem")
-				}
-				if c.String("cert") == "" {
-					return cli.Exit("TLS certificate file is required", 1)
-				}
-				if c.String("key") == "" {
-					return cli.Exit("TLS key file is required", 1)
-				}
-			} else {
-				if c.String("listen") == "" {
-					_ = c.Set("listen", ":8080")
-				}
+			cfg, err := loadServerConfig(c)
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
 			}
 
-			handler, err := httpHandler(c)
+			logger, err := newRequestLogger(cfg.LogFormat, cfg.AccessLog)
 			if err != nil {
-				return err
+				return cli.Exit(err.Error(), 1)
 			}
 
-			if isTls {
-				log.Printf("Starting HTTPS server on '%s'", c.String("listen"))
-				err := http.ListenAndServeTLS(c.String("listen"), c.String("cert"), c.String("key"), handler)
-				if err != nil {
-					log.Fatalf("failed to start server: %v", err)
+			var j *journal
+			if cfg.Jsonl != "" {
+				if j, err = openJournal(cfg.Jsonl); err != nil {
+					return cli.Exit(err.Error(), 1)
 				}
-			} else {
-				log.Printf("Starting HTTP server on '%s'", c.String("listen"))
-				err := http.ListenAndServe(c.String("listen"), handler)
+				log.Printf("Records are also appended to '%s'", cfg.Jsonl)
+			}
+
+			store := newRecordStore(cfg.AdminRecords)
+			var recorders []*recorder
+			var dirs []string
+			for _, l := range cfg.Listeners {
+				rec, err := newRecorder(l, store, logger, j)
 				if err != nil {
-					log.Fatalf("failed to start server: %v", err)
+					return err
 				}
+				recorders = append(recorders, rec)
+				dirs = append(dirs, l.Save)
+			}
+			if err := store.open(cfg.Num, dirs); err != nil {
+				return err
+			}
+
+			if c.String("config") != "" {
+				go watchConfig(c, c.String("config"), cfg, recorders)
+			}
+
+			if cfg.TunnelListen != "" {
+				go func() {
+					if err := serveTunnel(cfg); err != nil {
+						log.Fatalf("failed to start tunnel endpoint: %v", err)
+					}
+				}()
+			}
+
+			if cfg.Admin != "" {
+				go func() {
+					log.Printf("Starting admin server on '%s'", cfg.Admin)
+					if err := http.ListenAndServe(cfg.Admin, adminHandler(store, recorders)); err != nil {
+						log.Fatalf("failed to start admin server: %v", err)
+					}
+				}()
+			}
+
+			errs := make(chan error, len(cfg.Listeners))
+			for i, l := range cfg.Listeners {
+				go func() {
+					errs <- serveListener(l, recorders[i])
+				}()
+			}
+			if err := <-errs; err != nil {
+				log.Fatalf("failed to start server: %v", err)
 			}
 
 			return nil
@@ -135,116 +306,350 @@ func serverCmd() *cli.Command {
 	}
 }
 
-func httpHandler(c *cli.Context) (http.HandlerFunc, error) {
-	saveDir := c.String("save")
-	if err := os.MkdirAll(saveDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create directory %s: %v", saveDir, err)
+func serveListener(l *listenerConfig, handler http.Handler) error {
+	name := ""
+	if l.Name != "" {
+		name = fmt.Sprintf(" '%s'", l.Name)
 	}
 
-	var responser http.HandlerFunc
-	var err error
-	if c.String("proxy") != "" {
-		responser, err = proxyResponse(c.String("proxy"))
-		if err != nil {
-			return nil, err
+	ln, err := listen(l)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: handler}
+
+	if l.RawHeaders {
+		// the raw bytes are read below TLS, so the server gets plain connections
+		if l.HTTPS {
+			tlsConfig, err := l.serverTLSConfig()
+			if err != nil {
+				ln.Close()
+				return err
+			}
+			cert, err := tls.LoadX509KeyPair(l.Cert, l.Key)
+			if err != nil {
+				ln.Close()
+				return fmt.Errorf("failed to load certificate: %s", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+			tlsConfig.NextProtos = []string{"http/1.1"}
+			ln = tls.NewListener(ln, tlsConfig)
 		}
-	} else if c.String("wwwroot") != "" {
-		responser, err = staticResponse(c.String("wwwroot"))
-		if err != nil {
-			return nil, err
+		srv.ConnContext = rawHeaderContext
+		ln = rawHeaderListener{ln}
+	}
+
+	if l.HTTPS && !l.RawHeaders {
+		if srv.TLSConfig, err = l.serverTLSConfig(); err != nil {
+			ln.Close()
+			return err
 		}
+		log.Printf("Starting HTTPS server%s on '%s'", name, l.Listen)
+		return srv.ServeTLS(ln, l.Cert, l.Key)
+	}
+
+	if l.HTTPS {
+		log.Printf("Starting HTTPS server%s on '%s'", name, l.Listen)
 	} else {
-		responser = simpleResponse(c.Int("status"), c.String("body"))
+		log.Printf("Starting HTTP server%s on '%s'", name, l.Listen)
+	}
+	return srv.Serve(ln)
+}
+
+// recorder captures every request into its store and answers it with the
+// current responder.
+type recorder struct {
+	name      string
+	saveDir   string
+	maxBody   int64
+	store     *recordStore
+	responder atomic.Pointer[responder]
+	filters   atomic.Pointer[filterConfig]
+	redact    atomic.Pointer[redactConfig]
+	verify    atomic.Pointer[verifiers]
+	roots     atomic.Pointer[[]string]
+	logger    *requestLogger
+	tee       *tee
+	journal   *journal
+}
+
+func newRecorder(l *listenerConfig, store *recordStore, logger *requestLogger, j *journal) (*recorder, error) {
+	if err := os.MkdirAll(l.Save, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %v", l.Save, err)
+	}
+
+	t, err := newTee(l.Tee)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &recorder{name: l.Name, saveDir: l.Save, maxBody: l.MaxBody, store: store, logger: logger, tee: t, journal: j}
+	if err := rec.setResponder(l.Responder); err != nil {
+		return nil, err
+	}
+	rec.setRules(l.Filters, l.Redact, l.Verify)
+	rec.setRoots(l)
+
+	if l.Name != "" {
+		log.Printf("Listener '%s' saves requests to '%s'", l.Name, l.Save)
+	}
+	return rec, nil
+}
+
+func (rec *recorder) setResponder(cfg *responderConfig) error {
+	res, err := newResponder(cfg)
+	if err != nil {
+		return err
+	}
+	rec.responder.Store(res)
+	return nil
+}
+
+// setRoots keeps the directories of the listener config, the admin api only
+// points the responder at files below them.
+func (rec *recorder) setRoots(l *listenerConfig) {
+	roots := []string{l.Save}
+	if res := l.Responder; res != nil {
+		for _, dir := range []string{res.Playback, res.WWWRoot} {
+			if dir != "" {
+				roots = append(roots, dir)
+			}
+		}
+		if res.Scenario != "" {
+			roots = append(roots, filepath.Dir(res.Scenario))
+		}
 	}
+	rec.roots.Store(&roots)
+}
 
-	num := int32(c.Int("num"))
-	if num <= 0 {
-		_num, err := maxFileNum(saveDir)
+// allowedPath reports whether path is one of the configured directories or
+// below one of them.
+func (rec *recorder) allowedPath(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, root := range *rec.roots.Load() {
+		rootAbs, err := filepath.Abs(root)
 		if err != nil {
-			return nil, err
+			continue
+		}
+		if rel, err := filepath.Rel(rootAbs, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
 		}
-		num = int32(_num)
 	}
+	return false
+}
 
-	log.Printf("Requests save to '%s', file number start from %d", saveDir, num+1)
+func (rec *recorder) setRules(filters *filterConfig, redact *redactConfig, verify verifiers) {
+	rec.filters.Store(filters)
+	rec.redact.Store(redact)
+	rec.verify.Store(&verify)
+}
 
-	return func(w http.ResponseWriter, r *http.Request) {
-		now := time.Now()
-		path := r.URL.Path[1:]
-
-		// replace invalid characters for filename
-		path = strings.ReplaceAll(path, "/", "_")
-		path = strings.ReplaceAll(path, "\\", "_")
-		path = strings.ReplaceAll(path, ".", "_")
-
-		requestNum := atomic.AddInt32(&num, 1)
-		filename := fmt.Sprintf("%04d_%s_%s_%s.json",
-			requestNum,
-			now.Format("20060102_150405"),
-			r.Method,
-			path)
-		filename = strings.ReplaceAll(filename, "__", "_")
-
-		record := Record{
-			Method:   r.Method,
-			URL:      r.URL.String(),
-			Time:     now.Format(time.RFC3339),
-			Protocol: r.Proto,
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// taken first, the connection drops the head of every request it served
+	header := rawHeader(r)
+
+	// every request is logged once, with the status actually written
+	now := time.Now()
+	cw := &responseCapture{ResponseWriter: w, limit: rec.maxBody}
+	var requestNum int
+	var file string
+	defer func() {
+		status := cw.status
+		if status == 0 {
+			status = http.StatusOK
 		}
+		rec.logger.log(&requestLog{
+			Seq:      requestNum,
+			Time:     now,
+			Request:  r,
+			Status:   status,
+			Bytes:    cw.size,
+			Duration: time.Since(now),
+			File:     file,
+		})
+	}()
+
+	responder := rec.responder.Load()
+	if !rec.filters.Load().match(r) {
+		filteredTotal.inc(rec.name, metricMethod(r.Method))
+		cw.discard = true
+		responder.handler(cw, r)
+		return
+	}
+
+	requestNum = rec.store.nextSeq()
+	filename := recordFilename(requestNum, now, r.Method, r.URL.Path)
+	basename := strings.TrimSuffix(filename, ".json")
+
+	record := Record{
+		Method:   r.Method,
+		URL:      r.URL.String(),
+		Time:     now.Format(time.RFC3339Nano),
+		Protocol: r.Proto,
+		Listener: rec.name,
+		Request:  &RequestResponse{},
+	}
 
-		var header Header
-		header.FromHttpHeader(r.Header)
-		delete(header, "Content-Encoding")
-		record.Request = &RequestResponse{
-			Header:                  header,
-			OriginalContentEncoding: r.Header.Get("Content-Encoding"),
+	if rec.maxBody > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, rec.maxBody)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			log.Printf("request body exceeds %d bytes", tooLarge.Limit)
+			captureFailures.inc(rec.name, "too_large")
+			cw.WriteHeader(http.StatusRequestEntityTooLarge)
+			cw.Write([]byte("request body too large"))
+			return
+		}
+		log.Printf("failed to read body: %v", err)
+		captureFailures.inc(rec.name, "read")
+		cw.WriteHeader(http.StatusBadRequest)
+		cw.Write([]byte("failed to read body"))
+		return
+	}
+	if r.TLS != nil {
+		if record.TLS, err = captureTLS(r.TLS, rec.saveDir, basename); err != nil {
+			log.Printf("failed to capture TLS state: %v", err)
+			captureFailures.inc(rec.name, "tls")
 		}
+	}
+	if err := captureBody(record.Request, header, body, rec.saveDir, basename); err != nil {
+		log.Printf("failed to save body: %v", err)
+		captureFailures.inc(rec.name, "request_body")
+		cw.WriteHeader(http.StatusInternalServerError)
+		cw.Write([]byte("failed to save body"))
+		return
+	}
 
-		if r.Body != nil {
-			defer r.Body.Close()
+	if rec.tee != nil {
+		record.TeeFile = basename + "-tee.jsonl"
+		rec.tee.send(&record, rec.saveDir, record.TeeFile)
+	}
 
-			contentType := r.Header.Get("Content-Type")
-			var err error
-			if isContentMultiPart(contentType) {
-				record.Request.BodyMultiPart, err = readMultiPart(r, contentType, filename)
-				if err != nil {
-					log.Fatalf("failed to parse multipart: %v", err)
-					w.WriteHeader(http.StatusBadRequest)
-					w.Write([]byte("failed to parse multipart"))
-					return
-				}
-			} else if isContentJson(contentType) {
-				record.Request.BodyJson, err = readJson(r.Body)
-				if err != nil {
-					log.Fatalf("failed to parse json: %v", err)
-					w.WriteHeader(http.StatusBadRequest)
-					w.Write([]byte("failed to parse json"))
-					return
-				}
-			} else {
-				recommendFilename := fmt.Sprintf("%s-body.dat", strings.TrimSuffix(filename, ".json"))
-				record.Request.Body, record.Request.BodyFile, err = saveBody(r.Body, contentType, recommendFilename)
-				if err != nil {
-					log.Fatalf("failed to save body: %v", err)
-					w.WriteHeader(http.StatusInternalServerError)
-					w.Write([]byte("failed to save body"))
-					return
-				}
-			}
+	handler := responder.handler
+	if v := rec.verify.Load().find(r); v != nil {
+		record.Verification = v.verify(r.Header, body, now)
+		if !record.Verification.Valid && v.Reject {
+			handler = rejectResponse
 		}
+	}
 
-		// save record to file
-		if err := saveRecord(saveDir, filename, &record); err != nil {
-			log.Fatalf("failed to create file '%s': %v", filename, err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("failed to create file"))
-			return
+	// the responder gets its own copy of the body, it may proxy or match it
+	ex := &exchange{}
+	r = r.WithContext(context.WithValue(r.Context(), exchangeKey{}, ex))
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	responderStart := time.Now()
+	handler(cw, r)
+	responderDuration := time.Since(responderStart)
+
+	record.Response = &RequestResponse{Status: cw.status, Truncated: cw.truncated}
+	if record.Response.Status == 0 {
+		record.Response.Status = http.StatusOK
+	}
+	if cw.truncated {
+		log.Printf("response body exceeds %d bytes, recording the first %d", rec.maxBody, cw.body.Len())
+	}
+	var respHeader Header
+	respHeader.FromHttpHeader(cw.Header())
+	if err := captureBody(record.Response, respHeader, cw.body.Bytes(), rec.saveDir, basename+"-response"); err != nil {
+		log.Printf("failed to save response body: %v", err)
+		captureFailures.inc(rec.name, "response_body")
+	}
+
+	route := ex.route
+	if route == "" {
+		route = metricRoute(r.URL.Path)
+	}
+	requestsTotal.inc(rec.name, metricMethod(r.Method), metricRoutes.label(route), strconv.Itoa(record.Response.Status))
+	requestBodySize.observe(float64(len(body)), rec.name)
+	responseBodySize.observe(float64(cw.size), rec.name)
+
+	rec.redact.Load().apply(&record, rec.saveDir)
+
+	// save record to file
+	if err := saveRecord(rec.saveDir, filename, &record); err != nil {
+		log.Printf("failed to create file '%s': %v", filename, err)
+		captureFailures.inc(rec.name, "record")
+		return
+	}
+	file = filepath.Join(rec.saveDir, filename)
+	captureDuration.observe((time.Since(now) - responderDuration).Seconds(), rec.name)
+	entry := &recordEntry{Seq: requestNum, File: filename, Dir: rec.saveDir, Record: &record}
+	rec.store.add(entry)
+	if rec.journal != nil {
+		if err := rec.journal.append(entry); err != nil {
+			log.Printf("failed to append record to journal: %v", err)
+			captureFailures.inc(rec.name, "journal")
 		}
+	}
+	if responder.playback != nil && ex.playbackMiss {
+		responder.playback.learn(&record, rec.saveDir)
+	}
+}
+
+type exchangeKey struct{}
+
+// exchange carries per request state from httpHandler to the responders.
+type exchange struct {
+	playbackMiss bool
+	route        string
+}
+
+func exchangeFromContext(ctx context.Context) *exchange {
+	ex, _ := ctx.Value(exchangeKey{}).(*exchange)
+	return ex
+}
+
+// responseCapture passes the response through and keeps a copy of up to
+// limit bytes for the record, unless discard is set.
+type responseCapture struct {
+	http.ResponseWriter
+	status    int
+	size      int
+	discard   bool
+	limit     int64
+	truncated bool
+	body      bytes.Buffer
+}
 
-		responser(w, r)
+func (w *responseCapture) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
 
-		log.Printf("#%04d [%s] %s %s", requestNum, now.Format("15:04:05"), r.Method, r.URL.Path)
-	}, nil
+func (w *responseCapture) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.discard {
+		keep := b
+		if room := w.limit - int64(w.body.Len()); w.limit > 0 && int64(len(b)) > room {
+			keep = b[:max(room, 0)]
+			w.truncated = true
+		}
+		w.body.Write(keep)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+func (w *responseCapture) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *responseCapture) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
 func simpleResponse(status int, msg string) http.HandlerFunc {
@@ -258,6 +663,10 @@ func simpleResponse(status int, msg string) http.HandlerFunc {
 	}
 }
 
+func rejectResponse(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "invalid signature", http.StatusUnauthorized)
+}
+
 func staticResponse(wwwroot string) (http.HandlerFunc, error) {
 	fi, err := os.Stat(wwwroot)
 	if err != nil {
@@ -278,12 +687,17 @@ func proxyResponse(_url string) (http.HandlerFunc, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse proxy url %s: %v", _url, err)
 	}
+	if proxyURL.Scheme == "" || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy url %s", _url)
+	}
 
-	_ = proxyURL
-
-	return func(w http.ResponseWriter, r *http.Request) {
-		// TODO: proxy request
-	}, nil
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(proxyURL)
+			pr.SetXForwarded()
+		},
+	}
+	return proxy.ServeHTTP, nil
 }
 
 func maxFileNum(dir string) (int, error) {
@@ -300,6 +714,7 @@ func maxFileNum(dir string) (int, error) {
 			if err == io.EOF {
 				break
 			}
+			return 0, fmt.Errorf("failed to read directory %s: %v", dir, err)
 		}
 
 		for _, d := range dirs {
@@ -307,16 +722,8 @@ func maxFileNum(dir string) (int, error) {
 				continue
 			}
 
-			name := d.Name()
-			pos := strings.IndexFunc(name, func(r rune) bool {
-				return r < '0' || r > '9'
-			})
-			if pos == -1 {
-				continue
-			}
-
-			n, err := strconv.Atoi(name[:pos])
-			if err != nil {
+			n, ok := fileSeq(d.Name())
+			if !ok {
 				continue
 			}
 
@@ -333,18 +740,20 @@ func isContentMultiPart(contentType string) bool {
 	return strings.Contains(contentType, "multipart/form-data")
 }
 
-func readMultiPart(r *http.Request, contentType string, jsonFilename string) ([]*MultiPart, error) {
-	if r.Body == nil {
-		return nil, errors.New("missing form body")
+func readMultiPart(body []byte, contentType string, dir string, basename string) ([]*MultiPart, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse content type: %w", err)
+	}
+	if params["boundary"] == "" {
+		return nil, errors.New("missing multipart boundary")
 	}
 
 	var multiParts []*MultiPart
 	var n int
 
-	mr, err := r.MultipartReader()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create multipart reader: %w", err)
-	}
+	heads := rawPartHeads(body, params["boundary"])
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
 	for {
 		p, err := mr.NextPart()
 		if err != nil {
@@ -355,7 +764,11 @@ func readMultiPart(r *http.Request, contentType string, jsonFilename string) ([]
 		}
 
 		multiPart := &MultiPart{}
-		multiPart.Header.FromMIMEHeader(p.Header)
+		if i := len(multiParts); i < len(heads) && sameFields(heads[i], p.Header) {
+			multiPart.Header = heads[i]
+		} else {
+			multiPart.Header.FromMIMEHeader(p.Header)
+		}
 
 		contentType := p.Header.Get("Content-Type")
 		if isContentJson(contentType) {
@@ -366,17 +779,13 @@ func readMultiPart(r *http.Request, contentType string, jsonFilename string) ([]
 		} else {
 			var recommendFilename string
 			if p.FileName() != "" {
-				recommendFilename = fmt.Sprintf("%s-%s",
-					strings.TrimSuffix(jsonFilename, ".json"),
-					p.FileName())
+				recommendFilename = fmt.Sprintf("%s-%s", basename, filepath.Base(p.FileName()))
 			} else {
-				recommendFilename = fmt.Sprintf("%s-multipart_%d.dat",
-					strings.TrimSuffix(jsonFilename, ".json"),
-					n)
+				recommendFilename = fmt.Sprintf("%s-multipart_%d.dat", basename, n)
 				n++
 			}
 
-			multiPart.Content, multiPart.ContentFile, err = saveBody(p, p.Header.Get("Content-Type"), recommendFilename)
+			multiPart.Content, multiPart.ContentFile, err = saveBody(p, p.Header.Get("Content-Type"), dir, recommendFilename)
 			if err != nil {
 				return nil, err
 			}
@@ -388,10 +797,26 @@ func readMultiPart(r *http.Request, contentType string, jsonFilename string) ([]
 	return multiParts, nil
 }
 
+// sameFields reports whether the raw head has the fields of the parsed one.
+func sameFields(raw Header, header textproto.MIMEHeader) bool {
+	n := 0
+	for k, values := range header {
+		if !slices.Equal(raw.Values(k), values) {
+			return false
+		}
+		n += len(values)
+	}
+	return n == len(raw)
+}
+
 func isContentJson(contentType string) bool {
 	return strings.Contains(contentType, "/json")
 }
 
+func isContentForm(contentType string) bool {
+	return strings.Contains(contentType, "application/x-www-form-urlencoded")
+}
+
 func readJson(r io.Reader) (json.RawMessage, error) {
 	lr := io.LimitReader(r, 1<<20)
 	data, err := io.ReadAll(lr)
@@ -405,7 +830,68 @@ func readJson(r io.Reader) (json.RawMessage, error) {
 	return data, nil
 }
 
-func saveBody(r io.Reader, contentType string, recommendFilename string) (string, string, error) {
+// captureBody fills the body fields of rr the same way for requests and
+// responses, attachments are saved in dir with basename as prefix.
+func captureBody(rr *RequestResponse, header Header, body []byte, dir string, basename string) error {
+	// Host, Content-Length and Transfer-Encoding stay for their position and
+	// casing, their values are recomputed when the request is sent again
+	rr.Header = header
+
+	// an undecodable body is kept as it is, with its Content-Encoding
+	if encoding := header.Get("Content-Encoding"); encoding != "" {
+		if decoded, err := decodeContent(encoding, body); err == nil {
+			body = decoded
+			rr.OriginalContentEncoding = encoding
+			rr.Header.Del("Content-Encoding")
+		}
+	}
+
+	if len(body) == 0 {
+		return nil
+	}
+
+	contentType := header.Get("Content-Type")
+	if isContentMultiPart(contentType) {
+		multiParts, err := readMultiPart(body, contentType, dir, basename)
+		if err == nil {
+			rr.BodyMultiPart = multiParts
+			return nil
+		}
+		log.Printf("failed to parse multipart, saving raw body: %v", err)
+	} else if isContentJson(contentType) {
+		bodyJson, err := readJson(bytes.NewReader(body))
+		if err == nil {
+			rr.BodyJson = bodyJson
+			return nil
+		}
+		log.Printf("failed to parse json, saving raw body: %v", err)
+	}
+
+	var err error
+	rr.Body, rr.BodyFile, err = saveBody(bytes.NewReader(body), contentType, dir, basename+"-body.dat")
+	return err
+}
+
+func decodeContent(encoding string, body []byte) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch strings.ToLower(encoding) {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %s", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+func saveBody(r io.Reader, contentType string, dir string, recommendFilename string) (string, string, error) {
 	var buffer []byte
 
 	buffer = make([]byte, 64*1024)
@@ -436,12 +922,14 @@ func saveBody(r io.Reader, contentType string, recommendFilename string) (string
 
 saveFile:
 	ext, _ := mime.ExtensionsByType(contentType)
-	if len(ext) >= 0 {
+	if len(ext) > 0 {
 		recommendFilename = strings.TrimSuffix(recommendFilename, filepath.Ext(recommendFilename))
 		recommendFilename = fmt.Sprintf("%s%s", recommendFilename, ext[0])
 	}
 
-	f, err := os.Create(recommendFilename)
+	defer storageDuration.observeSince(time.Now(), "body")
+
+	f, err := os.Create(filepath.Join(dir, recommendFilename))
 	if err != nil {
 		return "", "", err
 	}
@@ -460,18 +948,46 @@ saveFile:
 	return "", recommendFilename, nil
 }
 
+// recordFilename names the record file of a request.
+func recordFilename(seq int, now time.Time, method string, urlPath string) string {
+	path := strings.TrimPrefix(urlPath, "/")
+
+	// replace invalid characters for filename
+	path = strings.ReplaceAll(path, "/", "_")
+	path = strings.ReplaceAll(path, "\\", "_")
+	path = strings.ReplaceAll(path, ".", "_")
+
+	filename := fmt.Sprintf("%04d_%s_%s_%s.json",
+		seq,
+		now.Format("20060102_150405"),
+		method,
+		path)
+	return strings.ReplaceAll(filename, "__", "_")
+}
+
+// saveRecord writes the record file atomically, so directory watchers never
+// see a partially written record.
 func saveRecord(dir, filename string, record *Record) error {
-	f, err := os.Create(filepath.Join(dir, filename))
+	defer storageDuration.observeSince(time.Now(), "record")
+
+	tmpFilename := filepath.Join(dir, filename+".tmp")
+	f, err := os.Create(tmpFilename)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
 	enc := json.NewEncoder(f)
 	enc.SetIndent("", "  ")
 	enc.SetEscapeHTML(false)
 	if err := enc.Encode(record); err != nil {
+		f.Close()
+		os.Remove(tmpFilename)
 		return err
 	}
-	return nil
+	if err := f.Close(); err != nil {
+		os.Remove(tmpFilename)
+		return err
+	}
+
+	return os.Rename(tmpFilename, filepath.Join(dir, filename))
 }
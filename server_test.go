@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestRecorder(t *testing.T, maxBody int64) (*recorder, string) {
+	t.Helper()
+	dir := t.TempDir()
+	logger, err := newRequestLogger("text", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &listenerConfig{
+		Save:      dir,
+		MaxBody:   maxBody,
+		Responder: &responderConfig{Status: 200, Body: "ok"},
+		Filters:   &filterConfig{},
+		Redact:    &redactConfig{},
+	}
+	rec, err := newRecorder(l, newRecordStore(0), logger, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec, dir
+}
+
+func TestRecorderMaxBody(t *testing.T) {
+	rec, dir := newTestRecorder(t, 16)
+
+	w := httptest.NewRecorder()
+	rec.ServeHTTP(w, httptest.NewRequest("POST", "/small", strings.NewReader("0123456789")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	rec.ServeHTTP(w, httptest.NewRequest("POST", "/large", strings.NewReader(strings.Repeat("x", 17))))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", w.Code)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 || !strings.Contains(files[0], "small") {
+		t.Errorf("records = %v, want only the small request", files)
+	}
+}
+
+func TestRecorderLogsEveryRequest(t *testing.T) {
+	rec, _ := newTestRecorder(t, 16)
+	access := &bytes.Buffer{}
+	rec.logger.access = access
+	rec.setRules(&filterConfig{ExcludePaths: []string{"/health"}}, &redactConfig{}, nil)
+
+	rec.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	rec.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/large", strings.NewReader(strings.Repeat("x", 17))))
+	rec.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ok", nil))
+
+	lines := strings.Split(strings.TrimSpace(access.String()), "\n")
+	want := []string{`"GET /health HTTP/1.1" 200 2`, `"POST /large HTTP/1.1" 413 22`, `"GET /ok HTTP/1.1" 200 2`}
+	if len(lines) != len(want) {
+		t.Fatalf("logged %d requests, want %d:\n%s", len(lines), len(want), access)
+	}
+	for i, line := range lines {
+		if !strings.Contains(line, want[i]) {
+			t.Errorf("line %d = %s, want %s", i, line, want[i])
+		}
+	}
+}
+
+func TestRecorderResponseLimit(t *testing.T) {
+	rec, dir := newTestRecorder(t, 16)
+	body := strings.Repeat("y", 40)
+	if err := rec.setResponder(&responderConfig{Status: 200, Body: body}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	rec.ServeHTTP(w, httptest.NewRequest("GET", "/download", nil))
+	if w.Body.String() != body {
+		t.Errorf("client got %d bytes, want the whole response of %d", w.Body.Len(), len(body))
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("records = %v", files)
+	}
+	var record Record
+	if err := loadRecord(files[0], &record); err != nil {
+		t.Fatal(err)
+	}
+	if !record.Response.Truncated || record.Response.Body != body[:16] {
+		t.Errorf("recorded response truncated %v body %q, want the first 16 bytes", record.Response.Truncated, record.Response.Body)
+	}
+}
+
+func TestCaptureBodyContentEncoding(t *testing.T) {
+	dir := t.TempDir()
+
+	compressed := &bytes.Buffer{}
+	gz := gzip.NewWriter(compressed)
+	io.WriteString(gz, "hello world")
+	gz.Close()
+
+	rr := &RequestResponse{}
+	header := Header{{Name: "Content-Encoding", Value: "gzip"}, {Name: "Content-Length", Value: "31"}}
+	if err := captureBody(rr, header, compressed.Bytes(), dir, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Body != "hello world" || rr.OriginalContentEncoding != "gzip" || rr.Header.Get("Content-Encoding") != "" {
+		t.Errorf("decoded body = %q, encoding %q, header %v", rr.Body, rr.OriginalContentEncoding, rr.Header)
+	}
+
+	// a body which does not decode is kept with its encoding
+	rr = &RequestResponse{}
+	header = Header{{Name: "Content-Encoding", Value: "gzip"}}
+	if err := captureBody(rr, header, []byte("not gzip at all"), dir, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if rr.OriginalContentEncoding != "" || rr.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("undecodable body: encoding %q, header %v", rr.OriginalContentEncoding, rr.Header)
+	}
+}
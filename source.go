@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// journalEntry is one line of a JSONL record journal, body files of the
+// record are relative to dir.
+type journalEntry struct {
+	Seq    int     `json:"seq"`
+	File   string  `json:"file"`
+	Dir    string  `json:"dir"`
+	Record *Record `json:"record"`
+}
+
+// journal appends every saved record to a JSONL file.
+type journal struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openJournal(filename string) (*journal, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %s", err)
+	}
+	return &journal{f: f}, nil
+}
+
+func (j *journal) append(entry *recordEntry) error {
+	dir, err := filepath.Abs(entry.Dir)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&journalEntry{Seq: entry.Seq, File: entry.File, Dir: dir, Record: entry.Record})
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	_, err = j.f.Write(append(data, '\n'))
+	return err
+}
+
+// readRecordSource reads the records after seq from a save directory or a
+// JSONL journal, sorted by seq. A journal is read from offset on, the offset
+// after its last complete line is returned.
+func readRecordSource(path string, after int, offset int64) ([]*recordEntry, int64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open record source: %s", err)
+	}
+	if fi.IsDir() {
+		entries, err := readRecordDir(path, after)
+		return entries, 0, err
+	}
+	if fi.Size() < offset {
+		// truncated or replaced, start over
+		offset = 0
+	}
+	return readJournalFrom(path, after, offset)
+}
+
+func readRecordDir(dir string, after int) ([]*recordEntry, error) {
+	dirs, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open directory %s: %v", dir, err)
+	}
+
+	var entries []*recordEntry
+	for _, d := range dirs {
+		if d.IsDir() || filepath.Ext(d.Name()) != ".json" {
+			continue
+		}
+
+		seq, ok := fileSeq(d.Name())
+		if !ok || seq <= after {
+			continue
+		}
+
+		var record Record
+		if err := loadRecord(filepath.Join(dir, d.Name()), &record); err != nil {
+			continue
+		}
+		if record.Method == "" {
+			continue
+		}
+
+		entries = append(entries, &recordEntry{Seq: seq, File: d.Name(), Dir: dir, Record: &record})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Seq < entries[j].Seq
+	})
+	return entries, nil
+}
+
+func readRecordJournal(filename string, after int) ([]*recordEntry, error) {
+	entries, _, err := readJournalFrom(filename, after, 0)
+	return entries, err
+}
+
+// readJournalFrom reads the journal lines starting at offset, a last line
+// still being written is left for the next read.
+func readJournalFrom(filename string, after int, offset int64) ([]*recordEntry, int64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open journal: %s", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, 0, fmt.Errorf("failed to read journal %s: %s", filename, err)
+	}
+
+	var entries []*recordEntry
+	r := bufio.NewReaderSize(f, 64*1024)
+	for {
+		data, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read journal %s: %s", filename, err)
+		}
+		start := offset
+		offset += int64(len(data))
+
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+		var je journalEntry
+		if err := json.Unmarshal(data, &je); err != nil {
+			return nil, 0, fmt.Errorf("failed to decode journal %s at offset %d: %s", filename, start, err)
+		}
+		if je.Seq <= after || je.Record == nil {
+			continue
+		}
+
+		dir := je.Dir
+		if dir == "" {
+			dir = filepath.Dir(filename)
+		}
+		entries = append(entries, &recordEntry{Seq: je.Seq, File: je.File, Dir: dir, Record: je.Record})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Seq < entries[j].Seq
+	})
+	return entries, offset, nil
+}
+
+// loadRecordEntries reads the records given as files, directories, globs or
+// JSONL journals. A record given twice is only returned once.
+func loadRecordEntries(patterns []string) ([]*recordEntry, error) {
+	var entries []*recordEntry
+	seen := make(map[string]bool)
+	add := func(list ...*recordEntry) {
+		for _, entry := range list {
+			key := filepath.Join(entry.Dir, entry.File)
+			if entry.File == "" || !seen[key] {
+				seen[key] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	for _, pattern := range patterns {
+		paths := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %s", pattern, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no file matches %s", pattern)
+			}
+			paths = matches
+		}
+
+		for _, path := range paths {
+			fi, err := os.Stat(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open record source: %s", err)
+			}
+
+			switch {
+			case fi.IsDir():
+				list, err := readRecordDir(path, 0)
+				if err != nil {
+					return nil, err
+				}
+				add(list...)
+			case filepath.Ext(path) == ".jsonl":
+				list, err := readRecordJournal(path, 0)
+				if err != nil {
+					return nil, err
+				}
+				add(list...)
+			default:
+				var record Record
+				if err := loadRecord(path, &record); err != nil {
+					return nil, fmt.Errorf("%s: %s", path, err)
+				}
+				seq, _ := fileSeq(filepath.Base(path))
+				add(&recordEntry{Seq: seq, File: filepath.Base(path), Dir: filepath.Dir(path), Record: &record})
+			}
+		}
+	}
+	return entries, nil
+}
+
+// sortRecordEntries orders the entries by 'seq' or by the 'time' they were
+// received, records with the same time keep their order.
+func sortRecordEntries(entries []*recordEntry, order string) error {
+	switch order {
+	case "", "seq":
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Seq < entries[j].Seq
+		})
+	case "time":
+		times := make(map[*recordEntry]time.Time, len(entries))
+		for _, entry := range entries {
+			times[entry], _ = time.Parse(time.RFC3339Nano, entry.Record.Time)
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return times[entries[i]].Before(times[entries[j]])
+		})
+	default:
+		return fmt.Errorf("unknown order '%s', expect 'seq' or 'time'", order)
+	}
+	return nil
+}
+
+// seqRange selects records by sequence number, a bound not given is open.
+type seqRange struct {
+	from, to       int
+	hasFrom, hasTo bool
+}
+
+// parseSeqRange parses ranges like '120-180', '120-', '-180' or '120'.
+func parseSeqRange(s string) (seqRange, error) {
+	var r seqRange
+	s = strings.TrimSpace(s)
+	if s == "" || s == "-" {
+		return r, fmt.Errorf("invalid range '%s', expect 'from-to', 'from-', '-to' or a single number", s)
+	}
+
+	from, to, found := strings.Cut(s, "-")
+	if !found {
+		to = from
+	}
+	bound := func(v string, n *int, has *bool) error {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return nil
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 {
+			return fmt.Errorf("invalid range '%s', bounds are record numbers", s)
+		}
+		*n, *has = i, true
+		return nil
+	}
+	if err := bound(from, &r.from, &r.hasFrom); err != nil {
+		return r, err
+	}
+	if err := bound(to, &r.to, &r.hasTo); err != nil {
+		return r, err
+	}
+	if r.hasFrom && r.hasTo && r.from > r.to {
+		return r, fmt.Errorf("invalid range '%s', %d is after %d", s, r.from, r.to)
+	}
+	return r, nil
+}
+
+func (r seqRange) contains(seq int) bool {
+	return (!r.hasFrom || seq >= r.from) && (!r.hasTo || seq <= r.to)
+}
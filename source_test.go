@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestParseSeqRange(t *testing.T) {
+	tests := []struct {
+		s    string
+		in   []int
+		out  []int
+		fail bool
+	}{
+		{s: "120-180", in: []int{120, 150, 180}, out: []int{0, 119, 181}},
+		{s: "120-", in: []int{120, 100000}, out: []int{0, 119}},
+		{s: "-180", in: []int{0, 1, 180}, out: []int{181}},
+		{s: "120", in: []int{120}, out: []int{119, 121}},
+		{s: " 5 - 7 ", in: []int{5, 7}, out: []int{4, 8}},
+		{s: "0", in: []int{0}, out: []int{1, 2}},
+		{s: "0-0", in: []int{0}, out: []int{1}},
+		{s: "", fail: true},
+		{s: "-", fail: true},
+		{s: "5--3", fail: true},
+		{s: "--3", fail: true},
+		{s: "a-3", fail: true},
+		{s: "9-3", fail: true},
+	}
+	for _, tt := range tests {
+		r, err := parseSeqRange(tt.s)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("parseSeqRange(%q) is accepted", tt.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSeqRange(%q): %v", tt.s, err)
+			continue
+		}
+		for _, seq := range tt.in {
+			if !r.contains(seq) {
+				t.Errorf("%q does not contain %d", tt.s, seq)
+			}
+		}
+		for _, seq := range tt.out {
+			if r.contains(seq) {
+				t.Errorf("%q contains %d", tt.s, seq)
+			}
+		}
+	}
+}
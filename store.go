@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type recordEntry struct {
+	Seq    int     `json:"seq"`
+	File   string  `json:"file"`
+	Dir    string  `json:"-"`
+	Record *Record `json:"record"`
+}
+
+// recordStore keeps the records of a server run in memory, ordered by their
+// sequence number, it also hands out the sequence numbers of new records.
+// Only the newest limit records are kept, the older ones stay on disk.
+type recordStore struct {
+	mu          sync.RWMutex
+	seq         int
+	limit       int
+	entries     []*recordEntry
+	subscribers map[chan *recordEntry]struct{}
+}
+
+func newRecordStore(limit int) *recordStore {
+	return &recordStore{limit: limit}
+}
+
+func (s *recordStore) resetSeq(seq int) {
+	s.mu.Lock()
+	s.seq = seq
+	s.mu.Unlock()
+}
+
+func (s *recordStore) currentSeq() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.seq
+}
+
+func (s *recordStore) nextSeq() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	return s.seq
+}
+
+// open sets up the sequence and reads the records saved in dirs, numbering
+// continues after the highest saved record unless num is given.
+func (s *recordStore) open(num int, dirs []string) error {
+	if num <= 0 {
+		for _, dir := range dirs {
+			n, err := maxFileNum(dir)
+			if err != nil {
+				return err
+			}
+			num = max(num, n)
+		}
+	}
+	s.resetSeq(num)
+
+	loaded := make(map[string]bool)
+	for _, dir := range dirs {
+		if loaded[dir] {
+			continue
+		}
+		loaded[dir] = true
+		if err := s.load(dir); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Requests save to '%s', file number start from %d", strings.Join(dirs, "', '"), num+1)
+	return nil
+}
+
+// load reads the records already saved in dir.
+func (s *recordStore) load(dir string) error {
+	entries, err := readRecordDir(dir, 0)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		s.add(entry)
+	}
+	return nil
+}
+
+func (s *recordStore) add(entry *recordEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pos := sort.Search(len(s.entries), func(i int) bool {
+		return s.entries[i].Seq > entry.Seq
+	})
+	s.entries = append(s.entries, nil)
+	copy(s.entries[pos+1:], s.entries[pos:])
+	s.entries[pos] = entry
+	if s.limit > 0 && len(s.entries) > s.limit {
+		n := len(s.entries) - s.limit
+		clear(s.entries[:n])
+		s.entries = s.entries[n:]
+		if pos < n {
+			// older than everything kept
+			return
+		}
+	}
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- entry:
+		default:
+			// slow subscriber, drop the entry rather than blocking the capture
+		}
+	}
+}
+
+// subscribe delivers every entry added from now on, until cancel is called.
+func (s *recordStore) subscribe() (<-chan *recordEntry, func()) {
+	ch := make(chan *recordEntry, 64)
+
+	s.mu.Lock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[chan *recordEntry]struct{})
+	}
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.subscribers, ch)
+		s.mu.Unlock()
+	}
+}
+
+func (s *recordStore) list() []*recordEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]*recordEntry(nil), s.entries...)
+}
+
+func (s *recordStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.entries)
+}
+
+func (s *recordStore) get(seq int) *recordEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pos := sort.Search(len(s.entries), func(i int) bool {
+		return s.entries[i].Seq >= seq
+	})
+	if pos < len(s.entries) && s.entries[pos].Seq == seq {
+		return s.entries[pos]
+	}
+	return nil
+}
+
+func (s *recordStore) remove(seq int) *recordEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, entry := range s.entries {
+		if entry.Seq == seq {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			return entry
+		}
+	}
+	return nil
+}
+
+func (s *recordStore) clear() []*recordEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.entries
+	s.entries = nil
+	return entries
+}
+
+// fileSeq parses the sequence number prefix of a record file name.
+func fileSeq(name string) (int, bool) {
+	pos := strings.IndexFunc(name, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	if pos <= 0 {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(name[:pos])
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// files lists the attachments of a record.
+func (e *recordEntry) files() []string {
+	var files []string
+	if e.Record.TeeFile != "" {
+		files = append(files, e.Record.TeeFile)
+	}
+	if e.Record.TLS != nil {
+		for _, cert := range e.Record.TLS.ClientCerts {
+			if cert.File != "" {
+				files = append(files, cert.File)
+			}
+		}
+	}
+	files = append(files, bodyFiles(e.Record.Request)...)
+	files = append(files, bodyFiles(e.Record.Response)...)
+	return files
+}
+
+// bodyFiles lists the files holding the body of a request or response.
+func bodyFiles(rr *RequestResponse) []string {
+	if rr == nil {
+		return nil
+	}
+	var files []string
+	if rr.BodyFile != "" {
+		files = append(files, rr.BodyFile)
+	}
+	for _, part := range rr.BodyMultiPart {
+		if part.ContentFile != "" {
+			files = append(files, part.ContentFile)
+		}
+	}
+	return files
+}
+
+// removeFiles deletes the record file and its attachments.
+func (e *recordEntry) removeFiles() error {
+	for _, name := range append([]string{e.File}, e.files()...) {
+		err := os.Remove(filepath.Join(e.Dir, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestRecordStoreLimit(t *testing.T) {
+	s := newRecordStore(3)
+	for _, seq := range []int{1, 2, 4, 5, 3} {
+		s.add(&recordEntry{Seq: seq, Record: &Record{}})
+	}
+
+	var seqs []int
+	for _, entry := range s.list() {
+		seqs = append(seqs, entry.Seq)
+	}
+	if len(seqs) != 3 || seqs[0] != 3 || seqs[1] != 4 || seqs[2] != 5 {
+		t.Errorf("kept %v, want [3 4 5]", seqs)
+	}
+
+	// a record older than the kept ones is not stored
+	s.add(&recordEntry{Seq: 1, Record: &Record{}})
+	if s.get(1) != nil || s.len() != 3 {
+		t.Errorf("old record is kept")
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func tailCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "tail",
+		Usage: "Follow captured requests live",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "admin",
+				Aliases: []string{"a"},
+				Usage:   "Admin address of a running server, e.g. 'localhost:9090'",
+			},
+			&cli.StringFlag{
+				Name:    "dir",
+				Aliases: []string{"d"},
+				Usage:   "Save directory to watch, used when the admin server is not given or not reachable",
+			},
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "Print the records captured before too",
+			},
+			&cli.BoolFlag{
+				Name:    "full",
+				Aliases: []string{"F"},
+				Usage:   "Print full records instead of one line summaries",
+			},
+			&cli.BoolFlag{
+				Name:  "no-color",
+				Usage: "Disable colored output",
+			},
+			&cli.StringFlag{
+				Name:     "listener",
+				Usage:    "Only print requests received by this listener",
+				Category: "filter",
+			},
+			&cli.StringFlag{
+				Name:     "method",
+				Usage:    "Only print requests with this method",
+				Category: "filter",
+			},
+			&cli.StringFlag{
+				Name:     "path",
+				Usage:    "Only print requests whose url contains this text",
+				Category: "filter",
+			},
+			&cli.StringFlag{
+				Name:     "status",
+				Usage:    "Only print requests answered with this status, e.g. '404' or '5xx'",
+				Category: "filter",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.String("admin") == "" && c.String("dir") == "" {
+				return cli.Exit("either --admin or --dir is required", 1)
+			}
+
+			p := &tailPrinter{
+				filter: &recordFilter{
+					Listener: c.String("listener"),
+					Method:   c.String("method"),
+					Path:     c.String("path"),
+					Status:   c.String("status"),
+				},
+				full:  c.Bool("full"),
+				color: !c.Bool("no-color") && isTerminal(os.Stdout),
+			}
+
+			if c.String("admin") != "" {
+				err := tailEvents(c.Context, c.String("admin"), c.Bool("all"), p.print)
+				if err == nil || c.String("dir") == "" {
+					return err
+				}
+				log.Printf("Admin server not reachable, watching directory '%s' instead: %v", c.String("dir"), err)
+			}
+
+			return tailDir(c.Context, c.String("dir"), c.Bool("all"), p.print)
+		},
+	}
+}
+
+// tailEvents follows the event stream of the admin server, reconnecting when
+// the stream breaks. It only returns an error if the first connect fails.
+func tailEvents(ctx context.Context, admin string, all bool, fn func(*recordEntry)) error {
+	uri := strings.TrimSuffix(admin, "/") + "/api/events"
+	if !strings.Contains(uri, "://") {
+		uri = "http://" + uri
+	}
+
+	lastID := ""
+	if all {
+		lastID = "0"
+	}
+
+	everConnected := false
+	for {
+		connected, err := readEvents(ctx, uri, &lastID, fn)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !connected && !everConnected {
+			return err
+		}
+		everConnected = everConnected || connected
+
+		log.Printf("Event stream closed, reconnecting: %v", err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func readEvents(ctx context.Context, uri string, lastID *string, fn func(*recordEntry)) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return false, err
+	}
+	if *lastID != "" {
+		req.Header.Set("Last-Event-ID", *lastID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected response %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+
+	var id string
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if data.Len() > 0 {
+				var entry recordEntry
+				if err := json.Unmarshal([]byte(data.String()), &entry); err != nil {
+					log.Printf("failed to decode event: %v", err)
+				} else if entry.Record != nil {
+					fn(&entry)
+				}
+				*lastID = id
+			}
+			data.Reset()
+		case strings.HasPrefix(line, "id:"):
+			id = strings.TrimSpace(strings.TrimPrefix(line, "id:"))
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, fmt.Errorf("end of stream")
+}
+
+// tailDir polls the save directory for new record files.
+func tailDir(ctx context.Context, dir string, all bool, fn func(*recordEntry)) error {
+	seen := make(map[string]bool)
+	first := true
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		dirs, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("failed to open directory %s: %v", dir, err)
+		}
+
+		for _, d := range dirs {
+			name := d.Name()
+			if d.IsDir() || filepath.Ext(name) != ".json" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			if first && !all {
+				continue
+			}
+
+			seq, ok := fileSeq(name)
+			if !ok {
+				continue
+			}
+
+			var record Record
+			if err := loadRecord(filepath.Join(dir, name), &record); err != nil || record.Method == "" {
+				continue
+			}
+			fn(&recordEntry{Seq: seq, File: name, Dir: dir, Record: &record})
+		}
+		first = false
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+const (
+	colorReset  = "\033[0m"
+	colorBold   = "\033[1m"
+	colorDim    = "\033[2m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorCyan   = "\033[36m"
+)
+
+type tailPrinter struct {
+	filter *recordFilter
+	full   bool
+	color  bool
+}
+
+func (p *tailPrinter) print(entry *recordEntry) {
+	record := entry.Record
+	if !p.filter.match(record) {
+		return
+	}
+
+	t := record.Time
+	if parsed, err := time.Parse(time.RFC3339, record.Time); err == nil {
+		t = parsed.Local().Format("15:04:05")
+	}
+
+	status := "-"
+	if record.status() != 0 {
+		status = fmt.Sprint(record.status())
+	}
+
+	var contentType string
+	if record.Request != nil {
+		contentType = record.Request.Header.Get("Content-Type")
+	}
+
+	if record.Listener != "" {
+		t += " " + record.Listener
+	}
+
+	fmt.Printf("#%04d %s %s %s %s %s\n",
+		entry.Seq,
+		p.paint(colorDim, t),
+		p.paint(colorBold, fmt.Sprintf("%-6s", record.Method)),
+		record.URL,
+		p.paint(statusColor(record.status()), status),
+		p.paint(colorDim, contentType))
+
+	if p.full {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		_ = enc.Encode(record)
+	}
+}
+
+func (p *tailPrinter) paint(color string, s string) string {
+	if !p.color || color == "" {
+		return s
+	}
+	return color + s + colorReset
+}
+
+func statusColor(status int) string {
+	switch {
+	case status >= 500:
+		return colorRed
+	case status >= 400:
+		return colorYellow
+	case status >= 300:
+		return colorCyan
+	case status >= 200:
+		return colorGreen
+	default:
+		return ""
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// teeConfig forwards captured requests to more destinations, the responses
+// of the destinations are only written to the tee side file of the record.
+type teeConfig struct {
+	Targets []string `json:"targets,omitempty"`
+	Queue   int      `json:"queue,omitempty"`
+	Retries int      `json:"retries,omitempty"`
+	Timeout string   `json:"timeout,omitempty"`
+}
+
+// teeResult is one line of the tee side file.
+type teeResult struct {
+	Target   string `json:"target"`
+	Time     string `json:"time"`
+	Status   int    `json:"status,omitempty"`
+	Attempts int    `json:"attempts"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+type teeJob struct {
+	record   *Record
+	dir      string
+	filename string
+}
+
+type teeTarget struct {
+	uri   *url.URL
+	queue chan *teeJob
+}
+
+type tee struct {
+	targets []*teeTarget
+	retries int
+	backoff time.Duration
+	client  *http.Client
+
+	// serializes writes to the side files
+	mu sync.Mutex
+}
+
+func newTee(cfg *teeConfig) (*tee, error) {
+	if cfg == nil || len(cfg.Targets) == 0 {
+		return nil, nil
+	}
+
+	queue := cfg.Queue
+	if queue <= 0 {
+		queue = 100
+	}
+	timeout := 10 * time.Second
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tee timeout '%s': %s", cfg.Timeout, err)
+		}
+		timeout = d
+	}
+
+	t := &tee{
+		retries: max(cfg.Retries, 0),
+		backoff: 500 * time.Millisecond,
+		client: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	for _, target := range cfg.Targets {
+		uri, err := url.Parse(target)
+		if err != nil || uri.Scheme == "" || uri.Host == "" {
+			return nil, fmt.Errorf("invalid tee url '%s'", target)
+		}
+		tt := &teeTarget{uri: uri, queue: make(chan *teeJob, queue)}
+		t.targets = append(t.targets, tt)
+		go t.worker(tt)
+	}
+
+	log.Printf("Tee requests to %d targets", len(t.targets))
+	return t, nil
+}
+
+// send queues the request for every target, a full queue drops the request
+// instead of blocking the capture.
+func (t *tee) send(record *Record, dir string, filename string) {
+	job := &teeJob{record: cloneRequestRecord(record, dir), dir: dir, filename: filename}
+	for _, tt := range t.targets {
+		select {
+		case tt.queue <- job:
+		default:
+			teeDeliveries.inc(tt.uri.String(), "dropped")
+			t.writeResult(job, &teeResult{
+				Target: tt.uri.String(),
+				Time:   time.Now().Format(time.RFC3339),
+				Error:  "queue full, request dropped",
+			})
+		}
+	}
+}
+
+func (t *tee) worker(tt *teeTarget) {
+	for job := range tt.queue {
+		result := t.deliver(tt, job)
+		if result.Error != "" {
+			teeDeliveries.inc(tt.uri.String(), "failed")
+		} else {
+			teeDeliveries.inc(tt.uri.String(), "ok")
+		}
+		t.writeResult(job, result)
+	}
+}
+
+// deliver sends the request, retrying on connection errors and 5xx.
+func (t *tee) deliver(tt *teeTarget, job *teeJob) *teeResult {
+	start := time.Now()
+	result := &teeResult{Target: tt.uri.String(), Time: start.Format(time.RFC3339)}
+
+	backoff := t.backoff
+	for attempt := 0; attempt <= t.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		result.Attempts = attempt + 1
+
+		status, err := t.do(tt.uri, job)
+		result.Status = status
+		result.Error = ""
+		if err != nil {
+			result.Error = err.Error()
+			continue
+		}
+		if status < 500 {
+			break
+		}
+		result.Error = fmt.Sprintf("server error %d", status)
+	}
+
+	result.Duration = time.Since(start).String()
+	return result
+}
+
+func (t *tee) do(uri *url.URL, job *teeJob) (int, error) {
+	req, err := newRequest(job.record, uri, job.dir)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+func (t *tee) writeResult(job *teeJob, result *teeResult) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, err := os.OpenFile(filepath.Join(job.dir, job.filename), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("failed to write tee result: %v", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Printf("failed to write tee result: %v", err)
+	}
+}
+
+// cloneRequestRecord copies the request part of the record, redaction later
+// changes headers in place and the tee has to send the original ones. Form
+// bodies saved in dir are read now, redaction rewrites their files.
+func cloneRequestRecord(record *Record, dir string) *Record {
+	c := *record
+	c.Response = nil
+	if record.Request == nil {
+		return &c
+	}
+
+	rr := *record.Request
+	rr.Header = slices.Clone(rr.Header)
+	if rr.BodyFile != "" && isContentForm(rr.Header.Get("Content-Type")) {
+		if data, err := os.ReadFile(filepath.Join(dir, rr.BodyFile)); err == nil {
+			rr.Body, rr.BodyFile = string(data), ""
+		}
+	}
+	rr.BodyMultiPart = nil
+	for _, part := range record.Request.BodyMultiPart {
+		p := *part
+		p.Header = slices.Clone(p.Header)
+		if _, ok := formField(&p); ok && p.ContentFile != "" {
+			if data, err := os.ReadFile(filepath.Join(dir, p.ContentFile)); err == nil {
+				p.Content, p.ContentFile = string(data), ""
+			}
+		}
+		rr.BodyMultiPart = append(rr.BodyMultiPart, &p)
+	}
+	c.Request = &rr
+	return &c
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func readTeeResults(t *testing.T, filename string) []*teeResult {
+	t.Helper()
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var results []*teeResult
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		result := &teeResult{}
+		if err := json.Unmarshal(scanner.Bytes(), result); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func TestTeeDeliverRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		retries  int
+		attempts int
+		status   int
+		failed   bool
+	}{
+		{"ok", []int{200}, 2, 1, 200, false},
+		{"client error is not retried", []int{404, 200}, 2, 1, 404, false},
+		{"server error retried", []int{503, 502, 201}, 2, 3, 201, false},
+		{"retries exhausted", []int{500, 500, 500}, 1, 2, 500, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(calls.Add(1)) - 1
+				w.WriteHeader(tt.statuses[min(n, len(tt.statuses)-1)])
+			}))
+			defer server.Close()
+
+			tee, err := newTee(&teeConfig{Targets: []string{server.URL}, Retries: tt.retries})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tee.backoff = time.Millisecond
+
+			job := &teeJob{record: &Record{Method: "POST", URL: "/hook", Request: &RequestResponse{Body: "a"}}, dir: t.TempDir()}
+			result := tee.deliver(tee.targets[0], job)
+			if result.Attempts != tt.attempts || result.Status != tt.status || (result.Error != "") != tt.failed {
+				t.Errorf("result = %+v", result)
+			}
+			if int(calls.Load()) != tt.attempts {
+				t.Errorf("target called %d times, want %d", calls.Load(), tt.attempts)
+			}
+		})
+	}
+
+	// connection errors are retried as well
+	tee, err := newTee(&teeConfig{Targets: []string{"http://127.0.0.1:1"}, Retries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tee.backoff = time.Millisecond
+	result := tee.deliver(tee.targets[0], &teeJob{record: &Record{Method: "GET", URL: "/"}, dir: t.TempDir()})
+	if result.Attempts != 2 || result.Error == "" {
+		t.Errorf("connection error result = %+v", result)
+	}
+}
+
+func TestTeeDropsWhenQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	tee, err := newTee(&teeConfig{Targets: []string{server.URL}, Queue: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	record := &Record{Method: "GET", URL: "/"}
+	// the worker takes the first job and blocks, the second fills the queue
+	tee.send(record, dir, "0001-tee.jsonl")
+	deadline := time.Now().Add(time.Second)
+	for len(tee.targets[0].queue) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	tee.send(record, dir, "0002-tee.jsonl")
+	tee.send(record, dir, "0003-tee.jsonl")
+
+	if _, err := os.Stat(filepath.Join(dir, "0002-tee.jsonl")); err == nil {
+		t.Error("queued request is reported before delivery")
+	}
+	results := readTeeResults(t, filepath.Join(dir, "0003-tee.jsonl"))
+	if len(results) != 1 || results[0].Error != "queue full, request dropped" || results[0].Target != server.URL {
+		t.Errorf("dropped results = %+v", results)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TLSInfo describes the TLS connection a request was received on.
+type TLSInfo struct {
+	Version     string        `json:"version"`
+	CipherSuite string        `json:"cipher_suite"`
+	ServerName  string        `json:"server_name,omitempty"`
+	Verified    bool          `json:"verified,omitempty"`
+	ClientCerts []*ClientCert `json:"client_certs,omitempty"`
+}
+
+// ClientCert is one certificate of the chain presented by the client, the
+// first one is the leaf.
+type ClientCert struct {
+	Subject     string `json:"subject"`
+	Issuer      string `json:"issuer"`
+	Serial      string `json:"serial"`
+	Fingerprint string `json:"fingerprint_sha256"`
+	NotBefore   string `json:"not_before"`
+	NotAfter    string `json:"not_after"`
+	File        string `json:"file,omitempty"`
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":    tls.NoClientCert,
+	"request": tls.RequestClientCert,
+	"require": tls.RequireAnyClientCert,
+	"verify":  tls.RequireAndVerifyClientCert,
+}
+
+// serverTLSConfig sets up client certificate authentication of a listener.
+func (l *listenerConfig) serverTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ClientAuth: clientAuthTypes[l.ClientAuth],
+	}
+	if l.ClientCA != "" {
+		pool, err := loadCertPool(l.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+	}
+	return config, nil
+}
+
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in CA file %s", filename)
+	}
+	return pool, nil
+}
+
+// captureTLS describes the connection state and saves the client certificates
+// next to the record.
+func captureTLS(state *tls.ConnectionState, dir string, basename string) (*TLSInfo, error) {
+	info := &TLSInfo{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ServerName:  state.ServerName,
+		Verified:    len(state.VerifiedChains) > 0,
+	}
+
+	for i, cert := range state.PeerCertificates {
+		sum := sha256.Sum256(cert.Raw)
+		cc := &ClientCert{
+			Subject:     cert.Subject.String(),
+			Issuer:      cert.Issuer.String(),
+			Serial:      cert.SerialNumber.String(),
+			Fingerprint: hex.EncodeToString(sum[:]),
+			NotBefore:   cert.NotBefore.Format(time.RFC3339),
+			NotAfter:    cert.NotAfter.Format(time.RFC3339),
+			File:        fmt.Sprintf("%s-client_cert_%d.pem", basename, i),
+		}
+
+		data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		if err := os.WriteFile(filepath.Join(dir, cc.File), data, 0644); err != nil {
+			return info, fmt.Errorf("failed to save client certificate: %v", err)
+		}
+		info.ClientCerts = append(info.ClientCerts, cc)
+	}
+	return info, nil
+}
+
+// clientTLSConfig loads the client certificate and the CA used to verify the
+// server for the client commands.
+func clientTLSConfig(certFile, keyFile, caFile string, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: insecure}
+
+	if certFile != "" || keyFile != "" {
+		if keyFile == "" {
+			keyFile = certFile
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert creates a certificate signed by parent, or a self signed CA
+// without parent, and writes it and its key as PEM files to dir.
+func testCert(t *testing.T, dir, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), certPem, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := testCert(t, dir, "ca", &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	testCert(t, dir, "server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	testCert(t, dir, "client", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "test client", Organization: []string{"rr"}},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	rec, saveDir := newTestRecorder(t, 0)
+	l := &listenerConfig{HTTPS: true, ClientCA: filepath.Join(dir, "ca.pem")}
+	if err := l.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	serverConfig, err := l.serverTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig.Certificates = []tls.Certificate{serverCert}
+
+	server := httptest.NewUnstartedServer(rec)
+	server.TLS = serverConfig
+	server.StartTLS()
+	defer server.Close()
+
+	// without a client certificate the handshake fails
+	transport, err := clientTransport(newTestContext(t, "--cacert", filepath.Join(dir, "ca.pem")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := (&http.Client{Transport: transport}).Get(server.URL + "/anonymous"); err == nil {
+		resp.Body.Close()
+		t.Error("request without client certificate succeeded")
+	}
+
+	transport, err = clientTransport(newTestContext(t,
+		"--cert", filepath.Join(dir, "client.pem"),
+		"--key", filepath.Join(dir, "client-key.pem"),
+		"--cacert", filepath.Join(dir, "ca.pem")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL + "/secure")
+	if err != nil {
+		t.Fatalf("mutual TLS request: %v", err)
+	}
+	resp.Body.Close()
+
+	entry := rec.store.get(rec.store.currentSeq())
+	if entry == nil || entry.Record.TLS == nil {
+		t.Fatalf("no TLS state recorded: %+v", entry)
+	}
+	info := entry.Record.TLS
+	if !info.Verified || len(info.ClientCerts) == 0 {
+		t.Fatalf("tls info = %+v, want a verified client certificate", info)
+	}
+	if cc := info.ClientCerts[0]; cc.Subject != "CN=test client,O=rr" || cc.Issuer != "CN=test ca" {
+		t.Errorf("client cert subject %q issuer %q", cc.Subject, cc.Issuer)
+	}
+	data, err := os.ReadFile(filepath.Join(saveDir, info.ClientCerts[0].File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(data); block == nil || block.Type != "CERTIFICATE" {
+		t.Errorf("saved client certificate is no PEM certificate")
+	}
+}
+
+func TestClientTLSConfigInvalid(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("no pem"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientTLSConfig(filepath.Join(dir, "missing.pem"), "", "", false); err == nil {
+		t.Error("missing certificate accepted")
+	}
+	if _, err := clientTLSConfig("", "", empty, false); err == nil {
+		t.Error("CA file without certificates accepted")
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The tunnel reverses the connection direction: clients on private machines
+// connect to the server and upgrade to a raw connection, on which the server
+// then sends captured requests as a plain HTTP/1.1 client and reads back the
+// responses. Every client connection carries one request at a time.
+
+const (
+	tunnelProtocol = "request-recorder-tunnel"
+	tunnelPath     = "/tunnel"
+	tunnelWait     = 5 * time.Second
+)
+
+var errTunnelAuth = errors.New("tunnel token rejected")
+
+type tunnelConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+}
+
+// tunnelPool keeps the idle client connections and sends requests over them.
+type tunnelPool struct {
+	idle chan *tunnelConn
+}
+
+// tunnels is shared by the tunnel endpoint and the tunnel responders.
+var tunnels = &tunnelPool{idle: make(chan *tunnelConn, 1024)}
+
+func tunnelHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+tunnelPath, func(w http.ResponseWriter, r *http.Request) {
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			http.Error(w, errTunnelAuth.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !strings.EqualFold(r.Header.Get("Upgrade"), tunnelProtocol) {
+			http.Error(w, "expect upgrade to "+tunnelProtocol, http.StatusBadRequest)
+			return
+		}
+
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = conn.SetDeadline(time.Time{})
+
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: " + tunnelProtocol + "\r\nConnection: Upgrade\r\n\r\n")
+		if err := rw.Flush(); err != nil {
+			conn.Close()
+			return
+		}
+
+		select {
+		case tunnels.idle <- &tunnelConn{conn: conn, rw: rw}:
+			log.Printf("Tunnel client connected from %s", r.RemoteAddr)
+		default:
+			log.Printf("Too many tunnel connections, closing the one from %s", r.RemoteAddr)
+			conn.Close()
+		}
+	})
+	return mux
+}
+
+func serveTunnel(cfg *serverConfig) error {
+	srv := &http.Server{Addr: cfg.TunnelListen, Handler: tunnelHandler(cfg.TunnelToken)}
+	if cfg.TunnelCert != "" {
+		// tunnel connections are hijacked, which only works with HTTP/1.1
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		log.Printf("Starting tunnel endpoint on '%s' (HTTPS)", cfg.TunnelListen)
+		return srv.ListenAndServeTLS(cfg.TunnelCert, cfg.TunnelKey)
+	}
+	log.Printf("Starting tunnel endpoint on '%s'", cfg.TunnelListen)
+	return srv.ListenAndServe()
+}
+
+func (p *tunnelPool) get(ctx context.Context) (*tunnelConn, error) {
+	timer := time.NewTimer(tunnelWait)
+	defer timer.Stop()
+
+	select {
+	case tc := <-p.idle:
+		return tc, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, errors.New("no tunnel client connected")
+	}
+}
+
+func (p *tunnelPool) put(tc *tunnelConn) {
+	select {
+	case p.idle <- tc:
+	default:
+		tc.conn.Close()
+	}
+}
+
+// RoundTrip sends the request through a tunnel client. Idle connections may
+// have been closed by the client meanwhile, the request goes to the next
+// connection only when a closed one is found before the request is written.
+// Once it was written the request may have reached the client, so it is not
+// sent again.
+func (p *tunnelPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	for {
+		tc, err := p.get(req.Context())
+		if err != nil {
+			return nil, err
+		}
+		if err := tc.check(); err != nil {
+			tc.conn.Close()
+			log.Printf("Tunnel connection lost: %v", err)
+			continue
+		}
+
+		out := req.Clone(req.Context())
+		out.Body = io.NopCloser(bytes.NewReader(body))
+		out.ContentLength = int64(len(body))
+
+		err = out.Write(tc.rw)
+		if err == nil {
+			err = tc.rw.Flush()
+		}
+		if err != nil {
+			tc.conn.Close()
+			log.Printf("Tunnel connection lost: %v", err)
+			continue
+		}
+
+		resp, err := http.ReadResponse(tc.rw.Reader, out)
+		if err != nil {
+			tc.conn.Close()
+			return nil, fmt.Errorf("failed to read tunnel response: %w", err)
+		}
+		resp.Body = &tunnelBody{ReadCloser: resp.Body, pool: p, tc: tc, reuse: !resp.Close}
+		return resp, nil
+	}
+}
+
+// check finds idle connections the client closed, an idle connection has
+// nothing to read.
+func (tc *tunnelConn) check() error {
+	if tc.rw.Reader.Buffered() > 0 {
+		return errors.New("unexpected data on idle connection")
+	}
+	if err := tc.conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return err
+	}
+	_, err := tc.rw.Peek(1)
+	if err == nil {
+		return errors.New("unexpected data on idle connection")
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return err
+	}
+	return tc.conn.SetReadDeadline(time.Time{})
+}
+
+// tunnelBody gives the connection back to the pool once the response is read.
+type tunnelBody struct {
+	io.ReadCloser
+	pool  *tunnelPool
+	tc    *tunnelConn
+	reuse bool
+	once  sync.Once
+	eof   bool
+}
+
+func (b *tunnelBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *tunnelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		if b.eof && b.reuse {
+			b.pool.put(b.tc)
+		} else {
+			b.tc.conn.Close()
+		}
+	})
+	return err
+}
+
+func tunnelResponse() http.HandlerFunc {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = pr.In.Host
+			pr.Out.Host = pr.In.Host
+			pr.SetXForwarded()
+		},
+		Transport: tunnels,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("failed to send request through tunnel: %v", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		},
+	}
+	return proxy.ServeHTTP
+}
+
+func tunnelCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "tunnel",
+		Usage: "Receive the requests captured by a remote server and forward them to a local service",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "server",
+				Aliases:  []string{"s"},
+				Usage:    "Tunnel endpoint of the server, e.g. 'https://recorder.example.com:9443'",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "token",
+				Usage:    "Tunnel token of the server",
+				EnvVars:  []string{"TUNNEL_TOKEN"},
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "local",
+				Aliases:  []string{"l"},
+				Usage:    "Local service receiving the requests, e.g. 'localhost:3000' or 'http://localhost:3000'",
+				Required: true,
+			},
+			&cli.IntFlag{
+				Name:    "connections",
+				Aliases: []string{"n"},
+				Usage:   "Number of tunnel connections, i.e. requests handled at the same time",
+				Value:   4,
+			},
+			&cli.BoolFlag{
+				Name:  "insecure",
+				Usage: "Skip SSL verification of the server",
+			},
+			&cli.StringFlag{
+				Name:  "cacert",
+				Usage: "CA file to verify the server certificate",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			server, err := url.Parse(c.String("server"))
+			if err != nil || server.Host == "" {
+				return cli.Exit(fmt.Sprintf("invalid server url '%s'", c.String("server")), 1)
+			}
+			if server.Path == "" || server.Path == "/" {
+				server.Path = tunnelPath
+			}
+
+			local := c.String("local")
+			if !strings.Contains(local, "://") {
+				local = "http://" + local
+			}
+			localURL, err := url.Parse(local)
+			if err != nil || localURL.Host == "" {
+				return cli.Exit(fmt.Sprintf("invalid local url '%s'", c.String("local")), 1)
+			}
+
+			tlsConfig, err := clientTLSConfig("", "", c.String("cacert"), c.Bool("insecure"))
+			if err != nil {
+				return err
+			}
+
+			t := &tunnelClient{
+				server:    server,
+				token:     c.String("token"),
+				local:     localURL,
+				tlsConfig: tlsConfig,
+				transport: &http.Transport{
+					Proxy:              http.ProxyFromEnvironment,
+					DisableCompression: true,
+				},
+			}
+
+			ctx, stop := signal.NotifyContext(c.Context, os.Interrupt)
+			defer stop()
+
+			log.Printf("Forwarding requests of '%s' to '%s'", server.Host, localURL)
+			errs := make(chan error, c.Int("connections"))
+			for i := 0; i < max(c.Int("connections"), 1); i++ {
+				go func() {
+					errs <- t.run(ctx)
+				}()
+			}
+			select {
+			case err := <-errs:
+				return err
+			case <-ctx.Done():
+				return nil
+			}
+		},
+	}
+}
+
+type tunnelClient struct {
+	server    *url.URL
+	token     string
+	local     *url.URL
+	tlsConfig *tls.Config
+	transport *http.Transport
+}
+
+// run keeps one tunnel connection open, reconnecting until the token is
+// rejected or ctx is done.
+func (t *tunnelClient) run(ctx context.Context) error {
+	backoff := time.Second
+	for {
+		conn, br, err := t.connect(ctx)
+		if errors.Is(err, errTunnelAuth) {
+			return err
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("Failed to connect tunnel, retry in %s: %v", backoff, err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, 30*time.Second)
+			continue
+		}
+		backoff = time.Second
+
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		err = t.serve(conn, br)
+		stop()
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !errors.Is(err, io.EOF) {
+			log.Printf("Tunnel connection closed: %v", err)
+		}
+	}
+}
+
+func (t *tunnelClient) connect(ctx context.Context) (net.Conn, *bufio.Reader, error) {
+	addr := t.server.Host
+	if t.server.Port() == "" {
+		if t.server.Scheme == "https" {
+			addr = net.JoinHostPort(t.server.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(t.server.Hostname(), "80")
+		}
+	}
+
+	conn, err := (&net.Dialer{Timeout: 10 * time.Second}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t.server.Scheme == "https" {
+		config := t.tlsConfig.Clone()
+		config.ServerName = t.server.Hostname()
+		config.NextProtos = []string{"http/1.1"}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn = tlsConn
+	}
+
+	req, err := http.NewRequest(http.MethodGet, t.server.String(), nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", tunnelProtocol)
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+		conn.Close()
+		if resp.StatusCode == http.StatusUnauthorized {
+			return nil, nil, errTunnelAuth
+		}
+		return nil, nil, fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	return conn, br, nil
+}
+
+// serve answers the requests sent by the server until the connection closes.
+func (t *tunnelClient) serve(conn net.Conn, br *bufio.Reader) error {
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return err
+		}
+
+		start := time.Now()
+		resp := t.forward(req)
+		_, _ = io.Copy(io.Discard, req.Body)
+
+		// the tunnel connection stays open whatever the local service does
+		resp.Close = false
+		resp.Header.Del("Connection")
+		err = resp.Write(conn)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		log.Printf("%s %s -> %d (%s)", req.Method, req.URL.RequestURI(), resp.StatusCode, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+func (t *tunnelClient) forward(req *http.Request) *http.Response {
+	req.RequestURI = ""
+	req.URL.Scheme = t.local.Scheme
+	req.URL.Host = t.local.Host
+	req.Host = t.local.Host
+	if t.local.Path != "" && t.local.Path != "/" {
+		req.URL.Path = strings.TrimSuffix(t.local.Path, "/") + req.URL.Path
+		req.URL.RawPath = ""
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		msg := fmt.Sprintf("tunnel client failed to reach %s: %v", t.local.Host, err)
+		return &http.Response{
+			StatusCode:    http.StatusBadGateway,
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+			ContentLength: int64(len(msg)),
+			Body:          io.NopCloser(strings.NewReader(msg)),
+		}
+	}
+	return resp
+}
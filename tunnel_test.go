@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTunnelLoopback(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Path", r.URL.RequestURI())
+		w.Write(append([]byte(r.Method+" "), body...))
+	}))
+	defer local.Close()
+
+	endpoint := httptest.NewServer(tunnelHandler("secret"))
+	defer endpoint.Close()
+
+	serverURL, _ := url.Parse(endpoint.URL + tunnelPath)
+	localURL, _ := url.Parse(local.URL)
+	client := &tunnelClient{
+		server:    serverURL,
+		token:     "secret",
+		local:     localURL,
+		tlsConfig: &tls.Config{},
+		transport: &http.Transport{},
+	}
+
+	// a wrong token stops the client
+	wrong := *client
+	wrong.token = "other"
+	if err := wrong.run(context.Background()); err != errTunnelAuth {
+		t.Fatalf("run with wrong token = %v, want %v", err, errTunnelAuth)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		client.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	handler := tunnelResponse()
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "http://app.test/hook?a=1", strings.NewReader("hello")))
+		if w.Code != http.StatusOK || w.Body.String() != "POST hello" || w.Header().Get("X-Path") != "/hook?a=1" {
+			t.Fatalf("response %d %q %v", w.Code, w.Body, w.Header())
+		}
+	}
+}
+
+// pipeTunnel puts a connection into the pool and returns the client side.
+func pipeTunnel(p *tunnelPool) (net.Conn, *bufio.Reader) {
+	server, client := net.Pipe()
+	p.idle <- &tunnelConn{conn: server, rw: bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))}
+	return client, bufio.NewReader(client)
+}
+
+func TestTunnelRoundTripRetry(t *testing.T) {
+	p := &tunnelPool{idle: make(chan *tunnelConn, 4)}
+
+	// a connection the client closed while idle is skipped
+	stale, _ := pipeTunnel(p)
+	stale.Close()
+	conn, br := pipeTunnel(p)
+	go func() {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		io.Copy(io.Discard, req.Body)
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	}()
+
+	req := httptest.NewRequest("POST", "http://app.test/", strings.NewReader("a"))
+	resp, err := p.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Errorf("body = %q", body)
+	}
+	conn.Close()
+	<-p.idle
+
+	// a request lost after it was written is not sent again
+	lost, br := pipeTunnel(p)
+	go func() {
+		http.ReadRequest(br)
+		lost.Close()
+	}()
+	next, nextReader := pipeTunnel(p)
+	defer next.Close()
+	received := make(chan struct{}, 1)
+	go func() {
+		if _, err := http.ReadRequest(nextReader); err == nil {
+			received <- struct{}{}
+		}
+	}()
+
+	req = httptest.NewRequest("POST", "http://app.test/", strings.NewReader("b"))
+	if _, err := p.RoundTrip(req); err == nil {
+		t.Fatal("lost response is not an error")
+	}
+	select {
+	case <-received:
+		t.Error("request is sent again after it was written")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
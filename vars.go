@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	mrand "math/rand/v2"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// placeholders are '${NAME}' or '${NAME:-default}', '$${' is a literal '${'.
+var placeholder = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_.-]*)(?::-([^}]*))?\}`)
+
+// variables resolve the placeholders of records. Names are looked up in the
+// --var values, the env files, the process environment, the built-ins now,
+// uuid and random_int and the defaults of a flow, in this order. Outside of a
+// flow and without --expand, --var or --env the records are sent as saved.
+type variables struct {
+	mu       sync.RWMutex
+	enabled  bool
+	values   map[string]string
+	defaults map[string]string
+}
+
+func loadVariables(envFiles, vars []string, expand bool) (*variables, error) {
+	v := &variables{
+		enabled: expand || len(envFiles) > 0 || len(vars) > 0,
+		values:  make(map[string]string),
+	}
+	for _, name := range envFiles {
+		values, err := readEnvFile(name)
+		if err != nil {
+			return nil, err
+		}
+		for k, value := range values {
+			v.values[k] = value
+		}
+	}
+	for _, kv := range vars {
+		k, value, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid variable '%s', expect 'name=value'", kv)
+		}
+		v.values[k] = value
+	}
+	return v, nil
+}
+
+// readEnvFile reads 'NAME=value' lines, blank lines and '#' comments are
+// skipped, an 'export ' prefix and quotes around the value are removed.
+func readEnvFile(filename string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open env file: %s", err)
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+
+		k, value, ok := strings.Cut(text, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid env file %s line %d", filename, line)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			if value[0] == '"' {
+				if unquoted, err := strconv.Unquote(value); err == nil {
+					value = unquoted
+				} else {
+					value = value[1 : len(value)-1]
+				}
+			} else {
+				value = value[1 : len(value)-1]
+			}
+		}
+		values[k] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read env file %s: %s", filename, err)
+	}
+	return values, nil
+}
+
+func (v *variables) set(name, value string) {
+	v.mu.Lock()
+	v.values[name] = value
+	v.mu.Unlock()
+}
+
+// builtins are resolved once per record, so '${uuid}' is the same in the
+// header and the body of a request.
+type builtins map[string]string
+
+func (b builtins) get(name string) (string, bool) {
+	if value, ok := b[name]; ok {
+		return value, true
+	}
+
+	var value string
+	switch name {
+	case "now":
+		value = time.Now().Format(time.RFC3339)
+	case "uuid":
+		var id [16]byte
+		_, _ = rand.Read(id[:])
+		id[6] = id[6]&0x0f | 0x40
+		id[8] = id[8]&0x3f | 0x80
+		value = fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+	case "random_int":
+		value = strconv.Itoa(mrand.IntN(1 << 31))
+	default:
+		return "", false
+	}
+	b[name] = value
+	return value, true
+}
+
+func (v *variables) lookup(name string, b builtins) (string, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if value, ok := v.values[name]; ok {
+		return value, true
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true
+	}
+	if value, ok := b.get(name); ok {
+		return value, true
+	}
+	value, ok := v.defaults[name]
+	return value, ok
+}
+
+// expand replaces the placeholders of s, escape encodes the values for the
+// place they are put in. Unknown names without a default are left as they
+// are.
+func (v *variables) expand(s string, b builtins, escape func(string) string) string {
+	if !v.enabled || !strings.Contains(s, "${") {
+		return s
+	}
+
+	return placeholder.ReplaceAllStringFunc(s, func(m string) string {
+		if m == "$${" {
+			return "${"
+		}
+		sub := placeholder.FindStringSubmatch(m)
+		value, ok := v.lookup(sub[1], b)
+		if !ok {
+			if !strings.Contains(m, ":-") {
+				return m
+			}
+			value = sub[2]
+		}
+		if escape != nil {
+			value = escape(value)
+		}
+		return value
+	})
+}
+
+// jsonEscape encodes a value for the inside of a JSON string.
+func jsonEscape(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data[1 : len(data)-1])
+}
+
+// expandURL replaces the placeholders of a url, the values are escaped for
+// the path or for the query they are put in.
+func (v *variables) expandURL(s string, b builtins) string {
+	// a '?' in the default of a placeholder does not start the query
+	masked := placeholder.ReplaceAllStringFunc(s, func(m string) string {
+		return strings.Repeat("_", len(m))
+	})
+	i := strings.IndexByte(masked, '?')
+	if i < 0 {
+		return v.expand(s, b, url.PathEscape)
+	}
+	return v.expand(s[:i], b, url.PathEscape) + "?" + v.expand(s[i+1:], b, url.QueryEscape)
+}
+
+// expandRecord returns a copy of record with the placeholders of the url,
+// the headers, the bodies and the multipart contents replaced.
+func (v *variables) expandRecord(record *Record) *Record {
+	if !v.enabled {
+		return record
+	}
+	b := make(builtins)
+	r := *record
+	r.URL = v.expandURL(r.URL, b)
+	if record.Request == nil {
+		return &r
+	}
+
+	rr := *record.Request
+	r.Request = &rr
+	rr.Header = v.expandHeader(rr.Header, b)
+	rr.Body = v.expand(rr.Body, b, nil)
+	if rr.BodyJson != nil {
+		rr.BodyJson = json.RawMessage(v.expand(string(rr.BodyJson), b, jsonEscape))
+	}
+
+	rr.BodyMultiPart = nil
+	for _, part := range record.Request.BodyMultiPart {
+		p := *part
+		p.Header = v.expandHeader(p.Header, b)
+		p.Content = v.expand(p.Content, b, nil)
+		if p.ContentJson != nil {
+			p.ContentJson = json.RawMessage(v.expand(string(p.ContentJson), b, jsonEscape))
+		}
+		rr.BodyMultiPart = append(rr.BodyMultiPart, &p)
+	}
+	return &r
+}
+
+func (v *variables) expandHeader(header Header, b builtins) Header {
+	if header == nil {
+		return nil
+	}
+	expanded := make(Header, len(header))
+	for i, f := range header {
+		expanded[i] = HeaderField{Name: f.Name, Value: v.expand(f.Value, b, nil)}
+	}
+	return expanded
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVariablesExpand(t *testing.T) {
+	t.Setenv("RR_TEST_ENV", "from env")
+
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "test.env")
+	if err := os.WriteFile(envFile, []byte("# comment\nexport HOST=\"example.com\"\nTOKEN='abc'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v, err := loadVariables([]string{envFile}, []string{"TOKEN=xyz"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"https://${HOST}/", "https://example.com/"},
+		{"Bearer ${TOKEN}", "Bearer xyz"},
+		{"${RR_TEST_ENV}", "from env"},
+		{"${UNKNOWN}", "${UNKNOWN}"},
+		{"${UNKNOWN:-fallback}", "fallback"},
+		{"$${HOST}", "${HOST}"},
+		{"no placeholder", "no placeholder"},
+	}
+	for _, tt := range tests {
+		if got := v.expand(tt.in, make(builtins), nil); got != tt.want {
+			t.Errorf("expand(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestVariablesDisabled(t *testing.T) {
+	t.Setenv("RR_TEST_SECRET", "secret")
+
+	v, err := loadVariables(nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &Record{
+		Method:  "POST",
+		URL:     "/${RR_TEST_SECRET}",
+		Request: &RequestResponse{Body: "${uuid} $${x}"},
+	}
+	if got := v.expandRecord(record); got != record {
+		t.Errorf("record changed without variables: %+v", got)
+	}
+
+	// --expand alone resolves the environment and the built-ins
+	v, err = loadVariables(nil, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := v.expandRecord(record)
+	if got.URL != "/secret" || len(got.Request.Body) != 36+5 || got.Request.Body[36:] != " ${x}" {
+		t.Errorf("expanded = %q %q", got.URL, got.Request.Body)
+	}
+}
+
+func TestExpandRecord(t *testing.T) {
+	v, err := loadVariables(nil, []string{"name=a \"b\"", "q=x&y=1/2"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &Record{
+		Method: "POST",
+		URL:    "/users/${name}/${q}?q=${q}&name=${name}&d=${none:-a?b}",
+		Request: &RequestResponse{
+			Header:   Header{{Name: "X-Id", Value: "${uuid}"}},
+			BodyJson: []byte(`{"name":"${name}","id":"${uuid}"}`),
+		},
+	}
+	got := v.expandRecord(record)
+	if want := `/users/a%20%22b%22/x&y=1%2F2?q=x%26y%3D1%2F2&name=a+%22b%22&d=a%3Fb`; got.URL != want {
+		t.Errorf("url = %q, want %q", got.URL, want)
+	}
+	if record.URL != "/users/${name}/${q}?q=${q}&name=${name}&d=${none:-a?b}" {
+		t.Errorf("original record changed: %q", record.URL)
+	}
+	id := got.Request.Header.Get("X-Id")
+	if want := `{"name":"a \"b\"","id":"` + id + `"}`; string(got.Request.BodyJson) != want {
+		t.Errorf("body = %s, want %s", got.Request.BodyJson, want)
+	}
+}
+
+func TestReadEnvFileInvalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "bad.env")
+	if err := os.WriteFile(filename, []byte("NAME=value\nnot a pair\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readEnvFile(filename); err == nil {
+		t.Error("expected an error for a line without '='")
+	}
+}
+
+func TestVariablesPrecedence(t *testing.T) {
+	t.Setenv("RR_TEST_HOST", "env.example.com")
+
+	v, err := loadVariables(nil, []string{"user=cli"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.defaults = map[string]string{"user": "flow", "RR_TEST_HOST": "flow.example.com", "page": "1"}
+	v.set("token", "extracted")
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"${user}", "cli"},
+		{"${RR_TEST_HOST}", "env.example.com"},
+		{"${page}", "1"},
+		{"${token}", "extracted"},
+	}
+	for _, tt := range tests {
+		if got := v.expand(tt.in, make(builtins), nil); got != tt.want {
+			t.Errorf("expand(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
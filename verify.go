@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Verification is the result of checking the webhook signature of a request.
+type Verification struct {
+	Scheme string `json:"scheme"`
+	Valid  bool   `json:"valid"`
+	Error  string `json:"error,omitempty"`
+}
+
+// verifyConfig checks webhook signatures of the requests on path.
+type verifyConfig struct {
+	Path      string `json:"path,omitempty"`
+	Scheme    string `json:"scheme"`
+	Secret    string `json:"secret"`
+	Tolerance string `json:"tolerance,omitempty"`
+	Reject    bool   `json:"reject,omitempty"`
+
+	// generic hmac only
+	Header    string `json:"header,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+
+	tolerance time.Duration
+}
+
+type verifiers []*verifyConfig
+
+const defaultTolerance = 5 * time.Minute
+
+var hmacAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func (vs verifiers) prepare() error {
+	for i, v := range vs {
+		if err := v.prepare(); err != nil {
+			return fmt.Errorf("verify %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+func (v *verifyConfig) prepare() error {
+	switch v.Scheme {
+	case "github", "stripe", "slack":
+	case "hmac":
+		if v.Header == "" {
+			return errors.New("header is required for hmac")
+		}
+		if v.Algorithm == "" {
+			v.Algorithm = "sha256"
+		}
+		if _, ok := hmacAlgorithms[v.Algorithm]; !ok {
+			return fmt.Errorf("unknown algorithm '%s', expect 'sha1', 'sha256' or 'sha512'", v.Algorithm)
+		}
+		switch v.Encoding {
+		case "":
+			v.Encoding = "hex"
+		case "hex", "base64":
+		default:
+			return fmt.Errorf("unknown encoding '%s', expect 'hex' or 'base64'", v.Encoding)
+		}
+	default:
+		return fmt.Errorf("unknown scheme '%s', expect 'github', 'stripe', 'slack' or 'hmac'", v.Scheme)
+	}
+
+	if v.Secret == "" {
+		return errors.New("secret is required")
+	}
+
+	v.tolerance = defaultTolerance
+	if v.Tolerance != "" {
+		d, err := time.ParseDuration(v.Tolerance)
+		if err != nil {
+			return fmt.Errorf("invalid tolerance '%s': %s", v.Tolerance, err)
+		}
+		v.tolerance = d
+	}
+	return nil
+}
+
+// find returns the first verifier of the request path.
+func (vs verifiers) find(r *http.Request) *verifyConfig {
+	for _, v := range vs {
+		if v.Path == "" || matchPath(v.Path, r.URL.Path) {
+			return v
+		}
+	}
+	return nil
+}
+
+// verify checks the signature over the body exactly as received.
+func (v *verifyConfig) verify(header http.Header, body []byte, now time.Time) *Verification {
+	var err error
+	switch v.Scheme {
+	case "github":
+		err = v.verifyGithub(header, body)
+	case "stripe":
+		err = v.verifyStripe(header, body, now)
+	case "slack":
+		err = v.verifySlack(header, body, now)
+	case "hmac":
+		err = v.verifyHmac(header, body)
+	}
+
+	result := &Verification{Scheme: v.Scheme, Valid: err == nil}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (v *verifyConfig) verifyGithub(header http.Header, body []byte) error {
+	signature := header.Get("X-Hub-Signature-256")
+	if signature == "" {
+		return errors.New("missing X-Hub-Signature-256 header")
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+	return checkMac(sha256.New, v.Secret, expected, body)
+}
+
+func (v *verifyConfig) verifyStripe(header http.Header, body []byte, now time.Time) error {
+	signature := header.Get("Stripe-Signature")
+	if signature == "" {
+		return errors.New("missing Stripe-Signature header")
+	}
+
+	var timestamp string
+	var signatures [][]byte
+	for _, item := range strings.Split(signature, ",") {
+		k, val, _ := strings.Cut(strings.TrimSpace(item), "=")
+		switch k {
+		case "t":
+			timestamp = val
+		case "v1":
+			if sig, err := hex.DecodeString(val); err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return errors.New("malformed signature")
+	}
+	if err := v.checkTimestamp(timestamp, now); err != nil {
+		return err
+	}
+
+	payload := append([]byte(timestamp+"."), body...)
+	for _, sig := range signatures {
+		if checkMac(sha256.New, v.Secret, sig, payload) == nil {
+			return nil
+		}
+	}
+	return errors.New("signature mismatch")
+}
+
+func (v *verifyConfig) verifySlack(header http.Header, body []byte, now time.Time) error {
+	signature := header.Get("X-Slack-Signature")
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	if signature == "" || timestamp == "" {
+		return errors.New("missing X-Slack-Signature or X-Slack-Request-Timestamp header")
+	}
+	if err := v.checkTimestamp(timestamp, now); err != nil {
+		return err
+	}
+
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "v0="))
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+	payload := append([]byte("v0:"+timestamp+":"), body...)
+	return checkMac(sha256.New, v.Secret, expected, payload)
+}
+
+func (v *verifyConfig) verifyHmac(header http.Header, body []byte) error {
+	signature := header.Get(v.Header)
+	if signature == "" {
+		return fmt.Errorf("missing %s header", v.Header)
+	}
+	signature = strings.TrimPrefix(signature, v.Prefix)
+
+	var expected []byte
+	var err error
+	if v.Encoding == "base64" {
+		expected, err = base64.StdEncoding.DecodeString(signature)
+	} else {
+		expected, err = hex.DecodeString(signature)
+	}
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+	return checkMac(hmacAlgorithms[v.Algorithm], v.Secret, expected, body)
+}
+
+func (v *verifyConfig) checkTimestamp(timestamp string, now time.Time) error {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("malformed timestamp")
+	}
+	if d := now.Sub(time.Unix(sec, 0)).Abs(); d > v.tolerance {
+		return fmt.Errorf("timestamp outside of tolerance by %s", (d - v.tolerance).Round(time.Second))
+	}
+	return nil
+}
+
+func checkMac(h func() hash.Hash, secret string, expected []byte, payload []byte) error {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(payload)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
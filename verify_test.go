@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testMac(h func() hash.Hash, secret, payload string) []byte {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func TestVerifySignatures(t *testing.T) {
+	const secret = "s3cret"
+	body := `{"event":"ping"}`
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	old := strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10)
+
+	github := "sha256=" + hex.EncodeToString(testMac(sha256.New, secret, body))
+	stripe := func(ts string) string {
+		return "t=" + ts + ",v1=00ff,v1=" + hex.EncodeToString(testMac(sha256.New, secret, ts+"."+body))
+	}
+	slack := func(ts string) string {
+		return "v0=" + hex.EncodeToString(testMac(sha256.New, secret, "v0:"+ts+":"+body))
+	}
+
+	tests := []struct {
+		name   string
+		v      *verifyConfig
+		header map[string]string
+		valid  bool
+	}{
+		{"github", &verifyConfig{Scheme: "github"}, map[string]string{"X-Hub-Signature-256": github}, true},
+		{"github wrong secret", &verifyConfig{Scheme: "github", Secret: "other"}, map[string]string{"X-Hub-Signature-256": github}, false},
+		{"github missing", &verifyConfig{Scheme: "github"}, nil, false},
+		{"github malformed", &verifyConfig{Scheme: "github"}, map[string]string{"X-Hub-Signature-256": "sha256=zz"}, false},
+		{"stripe", &verifyConfig{Scheme: "stripe"}, map[string]string{"Stripe-Signature": stripe(ts)}, true},
+		{"stripe expired", &verifyConfig{Scheme: "stripe"}, map[string]string{"Stripe-Signature": stripe(old)}, false},
+		{"stripe tolerance", &verifyConfig{Scheme: "stripe", Tolerance: "1h"}, map[string]string{"Stripe-Signature": stripe(old)}, true},
+		{"stripe no v1", &verifyConfig{Scheme: "stripe"}, map[string]string{"Stripe-Signature": "t=" + ts}, false},
+		{"slack", &verifyConfig{Scheme: "slack"}, map[string]string{"X-Slack-Signature": slack(ts), "X-Slack-Request-Timestamp": ts}, true},
+		{"slack timestamp changed", &verifyConfig{Scheme: "slack"}, map[string]string{"X-Slack-Signature": slack(ts), "X-Slack-Request-Timestamp": strconv.FormatInt(now.Unix()+1, 10)}, false},
+		{"hmac hex", &verifyConfig{Scheme: "hmac", Header: "X-Sig"},
+			map[string]string{"X-Sig": hex.EncodeToString(testMac(sha256.New, secret, body))}, true},
+		{"hmac sha1 base64 prefix", &verifyConfig{Scheme: "hmac", Header: "X-Sig", Algorithm: "sha1", Encoding: "base64", Prefix: "sha1="},
+			map[string]string{"X-Sig": "sha1=" + base64.StdEncoding.EncodeToString(testMac(sha1.New, secret, body))}, true},
+		{"hmac wrong algorithm", &verifyConfig{Scheme: "hmac", Header: "X-Sig", Algorithm: "sha512"},
+			map[string]string{"X-Sig": hex.EncodeToString(testMac(sha256.New, secret, body))}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.v.Secret == "" {
+				tt.v.Secret = secret
+			}
+			if err := tt.v.prepare(); err != nil {
+				t.Fatal(err)
+			}
+			header := http.Header{}
+			for k, v := range tt.header {
+				header.Set(k, v)
+			}
+			result := tt.v.verify(header, []byte(body), now)
+			if result.Valid != tt.valid {
+				t.Errorf("valid = %v, want %v (%s)", result.Valid, tt.valid, result.Error)
+			}
+			if !result.Valid && result.Error == "" {
+				t.Error("invalid result without error")
+			}
+		})
+	}
+}
+
+func TestVerifyPrepare(t *testing.T) {
+	tests := []*verifyConfig{
+		{Scheme: "gitlab", Secret: "a"},
+		{Scheme: "github"},
+		{Scheme: "hmac", Secret: "a"},
+		{Scheme: "hmac", Secret: "a", Header: "X-Sig", Algorithm: "md5"},
+		{Scheme: "hmac", Secret: "a", Header: "X-Sig", Encoding: "base32"},
+		{Scheme: "slack", Secret: "a", Tolerance: "5"},
+	}
+	for _, v := range tests {
+		if err := v.prepare(); err == nil {
+			t.Errorf("%+v is accepted", v)
+		}
+	}
+}